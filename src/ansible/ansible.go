@@ -0,0 +1,358 @@
+package ansible
+
+import (
+	"backend"
+	"config"
+	"fmt"
+	"inventory"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Ansible is a backend reading Ansible inventory files, both INI and YAML.
+//
+// Ansible groups become inventory groups, nested via children. Groups which
+// have no parents besides "all" become workgroups as well. Tags are taken
+// from "tags" variables and the datacenter from "datacenter" variables of
+// hosts and groups. All the host variables, inherited from groups the way
+// Ansible does it, are exposed as attributes for expression filters
+type Ansible struct {
+	*inventory.Inventory
+	filename string
+}
+
+type group struct {
+	name     string
+	hosts    []string
+	children []string
+	vars     map[string]interface{}
+}
+
+// parsed is an inventory file as is, before mapping onto the xc model
+type parsed struct {
+	groups     map[string]*group
+	groupOrder []string
+	hostVars   map[string]map[string]interface{}
+	hostOrder  []string
+}
+
+const (
+	groupAll       = "all"
+	groupUngrouped = "ungrouped"
+)
+
+func init() {
+	backend.Register("ansible", func(xc *config.XcConfig) (backend.Backend, error) {
+		return New(xc), nil
+	})
+}
+
+// New creates an ansible backend from the xc configuration
+func New(xc *config.XcConfig) *Ansible {
+	return &Ansible{
+		Inventory: inventory.New(&inventory.Data{}),
+		filename:  xc.AnsibleInventory,
+	}
+}
+
+// Load reads and parses the inventory file
+func (a *Ansible) Load() error {
+	contents, err := ioutil.ReadFile(a.filename)
+	if err != nil {
+		return err
+	}
+
+	var p *parsed
+	switch strings.ToLower(filepath.Ext(a.filename)) {
+	case ".yml", ".yaml":
+		p, err = parseYAML(contents)
+	default:
+		p, err = parseINI(contents)
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %s", a.filename, err)
+	}
+	a.Inventory = inventory.New(p.data())
+	return nil
+}
+
+// Reload re-reads the inventory file
+func (a *Ansible) Reload() error {
+	return a.Load()
+}
+
+func newParsed() *parsed {
+	return &parsed{
+		groups:     make(map[string]*group),
+		groupOrder: make([]string, 0),
+		hostVars:   make(map[string]map[string]interface{}),
+		hostOrder:  make([]string, 0),
+	}
+}
+
+func (p *parsed) group(name string) *group {
+	g, found := p.groups[name]
+	if !found {
+		g = &group{name, make([]string, 0), make([]string, 0), make(map[string]interface{})}
+		p.groups[name] = g
+		p.groupOrder = append(p.groupOrder, name)
+	}
+	return g
+}
+
+func (p *parsed) addHost(groupName string, host string, vars map[string]interface{}) {
+	hv, found := p.hostVars[host]
+	if !found {
+		hv = make(map[string]interface{})
+		p.hostVars[host] = hv
+		p.hostOrder = append(p.hostOrder, host)
+	}
+	for k, v := range vars {
+		hv[k] = v
+	}
+	g := p.group(groupName)
+	if !contains(g.hosts, host) {
+		g.hosts = append(g.hosts, host)
+	}
+}
+
+func (p *parsed) addChild(groupName string, child string) {
+	p.group(child)
+	g := p.group(groupName)
+	if !contains(g.children, child) {
+		g.children = append(g.children, child)
+	}
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// parents returns direct parents of every group, "all" excluded
+func (p *parsed) parents() map[string][]string {
+	res := make(map[string][]string)
+	for _, name := range p.groupOrder {
+		if name == groupAll {
+			continue
+		}
+		for _, child := range p.groups[name].children {
+			res[child] = append(res[child], name)
+		}
+	}
+	return res
+}
+
+// hostGroupVars merges variables of the host's groups so the closer
+// groups override the farther ones and "all" has the lowest priority
+func (p *parsed) hostGroupVars(groups []string, parents map[string][]string) map[string]interface{} {
+	chain := make([]string, 0)
+	seen := make(map[string]bool)
+	queue := append([]string{}, groups...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		chain = append(chain, name)
+		queue = append(queue, parents[name]...)
+	}
+
+	vars := make(map[string]interface{})
+	if all, found := p.groups[groupAll]; found {
+		for k, v := range all.vars {
+			vars[k] = v
+		}
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		for k, v := range p.groups[chain[i]].vars {
+			vars[k] = v
+		}
+	}
+	return vars
+}
+
+// data maps the parsed inventory onto the xc inventory model
+func (p *parsed) data() *inventory.Data {
+	data := &inventory.Data{
+		Datacenters: make([]*inventory.Datacenter, 0),
+		WorkGroups:  make([]*inventory.WorkGroup, 0),
+		Groups:      make([]*inventory.Group, 0),
+		Hosts:       make([]*inventory.Host, 0),
+	}
+	parents := p.parents()
+
+	hostGroups := make(map[string][]string)
+	for _, name := range p.groupOrder {
+		if name == groupAll {
+			continue
+		}
+		g := p.groups[name]
+		ig := &inventory.Group{
+			Name:    name,
+			Parents: parents[name],
+			Tags:    listVar(g.vars["tags"]),
+		}
+		if len(ig.Parents) == 0 {
+			ig.WorkGroup = name
+		}
+		data.Groups = append(data.Groups, ig)
+		for _, host := range g.hosts {
+			hostGroups[host] = append(hostGroups[host], name)
+		}
+	}
+
+	for _, fqdn := range p.hostOrder {
+		groups := hostGroups[fqdn]
+		if len(groups) == 0 {
+			// hosts listed in "all" only
+			if _, found := p.groups[groupUngrouped]; !found {
+				p.group(groupUngrouped)
+				data.Groups = append(data.Groups, &inventory.Group{Name: groupUngrouped, WorkGroup: groupUngrouped})
+			}
+			groups = []string{groupUngrouped}
+		}
+
+		vars := p.hostGroupVars(groups, parents)
+		for k, v := range p.hostVars[fqdn] {
+			vars[k] = v
+		}
+
+		host := &inventory.Host{
+			FQDN:       fqdn,
+			Groups:     groups,
+			Tags:       listVar(p.hostVars[fqdn]["tags"]),
+			Attributes: make(map[string]string),
+		}
+		for k, v := range vars {
+			host.Attributes[k] = stringVar(v)
+		}
+		if dc, found := vars["datacenter"]; found {
+			host.Datacenter = stringVar(dc)
+		}
+		if addr, found := vars["ansible_host"]; found && stringVar(addr) != fqdn {
+			host.Aliases = []string{stringVar(addr)}
+		}
+		data.Hosts = append(data.Hosts, host)
+	}
+	return data
+}
+
+func stringVar(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []interface{}:
+		return strings.Join(listVar(value), ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// listVar converts a variable which is either a list
+// or a comma-separated string to a list of strings
+func listVar(v interface{}) []string {
+	res := make([]string, 0)
+	switch value := v.(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range value {
+			res = append(res, stringVar(item))
+		}
+	default:
+		for _, item := range strings.Split(stringVar(value), ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				res = append(res, item)
+			}
+		}
+	}
+	return res
+}
+
+// expandRanges expands Ansible host ranges like web[01:20:2].example.com
+// or db-[a:c]. Multiple ranges in a pattern produce all the combinations
+func expandRanges(pattern string) ([]string, error) {
+	start := strings.Index(pattern, "[")
+	if start < 0 {
+		return []string{pattern}, nil
+	}
+	end := strings.Index(pattern[start:], "]")
+	if end < 0 {
+		return nil, fmt.Errorf("unclosed range in %s", pattern)
+	}
+	end += start
+
+	items, err := expandRange(pattern[start+1 : end])
+	if err != nil {
+		return nil, fmt.Errorf("invalid range in %s: %s", pattern, err)
+	}
+	tails, err := expandRanges(pattern[end+1:])
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0, len(items)*len(tails))
+	for _, item := range items {
+		for _, tail := range tails {
+			res = append(res, pattern[:start]+item+tail)
+		}
+	}
+	return res, nil
+}
+
+func expandRange(spec string) ([]string, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("expected [start:end] or [start:end:step]")
+	}
+
+	step := 1
+	if len(parts) == 3 {
+		s, err := strconv.Atoi(parts[2])
+		if err != nil || s < 1 {
+			return nil, fmt.Errorf("invalid step %s", parts[2])
+		}
+		step = s
+	}
+
+	res := make([]string, 0)
+	from, errFrom := strconv.Atoi(parts[0])
+	to, errTo := strconv.Atoi(parts[1])
+	if errFrom == nil && errTo == nil {
+		// leading zeroes of the start define the width
+		format := "%d"
+		if len(parts[0]) > 1 && parts[0][0] == '0' {
+			format = fmt.Sprintf("%%0%dd", len(parts[0]))
+		}
+		for i := from; i <= to; i += step {
+			res = append(res, fmt.Sprintf(format, i))
+		}
+		return res, nil
+	}
+
+	if isLetter(parts[0]) && isLetter(parts[1]) {
+		for c := parts[0][0]; c <= parts[1][0]; c += byte(step) {
+			res = append(res, string(c))
+			if int(c)+step > 255 {
+				break
+			}
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("range bounds must be both numeric or both single letters")
+}
+
+func isLetter(s string) bool {
+	return len(s) == 1 && (s[0] >= 'a' && s[0] <= 'z' || s[0] >= 'A' && s[0] <= 'Z')
+}
@@ -0,0 +1,192 @@
+package ansible
+
+import (
+	"inventory"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestExpandRanges(t *testing.T) {
+	data := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"web1", []string{"web1"}},
+		{"web[1:3]", []string{"web1", "web2", "web3"}},
+		{"web[8:10].example.com", []string{"web8.example.com", "web9.example.com", "web10.example.com"}},
+		{"web[01:03]", []string{"web01", "web02", "web03"}},
+		{"web[008:010]", []string{"web008", "web009", "web010"}},
+		{"web[0:2]", []string{"web0", "web1", "web2"}},
+		{"web[1:10:4]", []string{"web1", "web5", "web9"}},
+		{"db-[a:c]", []string{"db-a", "db-b", "db-c"}},
+		{"db-[a:e:2]", []string{"db-a", "db-c", "db-e"}},
+		{"[a:b]-[1:2]", []string{"a-1", "a-2", "b-1", "b-2"}},
+		{"web[3:1]", []string{}},
+	}
+	for _, d := range data {
+		hosts, err := expandRanges(d.pattern)
+		if err != nil {
+			t.Errorf("error expanding %q: %s", d.pattern, err)
+			continue
+		}
+		if !reflect.DeepEqual(hosts, d.expected) {
+			t.Errorf("%q expected to expand to %v, got %v", d.pattern, d.expected, hosts)
+		}
+	}
+
+	hosts, err := expandRanges("web[01:20].example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 20 || hosts[0] != "web01.example.com" || hosts[9] != "web10.example.com" || hosts[19] != "web20.example.com" {
+		t.Errorf("web[01:20].example.com expected to expand to web01..web20, got %v", hosts)
+	}
+}
+
+func TestExpandRangesErrors(t *testing.T) {
+	invalid := []string{
+		"web[1:3",
+		"web[1]",
+		"web[1:2:3:4]",
+		"web[1:3:0]",
+		"web[1:3:x]",
+		"web[a:3]",
+		"web[aa:cc]",
+		"web[1:2][x]",
+	}
+	for _, pattern := range invalid {
+		if hosts, err := expandRanges(pattern); err == nil {
+			t.Errorf("expanding %q must fail, got %v", pattern, hosts)
+		}
+	}
+}
+
+const dataInventory = `
+host0
+
+[web]
+web1 tags=front,nginx
+web2 datacenter=eu-west
+
+[db]
+db1 ansible_host=10.0.0.1
+
+[europe:children]
+web
+db
+
+[europe:vars]
+datacenter=eu
+tags=eu
+
+[all:vars]
+env=prod
+datacenter=global
+`
+
+func TestData(t *testing.T) {
+	p, err := parseINI([]byte(dataInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := p.data()
+
+	groups := make(map[string]*inventory.Group)
+	for _, g := range data.Groups {
+		groups[g.Name] = g
+	}
+	if _, found := groups[groupAll]; found {
+		t.Error("group all is not expected to be an inventory group")
+	}
+	expectedGroups := []*inventory.Group{
+		{Name: "ungrouped", WorkGroup: "ungrouped", Tags: []string{}},
+		{Name: "web", Parents: []string{"europe"}, Tags: []string{}},
+		{Name: "db", Parents: []string{"europe"}, Tags: []string{}},
+		{Name: "europe", WorkGroup: "europe", Tags: []string{"eu"}},
+	}
+	for _, expected := range expectedGroups {
+		if g := groups[expected.Name]; !reflect.DeepEqual(g, expected) {
+			t.Errorf("group %s expected to be %+v, got %+v", expected.Name, expected, g)
+		}
+	}
+
+	expectedHosts := []*inventory.Host{
+		{
+			FQDN:       "host0",
+			Groups:     []string{"ungrouped"},
+			Tags:       []string{},
+			Datacenter: "global",
+			Attributes: map[string]string{"env": "prod", "datacenter": "global"},
+		},
+		{
+			FQDN:       "web1",
+			Groups:     []string{"web"},
+			Tags:       []string{"front", "nginx"},
+			Datacenter: "eu",
+			Attributes: map[string]string{"env": "prod", "datacenter": "eu", "tags": "front,nginx"},
+		},
+		{
+			FQDN:       "web2",
+			Groups:     []string{"web"},
+			Tags:       []string{},
+			Datacenter: "eu-west",
+			Attributes: map[string]string{"env": "prod", "datacenter": "eu-west", "tags": "eu"},
+		},
+		{
+			FQDN:       "db1",
+			Aliases:    []string{"10.0.0.1"},
+			Groups:     []string{"db"},
+			Tags:       []string{},
+			Datacenter: "eu",
+			Attributes: map[string]string{"env": "prod", "datacenter": "eu", "tags": "eu", "ansible_host": "10.0.0.1"},
+		},
+	}
+	if !reflect.DeepEqual(data.Hosts, expectedHosts) {
+		for i, h := range data.Hosts {
+			t.Errorf("host %d: %+v", i, h)
+		}
+		t.Fatal("unexpected hosts")
+	}
+
+	// children groups are resolved to their hosts
+	inv := inventory.New(data)
+	expressions := map[string][]string{
+		"%europe":                  {"db1", "web1", "web2"},
+		"%web":                     {"web1", "web2"},
+		"%ungrouped":               {"host0"},
+		"%europe[env=prod]":        {"db1", "web1", "web2"},
+		"%europe@eu-west":          {"web2"},
+		"%europe#eu":               {"db1", "web1", "web2"},
+		"*[ansible_host=10.0.0.1]": {"db1"},
+	}
+	for expr, expected := range expressions {
+		hosts, err := inv.HostList([]rune(expr))
+		if err != nil {
+			t.Errorf("error resolving %s: %s", expr, err)
+			continue
+		}
+		sort.Strings(hosts)
+		if !reflect.DeepEqual(hosts, expected) {
+			t.Errorf("%s expected to resolve to %v, got %v", expr, expected, hosts)
+		}
+	}
+}
+
+func TestDataAllHosts(t *testing.T) {
+	// hosts listed in "all" only get into the ungrouped group
+	p := newParsed()
+	p.addHost(groupAll, "lonely", map[string]interface{}{"port": 22})
+	data := p.data()
+
+	expected := []*inventory.Group{{Name: "ungrouped", WorkGroup: "ungrouped"}}
+	if !reflect.DeepEqual(data.Groups, expected) {
+		t.Errorf("groups expected to be %+v, got %+v", expected[0], data.Groups)
+	}
+	if len(data.Hosts) != 1 || !reflect.DeepEqual(data.Hosts[0].Groups, []string{"ungrouped"}) {
+		t.Fatalf("lonely host expected to be ungrouped, got %+v", data.Hosts)
+	}
+	if port := data.Hosts[0].Attributes["port"]; port != "22" {
+		t.Errorf("port attribute expected to be 22, got %q", port)
+	}
+}
@@ -0,0 +1,176 @@
+package ansible
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+type sectionType int
+
+const (
+	sectionHosts sectionType = iota
+	sectionChildren
+	sectionVars
+)
+
+// parseINI parses an INI inventory:
+//
+//	host0                          - hosts before any section are ungrouped
+//	[web]
+//	web[01:20].example.com var=1   - hosts with optional variables
+//	[europe:children]
+//	web                            - child groups
+//	[europe:vars]
+//	datacenter=eu                  - group variables
+func parseINI(contents []byte) (*parsed, error) {
+	p := newParsed()
+	currentGroup := groupUngrouped
+	currentSection := sectionHosts
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return nil, fmt.Errorf("invalid section header at line %d", lineNum)
+			}
+			name := line[1 : len(line)-1]
+			currentSection = sectionHosts
+			if ci := strings.LastIndex(name, ":"); ci >= 0 {
+				switch name[ci+1:] {
+				case "children":
+					currentSection = sectionChildren
+				case "vars":
+					currentSection = sectionVars
+				default:
+					return nil, fmt.Errorf("unknown section type %s at line %d", name[ci+1:], lineNum)
+				}
+				name = name[:ci]
+			}
+			if name == "" {
+				return nil, fmt.Errorf("empty group name at line %d", lineNum)
+			}
+			currentGroup = name
+			p.group(currentGroup)
+			continue
+		}
+
+		fields, err := splitFields(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s at line %d", err, lineNum)
+		}
+		if len(fields) == 0 || fields[0] == "" {
+			return nil, fmt.Errorf("empty name at line %d", lineNum)
+		}
+
+		switch currentSection {
+		case sectionChildren:
+			p.addChild(currentGroup, fields[0])
+
+		case sectionVars:
+			key, value, err := splitVar(stripComment(line))
+			if err != nil {
+				return nil, fmt.Errorf("%s at line %d", err, lineNum)
+			}
+			p.group(currentGroup).vars[key] = value
+
+		case sectionHosts:
+			vars := make(map[string]interface{})
+			for _, field := range fields[1:] {
+				key, value, err := splitVar(field)
+				if err != nil {
+					return nil, fmt.Errorf("%s at line %d", err, lineNum)
+				}
+				vars[key] = value
+			}
+			hosts, err := expandRanges(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("%s at line %d", err, lineNum)
+			}
+			for _, host := range hosts {
+				p.addHost(currentGroup, host, vars)
+			}
+		}
+	}
+	return p, scanner.Err()
+}
+
+// splitFields splits a line by whitespace keeping quoted values
+// intact, inline comments are dropped. Empty quotes make an empty field
+func splitFields(line string) ([]string, error) {
+	fields := make([]string, 0)
+	cur := ""
+	quoted := false
+	var quote rune
+	for _, sym := range line {
+		switch {
+		case quote != 0:
+			if sym == quote {
+				quote = 0
+			} else {
+				cur += string(sym)
+			}
+		case sym == '"' || sym == '\'':
+			quote = sym
+			quoted = true
+		case sym == '#' && cur == "" && !quoted:
+			return fields, nil
+		case sym == ' ' || sym == '\t':
+			if cur != "" || quoted {
+				fields = append(fields, cur)
+				cur = ""
+				quoted = false
+			}
+		default:
+			cur += string(sym)
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unclosed quote")
+	}
+	if cur != "" || quoted {
+		fields = append(fields, cur)
+	}
+	return fields, nil
+}
+
+// stripComment cuts an inline comment off a line, a comment starts
+// with # following whitespace outside of quotes
+func stripComment(line string) string {
+	var quote rune
+	prev := ' '
+	for i, sym := range line {
+		switch {
+		case quote != 0:
+			if sym == quote {
+				quote = 0
+			}
+		case sym == '"' || sym == '\'':
+			quote = sym
+		case sym == '#' && (prev == ' ' || prev == '\t'):
+			return strings.TrimSpace(line[:i])
+		}
+		prev = sym
+	}
+	return line
+}
+
+func splitVar(s string) (string, string, error) {
+	ei := strings.Index(s, "=")
+	if ei <= 0 {
+		return "", "", fmt.Errorf("expected key=value, got %s", s)
+	}
+	value := strings.TrimSpace(s[ei+1:])
+	if len(value) > 1 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	return strings.TrimSpace(s[:ei]), value, nil
+}
@@ -0,0 +1,111 @@
+package ansible
+
+import (
+	"reflect"
+	"testing"
+)
+
+const iniInventory = `
+host0
+; a comment
+[web]
+web[1:2].example.com tags=front  # inline comment
+db.example.com description="main database" port=5432
+
+[europe:children]
+web # europe hosts
+
+[europe:vars]
+datacenter=eu # inline comment
+motd="hello # world"
+`
+
+func TestParseINI(t *testing.T) {
+	p, err := parseINI([]byte(iniInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedHosts := []string{"host0", "web1.example.com", "web2.example.com", "db.example.com"}
+	if !reflect.DeepEqual(p.hostOrder, expectedHosts) {
+		t.Errorf("hosts expected to be %v, got %v", expectedHosts, p.hostOrder)
+	}
+	if !reflect.DeepEqual(p.groups[groupUngrouped].hosts, []string{"host0"}) {
+		t.Errorf("host0 expected to be ungrouped, got %v", p.groups[groupUngrouped].hosts)
+	}
+	if !reflect.DeepEqual(p.groups["europe"].children, []string{"web"}) {
+		t.Errorf("europe children expected to be [web], got %v", p.groups["europe"].children)
+	}
+
+	expectedVars := map[string]interface{}{"description": "main database", "port": "5432"}
+	if !reflect.DeepEqual(p.hostVars["db.example.com"], expectedVars) {
+		t.Errorf("db.example.com vars expected to be %v, got %v", expectedVars, p.hostVars["db.example.com"])
+	}
+	expectedVars = map[string]interface{}{"tags": "front"}
+	if !reflect.DeepEqual(p.hostVars["web1.example.com"], expectedVars) {
+		t.Errorf("web1.example.com vars expected to be %v, got %v", expectedVars, p.hostVars["web1.example.com"])
+	}
+	expectedVars = map[string]interface{}{"datacenter": "eu", "motd": "hello # world"}
+	if !reflect.DeepEqual(p.groups["europe"].vars, expectedVars) {
+		t.Errorf("europe vars expected to be %v, got %v", expectedVars, p.groups["europe"].vars)
+	}
+}
+
+func TestParseINIErrors(t *testing.T) {
+	invalid := []string{
+		"[web",
+		"[web:unknown]",
+		"[:vars]",
+		"''",
+		"[web]\n\"\" port=22",
+		"[web:children]\n''",
+		"[web]\nweb1 'unclosed",
+		"[web]\nweb1 novalue",
+		"[web:vars]\n=value",
+	}
+	for _, contents := range invalid {
+		if _, err := parseINI([]byte(contents)); err == nil {
+			t.Errorf("parsing %q must fail", contents)
+		}
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+	data := []struct {
+		line     string
+		expected []string
+	}{
+		{"web1 port=22", []string{"web1", "port=22"}},
+		{"web1\tdescription=\"a b\"", []string{"web1", "description=a b"}},
+		{"web1 # comment", []string{"web1"}},
+		{"web1 motd='# not a comment'", []string{"web1", "motd=# not a comment"}},
+		{"web1 empty=''", []string{"web1", "empty="}},
+		{"'' web1", []string{"", "web1"}},
+	}
+	for _, d := range data {
+		fields, err := splitFields(d.line)
+		if err != nil {
+			t.Errorf("splitting %q: %s", d.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(fields, d.expected) {
+			t.Errorf("%q expected to be split into %q, got %q", d.line, d.expected, fields)
+		}
+	}
+}
+
+func TestStripComment(t *testing.T) {
+	data := map[string]string{
+		"key=value":             "key=value",
+		"key=value # comment":   "key=value",
+		"key=value\t#comment":   "key=value",
+		"key=a#b":               "key=a#b",
+		`key="a # b" # comment`: `key="a # b"`,
+		`key='a # b'`:           `key='a # b'`,
+	}
+	for line, expected := range data {
+		if stripped := stripComment(line); stripped != expected {
+			t.Errorf("%q expected to be stripped to %q, got %q", line, expected, stripped)
+		}
+	}
+}
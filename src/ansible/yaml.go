@@ -0,0 +1,117 @@
+package ansible
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// yamlGroup is a group of a YAML inventory. Hosts and children are kept
+// as yaml.MapSlice to preserve the order they are listed in
+type yamlGroup struct {
+	Hosts    yaml.MapSlice          `yaml:"hosts"`
+	Vars     map[string]interface{} `yaml:"vars"`
+	Children yaml.MapSlice          `yaml:"children"`
+}
+
+// parseYAML parses a YAML inventory:
+//
+//	all:
+//	  children:
+//	    web:
+//	      hosts:
+//	        web[01:20].example.com:
+//	          var: 1
+//	      vars:
+//	        datacenter: eu
+func parseYAML(contents []byte) (*parsed, error) {
+	p := newParsed()
+	root := yaml.MapSlice{}
+	err := yaml.Unmarshal(contents, &root)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range root {
+		err = p.readYAMLGroup(fmt.Sprint(item.Key), item.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *parsed) readYAMLGroup(name string, value interface{}) error {
+	g := p.group(name)
+	if value == nil {
+		return nil
+	}
+
+	// the value is a generic map so it's re-encoded to get it typed
+	raw, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	yg := new(yamlGroup)
+	err = yaml.Unmarshal(raw, yg)
+	if err != nil {
+		return fmt.Errorf("group %s: %s", name, err)
+	}
+
+	for k, v := range yg.Vars {
+		g.vars[k] = normalize(v)
+	}
+
+	for _, item := range yg.Hosts {
+		vars := make(map[string]interface{})
+		if item.Value != nil {
+			hv, ok := normalize(item.Value).(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("group %s: variables of host %v must be a map", name, item.Key)
+			}
+			vars = hv
+		}
+		hosts, err := expandRanges(fmt.Sprint(item.Key))
+		if err != nil {
+			return err
+		}
+		for _, host := range hosts {
+			p.addHost(name, host, vars)
+		}
+	}
+
+	for _, item := range yg.Children {
+		child := fmt.Sprint(item.Key)
+		p.addChild(name, child)
+		err = p.readYAMLGroup(child, item.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalize converts maps yaml.v2 decodes into map[interface{}]interface{}
+// or yaml.MapSlice to map[string]interface{} recursively
+func normalize(v interface{}) interface{} {
+	switch value := v.(type) {
+	case yaml.MapSlice:
+		res := make(map[string]interface{})
+		for _, item := range value {
+			res[fmt.Sprint(item.Key)] = normalize(item.Value)
+		}
+		return res
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{})
+		for k, item := range value {
+			res[fmt.Sprint(k)] = normalize(item)
+		}
+		return res
+	case []interface{}:
+		for i, item := range value {
+			value[i] = normalize(item)
+		}
+		return value
+	default:
+		return v
+	}
+}
@@ -0,0 +1,97 @@
+package ansible
+
+import (
+	"reflect"
+	"testing"
+)
+
+const yamlInventory = `
+all:
+  hosts:
+    lonely:
+  vars:
+    env: prod
+  children:
+    web:
+      hosts:
+        web[01:02].example.com:
+          port: 8080
+          tags: [front, nginx]
+      vars:
+        datacenter: eu
+    europe:
+      children:
+        web:
+        db:
+          hosts:
+            db1:
+`
+
+func TestParseYAML(t *testing.T) {
+	p, err := parseYAML([]byte(yamlInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedHosts := []string{"lonely", "web01.example.com", "web02.example.com", "db1"}
+	if !reflect.DeepEqual(p.hostOrder, expectedHosts) {
+		t.Errorf("hosts expected to be %v, got %v", expectedHosts, p.hostOrder)
+	}
+	expectedGroups := []string{"all", "web", "europe", "db"}
+	if !reflect.DeepEqual(p.groupOrder, expectedGroups) {
+		t.Errorf("groups expected to be %v, got %v", expectedGroups, p.groupOrder)
+	}
+	if !reflect.DeepEqual(p.groups["all"].children, []string{"web", "europe"}) {
+		t.Errorf("all children expected to be [web europe], got %v", p.groups["all"].children)
+	}
+	if !reflect.DeepEqual(p.groups["europe"].children, []string{"web", "db"}) {
+		t.Errorf("europe children expected to be [web db], got %v", p.groups["europe"].children)
+	}
+	if !reflect.DeepEqual(p.groups["all"].hosts, []string{"lonely"}) {
+		t.Errorf("all hosts expected to be [lonely], got %v", p.groups["all"].hosts)
+	}
+
+	expectedVars := map[string]interface{}{"datacenter": "eu"}
+	if !reflect.DeepEqual(p.groups["web"].vars, expectedVars) {
+		t.Errorf("web vars expected to be %v, got %v", expectedVars, p.groups["web"].vars)
+	}
+	expectedVars = map[string]interface{}{"port": 8080, "tags": []interface{}{"front", "nginx"}}
+	if !reflect.DeepEqual(p.hostVars["web02.example.com"], expectedVars) {
+		t.Errorf("web02.example.com vars expected to be %v, got %v", expectedVars, p.hostVars["web02.example.com"])
+	}
+
+	// YAML values become string attributes
+	data := p.data()
+	hosts := make(map[string]map[string]string)
+	for _, h := range data.Hosts {
+		hosts[h.FQDN] = h.Attributes
+	}
+	expectedAttrs := map[string]string{
+		"env":        "prod",
+		"datacenter": "eu",
+		"port":       "8080",
+		"tags":       "front,nginx",
+	}
+	if !reflect.DeepEqual(hosts["web01.example.com"], expectedAttrs) {
+		t.Errorf("web01.example.com attributes expected to be %v, got %v", expectedAttrs, hosts["web01.example.com"])
+	}
+	if !reflect.DeepEqual(hosts["lonely"], map[string]string{"env": "prod"}) {
+		t.Errorf("lonely attributes expected to be map[env:prod], got %v", hosts["lonely"])
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	invalid := []string{
+		"all: [",
+		"all:\n  hosts: [web1, web2]",
+		"all:\n  hosts:\n    web1: 5",
+		"all:\n  children: web",
+		"all:\n  hosts:\n    web[1:2:0]:",
+		"all:\n  children:\n    web:\n      hosts:\n        web[a:3]:",
+	}
+	for _, contents := range invalid {
+		if _, err := parseYAML([]byte(contents)); err == nil {
+			t.Errorf("parsing %q must fail", contents)
+		}
+	}
+}
@@ -1,9 +1,9 @@
 package backend
 
 import (
-	"conductor"
 	"config"
-	"localfile"
+	"hostindex"
+	"time"
 )
 
 type Backend interface {
@@ -14,6 +14,29 @@ type Backend interface {
 	CompleteGroup(line string) []string
 	CompleteWorkGroup(line string) []string
 	CompleteDatacenter(line string) []string
+	DatacenterPath(host string) []string
+}
+
+// Cached is implemented by backends keeping a local copy of the inventory
+// which may be refreshed in background, the state is shown in the prompt.
+// Background refresh is only enabled for the interactive shell
+type Cached interface {
+	CacheAge() time.Duration
+	CacheExpired() bool
+	Refreshing() bool
+	SetBackgroundRefresh(enabled bool)
+}
+
+// HostSearcher is implemented by backends indexing host names, it allows
+// completing hosts by a part of the name when no name starts with it
+type HostSearcher interface {
+	SearchHosts(query string) []hostindex.Match
+}
+
+// Snapshots is implemented by backends keeping the history of inventory data
+type Snapshots interface {
+	Snapshots() ([]string, error)
+	DiffSnapshots(from string, to string) (*InventoryDiff, error)
 }
 
 func Load() error {
@@ -44,13 +67,15 @@ func CompleteDatacenter(line string) []string {
 }
 
 func NewBackend(xc *config.XcConfig) (backend Backend, err error) {
-	switch xc.BackendType {
-	case "localjson":
-		return localfile.NewFromFile(xc), nil
-	case "localini":
-		return localfile.NewFromFile(xc), nil
-	// here default backend for compatibility with prev versions
-	default:
-		return conductor.NewConductor(xc.Conductor), nil
+	if len(xc.Backends) > 0 {
+		return NewMulti(xc)
+	}
+	registryLock.Lock()
+	factory, found := registry[xc.BackendType]
+	if !found {
+		// here default backend for compatibility with prev versions
+		factory = registry[defaultBackendType]
 	}
+	registryLock.Unlock()
+	return factory(xc)
 }
@@ -0,0 +1,30 @@
+package backend
+
+// InventoryDiff describes changes between two inventory snapshots
+type InventoryDiff struct {
+	From          string
+	To            string
+	AddedHosts    []string
+	RemovedHosts  []string
+	AddedGroups   []string
+	RemovedGroups []string
+	// Groups lists groups with hosts added or removed, including
+	// hosts of child groups as a group expression resolves to them
+	Groups []*ListChange
+	// Tags lists hosts with tags added or removed
+	Tags []*ListChange
+}
+
+// ListChange is a change of a list of items belonging to a named object
+type ListChange struct {
+	Name    string
+	Added   []string
+	Removed []string
+}
+
+// Empty reports if there are no changes at all
+func (d *InventoryDiff) Empty() bool {
+	return len(d.AddedHosts) == 0 && len(d.RemovedHosts) == 0 &&
+		len(d.AddedGroups) == 0 && len(d.RemovedGroups) == 0 &&
+		len(d.Groups) == 0 && len(d.Tags) == 0
+}
@@ -0,0 +1,232 @@
+package backend
+
+import (
+	"config"
+	"fmt"
+	"hostindex"
+	"parser"
+	"sort"
+	"strings"
+	"term"
+	"time"
+)
+
+// TokenResolver is implemented by backends able to resolve a single
+// expression token. Backends combined by Multi must implement it
+type TokenResolver interface {
+	ResolveToken(token *parser.Token) ([]string, error)
+}
+
+// Namespaced is implemented by backends combining several named backends
+type Namespaced interface {
+	Backends() []string
+	Backend(name string) (Backend, bool)
+}
+
+// Multi combines several backends configured in main.backends. Tokens
+// prefixed with a backend name like lab:%web are resolved by that backend
+// only, the rest are resolved by all of them and the results are merged
+type Multi struct {
+	names    []string
+	backends map[string]Backend
+}
+
+// NewMulti creates backends listed in the xc configuration
+func NewMulti(xc *config.XcConfig) (*Multi, error) {
+	m := &Multi{make([]string, 0), make(map[string]Backend)}
+	for _, nb := range xc.Backends {
+		registryLock.Lock()
+		factory, found := registry[nb.Config.BackendType]
+		registryLock.Unlock()
+		if !found {
+			return nil, fmt.Errorf("backend %s has unknown type %s", nb.Name, nb.Config.BackendType)
+		}
+		b, err := factory(nb.Config)
+		if err != nil {
+			return nil, fmt.Errorf("error creating backend %s: %s", nb.Name, err)
+		}
+		if _, ok := b.(TokenResolver); !ok {
+			return nil, fmt.Errorf("backend %s of type %s can't be combined with others", nb.Name, nb.Config.BackendType)
+		}
+		m.names = append(m.names, nb.Name)
+		m.backends[nb.Name] = b
+	}
+	parser.SetNamespaces(m.names)
+	return m, nil
+}
+
+// Backends returns names of the combined backends in the configured order
+func (m *Multi) Backends() []string {
+	return m.names
+}
+
+// Backend returns a combined backend by its name
+func (m *Multi) Backend(name string) (Backend, bool) {
+	b, found := m.backends[name]
+	return b, found
+}
+
+// Load loads all the backends. A backend failed to load is reported
+// and skipped, it's an error only if none of them has been loaded
+func (m *Multi) Load() error {
+	loaded := 0
+	for _, name := range m.names {
+		err := m.backends[name].Load()
+		if err != nil {
+			term.Errorf("Error loading backend %s: %s\n", name, err)
+			continue
+		}
+		loaded++
+	}
+	if loaded == 0 && len(m.names) > 0 {
+		return fmt.Errorf("none of the backends has been loaded")
+	}
+	return nil
+}
+
+// Reload reloads all the backends
+func (m *Multi) Reload() error {
+	failed := make([]string, 0)
+	for _, name := range m.names {
+		err := m.backends[name].Reload()
+		if err != nil {
+			term.Errorf("Error reloading backend %s: %s\n", name, err)
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("error reloading %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// HostList resolves an expression
+func (m *Multi) HostList(expr []rune) ([]string, error) {
+	ast, err := parser.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	return ast.Evaluate(m.ResolveToken)
+}
+
+// ResolveToken resolves a token by the backend it's prefixed with,
+// or by all the backends if there's no prefix
+func (m *Multi) ResolveToken(token *parser.Token) ([]string, error) {
+	if token.Backend != "" {
+		b, found := m.backends[token.Backend]
+		if !found {
+			return nil, fmt.Errorf("unknown backend %s", token.Backend)
+		}
+		return b.(TokenResolver).ResolveToken(token)
+	}
+
+	res := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, name := range m.names {
+		hosts, err := m.backends[name].(TokenResolver).ResolveToken(token)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		for _, host := range hosts {
+			if !seen[host] {
+				seen[host] = true
+				res = append(res, host)
+			}
+		}
+	}
+	return res, nil
+}
+
+// complete merges completions of all the backends
+func (m *Multi) complete(complete func(Backend) []string) []string {
+	res := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, name := range m.names {
+		for _, item := range complete(m.backends[name]) {
+			if !seen[item] {
+				seen[item] = true
+				res = append(res, item)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (m *Multi) CompleteHost(line string) []string {
+	return m.complete(func(b Backend) []string { return b.CompleteHost(line) })
+}
+
+func (m *Multi) CompleteGroup(line string) []string {
+	return m.complete(func(b Backend) []string { return b.CompleteGroup(line) })
+}
+
+func (m *Multi) CompleteWorkGroup(line string) []string {
+	return m.complete(func(b Backend) []string { return b.CompleteWorkGroup(line) })
+}
+
+func (m *Multi) CompleteDatacenter(line string) []string {
+	return m.complete(func(b Backend) []string { return b.CompleteDatacenter(line) })
+}
+
+// SearchHosts merges the best host matches of the backends able to search
+func (m *Multi) SearchHosts(query string) []hostindex.Match {
+	lists := make([][]hostindex.Match, 0, len(m.names))
+	for _, name := range m.names {
+		if searcher, ok := m.backends[name].(HostSearcher); ok {
+			lists = append(lists, searcher.SearchHosts(query))
+		}
+	}
+	return hostindex.Merge(lists...)
+}
+
+// DatacenterPath returns the datacenter path of the host
+// from the first backend which knows it
+func (m *Multi) DatacenterPath(host string) []string {
+	for _, name := range m.names {
+		if path := m.backends[name].DatacenterPath(host); len(path) > 0 {
+			return path
+		}
+	}
+	return nil
+}
+
+// CacheAge returns the age of the oldest cache of the backends keeping one
+func (m *Multi) CacheAge() time.Duration {
+	var age time.Duration
+	for _, name := range m.names {
+		if cached, ok := m.backends[name].(Cached); ok && cached.CacheAge() > age {
+			age = cached.CacheAge()
+		}
+	}
+	return age
+}
+
+// CacheExpired reports if any of the backends has an expired cache
+func (m *Multi) CacheExpired() bool {
+	for _, name := range m.names {
+		if cached, ok := m.backends[name].(Cached); ok && cached.CacheExpired() {
+			return true
+		}
+	}
+	return false
+}
+
+// Refreshing reports if any of the backends is being refreshed
+func (m *Multi) Refreshing() bool {
+	for _, name := range m.names {
+		if cached, ok := m.backends[name].(Cached); ok && cached.Refreshing() {
+			return true
+		}
+	}
+	return false
+}
+
+// SetBackgroundRefresh enables background refresh of the backends keeping a cache
+func (m *Multi) SetBackgroundRefresh(enabled bool) {
+	for _, name := range m.names {
+		if cached, ok := m.backends[name].(Cached); ok {
+			cached.SetBackgroundRefresh(enabled)
+		}
+	}
+}
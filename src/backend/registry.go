@@ -0,0 +1,44 @@
+package backend
+
+import (
+	"config"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory creates a backend from the xc configuration
+type Factory func(xc *config.XcConfig) (Backend, error)
+
+const (
+	defaultBackendType = "conductor"
+)
+
+var (
+	registry     = make(map[string]Factory)
+	registryLock sync.Mutex
+)
+
+// Register makes a backend type available for main.backend_type setting.
+// Backends call it from their packages' init functions.
+// Registering the same type twice is a programming error so it panics
+func Register(name string, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, found := registry[name]; found {
+		panic(fmt.Sprintf("backend type %s is already registered", name))
+	}
+	registry[name] = factory
+}
+
+// Registered returns a sorted list of registered backend types
+func Registered() []string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
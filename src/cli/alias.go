@@ -2,13 +2,343 @@ package cli
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"term"
 )
 
+// alias is a command defined by user. Aliases defined with a parameter
+// list like "name(hosts:expr, branch=master, rest...)" take the arguments
+// it describes, the classic ones take any arguments referenced as #1, #2...
 type alias struct {
 	name  string
 	proxy string
+	doc   string
+	// params is nil for classic aliases
+	params []*aliasParam
+	// minArgs is the number of arguments the alias can't be run without
+	minArgs int
+	// exprArgs are positions of arguments being host expressions
+	exprArgs map[int]bool
+}
+
+type aliasParam struct {
+	name       string
+	value      string
+	hasDefault bool
+	expr       bool
+	variadic   bool
+}
+
+var (
+	aliasParamName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// exprCommands are commands taking a host expression as the first argument
+	exprCommands = map[string]bool{
+		"exec": true, "c_exec": true, "s_exec": true, "p_exec": true, "r_exec": true,
+		"runscript": true, "c_runscript": true, "s_runscript": true, "p_runscript": true, "r_runscript": true,
+		"ssh": true, "hostlist": true, "distribute": true,
+	}
+)
+
+// parseAlias parses an alias definition: name[(params)] ["docstring"] command
+func parseAlias(def string) (*alias, error) {
+	def = strings.TrimSpace(def)
+	end := strings.IndexAny(def, "( \t")
+	if end < 0 {
+		end = len(def)
+	}
+	al := &alias{name: def[:end], exprArgs: make(map[int]bool)}
+	if al.name == "" {
+		return nil, fmt.Errorf("alias name is empty")
+	}
+
+	rest := def[end:]
+	if strings.HasPrefix(rest, "(") {
+		var err error
+		al.params, rest, err = parseAliasParams(rest[1:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	rest = strings.TrimSpace(rest)
+	if strings.HasPrefix(rest, `"`) {
+		var err error
+		al.doc, rest, err = readQuoted(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid docstring: %s", err)
+		}
+	}
+	al.proxy = strings.TrimSpace(rest)
+	if al.proxy == "" {
+		return nil, fmt.Errorf("alias %s has no command", al.name)
+	}
+	return al, al.check()
+}
+
+// parseAliasParams parses the parameter list following the opening parenthesis
+// and returns the rest of the definition following the closing one
+func parseAliasParams(s string) ([]*aliasParam, string, error) {
+	params := make([]*aliasParam, 0)
+	s = strings.TrimLeft(s, " \t")
+	if strings.HasPrefix(s, ")") {
+		return params, s[1:], nil
+	}
+
+	for {
+		end := strings.IndexAny(s, ",)=")
+		if end < 0 {
+			return nil, "", fmt.Errorf("parameter list is not closed")
+		}
+		p := &aliasParam{name: strings.TrimSpace(s[:end])}
+		if strings.HasSuffix(p.name, "...") {
+			p.variadic = true
+			p.name = strings.TrimSpace(strings.TrimSuffix(p.name, "..."))
+		}
+		if strings.HasSuffix(p.name, ":expr") {
+			p.expr = true
+			p.name = strings.TrimSpace(strings.TrimSuffix(p.name, ":expr"))
+		}
+		s = s[end:]
+
+		if s[0] == '=' {
+			p.hasDefault = true
+			s = strings.TrimLeft(s[1:], " \t")
+			if strings.HasPrefix(s, `"`) {
+				var err error
+				p.value, s, err = readQuoted(s)
+				if err != nil {
+					return nil, "", fmt.Errorf("invalid default value of %s: %s", p.name, err)
+				}
+				s = strings.TrimLeft(s, " \t")
+			} else {
+				end = strings.IndexAny(s, ",)")
+				if end < 0 {
+					return nil, "", fmt.Errorf("parameter list is not closed")
+				}
+				p.value = strings.TrimSpace(s[:end])
+				s = s[end:]
+			}
+		}
+		params = append(params, p)
+
+		if s == "" {
+			return nil, "", fmt.Errorf("parameter list is not closed")
+		}
+		switch s[0] {
+		case ')':
+			return params, s[1:], nil
+		case ',':
+			s = s[1:]
+		default:
+			return nil, "", fmt.Errorf("unexpected %s after parameter %s", s, p.name)
+		}
+	}
+}
+
+// readQuoted reads a double quoted string with Go escapes
+// from the beginning of s and returns the rest of s
+func readQuoted(s string) (string, string, error) {
+	escaped := false
+	for i := 1; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			value, err := strconv.Unquote(s[:i+1])
+			return value, s[i+1:], err
+		}
+	}
+	return "", "", fmt.Errorf("closing quote is missing")
+}
+
+// check validates the parameters and the references of the command
+func (al *alias) check() error {
+	seen := make(map[string]bool)
+	optional := false
+	for i, p := range al.params {
+		if !aliasParamName.MatchString(p.name) {
+			return fmt.Errorf("invalid parameter name \"%s\"", p.name)
+		}
+		if seen[p.name] {
+			return fmt.Errorf("parameter %s is listed twice", p.name)
+		}
+		seen[p.name] = true
+		if p.variadic && i != len(al.params)-1 {
+			return fmt.Errorf("only the last parameter may take the rest of arguments")
+		}
+		if p.hasDefault || p.variadic {
+			optional = true
+		} else if optional {
+			return fmt.Errorf("parameter %s without a default value follows an optional one", p.name)
+		} else {
+			al.minArgs++
+		}
+		if p.expr {
+			al.exprArgs[i] = true
+		}
+	}
+
+	for _, ref := range aliasRefs(al.proxy) {
+		if n, err := strconv.Atoi(ref.name); err == nil {
+			if al.params == nil && n > al.minArgs {
+				al.minArgs = n
+			}
+			if al.params != nil && n > len(al.params) && !al.variadic() {
+				return fmt.Errorf("#%d is referenced but the alias has %d parameters", n, len(al.params))
+			}
+		} else if ref.braced && ref.name != "*" && !seen[ref.name] {
+			return fmt.Errorf("unknown parameter %s is referenced", ref.name)
+		}
+	}
+	return nil
+}
+
+func (al *alias) variadic() bool {
+	return len(al.params) > 0 && al.params[len(al.params)-1].variadic
+}
+
+// isExpr tells if the argument at the position given is a host expression
+func (al *alias) isExpr(n int) bool {
+	if al.exprArgs[n] {
+		return true
+	}
+	last := len(al.params) - 1
+	return al.variadic() && n > last && al.params[last].expr
+}
+
+// position returns the position of the argument a reference stands for
+func (al *alias) position(ref string) (int, bool) {
+	if n, err := strconv.Atoi(ref); err == nil && n > 0 {
+		return n - 1, true
+	}
+	for i, p := range al.params {
+		if p.name == ref {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// signature returns the alias name with its parameter list
+func (al *alias) signature() string {
+	if al.params == nil {
+		return al.name
+	}
+	params := make([]string, len(al.params))
+	for i, p := range al.params {
+		params[i] = p.name
+		if p.expr {
+			params[i] += ":expr"
+		}
+		if p.variadic {
+			params[i] += "..."
+		}
+		if p.hasDefault {
+			value := p.value
+			if value == "" || strings.ContainsAny(value, " \t,()\"\\") {
+				value = strconv.Quote(value)
+			}
+			params[i] += "=" + value
+		}
+	}
+	return al.name + "(" + strings.Join(params, ", ") + ")"
+}
+
+// definition returns the alias definition as it's given to the alias command
+func (al *alias) definition() string {
+	def := al.signature()
+	if al.doc != "" {
+		def += " " + strconv.Quote(al.doc)
+	}
+	return def + " " + al.proxy
+}
+
+// usage describes the arguments of the alias
+func (al *alias) usage() string {
+	if al.params == nil {
+		args := make([]string, al.minArgs)
+		for i := range args {
+			args[i] = fmt.Sprintf("<arg%d>", i+1)
+		}
+		return strings.Join(append(args, "[...]"), " ")
+	}
+	args := make([]string, len(al.params))
+	for i, p := range al.params {
+		arg := "<" + p.name + ">"
+		if p.variadic {
+			arg += "..."
+		}
+		if p.hasDefault || p.variadic {
+			arg = "[" + arg + "]"
+		}
+		args[i] = arg
+	}
+	return strings.Join(args, " ")
+}
+
+type aliasRef struct {
+	name   string
+	braced bool
+	// length is the length of the reference following #
+	length int
+}
+
+// parseRef parses a reference to an argument following #: #*, #N,
+// #name or the braced form #{N}, #{name} which may be followed by text
+func parseRef(s string) (aliasRef, bool) {
+	if s == "" {
+		return aliasRef{}, false
+	}
+	if s[0] == '*' {
+		return aliasRef{"*", false, 1}, true
+	}
+	if s[0] == '{' {
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return aliasRef{}, false
+		}
+		return aliasRef{s[1:end], true, end + 1}, true
+	}
+
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	isNameChar := func(c byte) bool {
+		return c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+	}
+	end := 0
+	if isDigit(s[0]) {
+		for end < len(s) && isDigit(s[end]) {
+			end++
+		}
+	} else {
+		for end < len(s) && isNameChar(s[end]) {
+			end++
+		}
+	}
+	if end == 0 {
+		return aliasRef{}, false
+	}
+	return aliasRef{s[:end], false, end}, true
+}
+
+func aliasRefs(proxy string) []aliasRef {
+	refs := make([]aliasRef, 0)
+	for i := 0; i < len(proxy); i++ {
+		if proxy[i] != '#' {
+			continue
+		}
+		if ref, ok := parseRef(proxy[i+1:]); ok {
+			refs = append(refs, ref)
+			i += ref.length
+		}
+	}
+	return refs
 }
 
 func (c *Cli) removeAlias(name []rune) error {
@@ -18,29 +348,65 @@ func (c *Cli) removeAlias(name []rune) error {
 	}
 	delete(c.aliases, string(name))
 	delete(c.handlers, string(name))
+	delete(c.completer.completers, string(name))
 	c.completer.removeCommand(string(name))
 	return nil
 }
 
-func (c *Cli) createAlias(name []rune, proxy []rune) error {
-	al := &alias{string(name), string(proxy)}
-	if _, found := c.aliases[al.name]; !found {
+func (c *Cli) createAlias(al *alias) error {
+	if al.name == "list" || al.name == "save" {
+		return fmt.Errorf("Can not create alias %s: the name is reserved for alias subcommands", al.name)
+	}
+	_, found := c.aliases[al.name]
+	if !found {
 		for _, cmd := range c.completer.commands {
 			if cmd == al.name {
 				return fmt.Errorf("Can not create alias %s: such command already exists", al.name)
 			}
 		}
+		c.completer.commands = append(c.completer.commands, al.name)
 	}
+	c.inferExprArgs(al)
 	c.aliases[al.name] = al
 	c.handlers[al.name] = c.runAlias
-	c.completer.commands = append(c.completer.commands, string(name))
+	c.completer.completers[al.name] = c.completer.completeAlias(al)
 	return nil
 }
 
+// isExprArg tells if the argument of a command at the position given is a host expression
+func (c *Cli) isExprArg(cmd string, n int) bool {
+	if al, found := c.aliases[cmd]; found {
+		return al.isExpr(n)
+	}
+	return n == 0 && exprCommands[cmd]
+}
+
+// inferExprArgs marks the arguments of an alias passed as
+// host expressions to the command it runs
+func (c *Cli) inferExprArgs(al *alias) {
+	cmd, rest := wsSplit([]rune(al.proxy))
+	if rest == nil {
+		return
+	}
+	for i, token := range exprWhiteSpace.Split(string(rest), -1) {
+		if !c.isExprArg(string(cmd), i) || !strings.HasPrefix(token, "#") {
+			continue
+		}
+		ref, ok := parseRef(token[1:])
+		if !ok || ref.length != len(token)-1 {
+			continue
+		}
+		if pos, found := al.position(ref.name); found {
+			al.exprArgs[pos] = true
+		}
+	}
+}
+
 func (c *Cli) runAlias(name string, argsLine string, args ...string) {
 	c.aliasRecursionCount--
 	if c.aliasRecursionCount < 0 {
 		term.Errorf("Maximum recursion reached for alias referencing\n")
+		c.fail(ExitError)
 		return
 	}
 
@@ -53,6 +419,7 @@ func (c *Cli) runAlias(name string, argsLine string, args ...string) {
 	cmdLine, err := exterpolate(al, argsLine, args...)
 	if err != nil {
 		term.Errorf("Error running alias %s: %s\n", al.name, err)
+		c.fail(ExitError)
 		return
 	}
 	c.OneCmd(cmdLine)
@@ -61,43 +428,206 @@ func (c *Cli) runAlias(name string, argsLine string, args ...string) {
 func (c *Cli) doAlias(name string, argsLine string, args ...string) {
 	aliasName, rest := wsSplit([]rune(argsLine))
 	if len(aliasName) == 0 {
-		term.Errorf("Usage: alias <alias_name> <command> [...args]\n")
+		term.Errorf("Usage: alias <alias_name>[(<params>)] [\"<docstring>\"] <command> [...args]\n")
 		return
 	}
 
-	if rest == nil || len(rest) == 0 {
+	if len(rest) == 0 && !strings.ContainsRune(string(aliasName), '(') {
+		switch string(aliasName) {
+		case "list":
+			c.listAliases()
+			return
+		case "save":
+			err := c.saveAliases()
+			if err != nil {
+				term.Errorf("Error saving aliases: %s\n", err)
+				c.fail(ExitError)
+				return
+			}
+			term.Successf("%d aliases saved to %s\n", len(c.aliases), c.rcfile)
+			return
+		}
+
 		err := c.removeAlias(aliasName)
 		if err != nil {
 			term.Errorf("Error removing alias %s: %s\n", string(aliasName), err)
 		}
-	} else {
-		err := c.createAlias(aliasName, rest)
-		if err != nil {
-			term.Errorf("Error creating alias %s: %s\n", string(aliasName), err)
+		return
+	}
+
+	al, err := parseAlias(argsLine)
+	if err == nil {
+		err = c.createAlias(al)
+	}
+	if err != nil {
+		term.Errorf("Error creating alias %s: %s\n", string(aliasName), err)
+		c.fail(ExitError)
+	}
+}
+
+// bindArgs returns the values of alias parameters for the arguments given
+func bindArgs(al *alias, argsLine string, args []string) (map[string]string, error) {
+	if len(args) < al.minArgs {
+		return nil, fmt.Errorf("%d arguments given while at least %d needed, usage: %s %s",
+			len(args), al.minArgs, al.name, al.usage())
+	}
+	values := make(map[string]string)
+	if al.params == nil {
+		return values, nil
+	}
+	if len(args) > len(al.params) && !al.variadic() {
+		return nil, fmt.Errorf("%d arguments given while at most %d allowed, usage: %s %s",
+			len(args), len(al.params), al.name, al.usage())
+	}
+
+	for i, p := range al.params {
+		switch {
+		case i >= len(args):
+			values[p.name] = p.value
+		case p.variadic:
+			// the rest of arguments is taken as is
+			rest := []rune(argsLine)
+			for j := 0; j < i; j++ {
+				_, rest = wsSplit(rest)
+			}
+			values[p.name] = string(rest)
+		default:
+			values[p.name] = args[i]
 		}
 	}
+	return values, nil
 }
 
 func exterpolate(al *alias, argsLine string, args ...string) (string, error) {
+	values, err := bindArgs(al, argsLine, args)
+	if err != nil {
+		return "", err
+	}
+
 	res := ""
 	for i := 0; i < len(al.proxy); i++ {
-		if i < len(al.proxy)-1 && al.proxy[i] == '#' {
-			an, err := strconv.ParseInt(string(al.proxy[i+1]), 10, 64)
-			if err == nil {
-				argNum := int(an - 1)
-				if argNum >= len(args) {
-					return "", fmt.Errorf("alias needs argument #%d but only %d arguments are given", int(an), len(args))
-				}
-				res += args[argNum]
-				i++
-				continue
-			} else if al.proxy[i+1] == '*' {
-				res += argsLine
-				i++
-				continue
+		if al.proxy[i] != '#' {
+			res += string(al.proxy[i])
+			continue
+		}
+		ref, ok := parseRef(al.proxy[i+1:])
+		if !ok {
+			res += "#"
+			continue
+		}
+
+		if ref.name == "*" {
+			res += argsLine
+		} else if n, err := strconv.Atoi(ref.name); err == nil && n > 0 {
+			switch {
+			case n <= len(args):
+				res += args[n-1]
+			case n <= len(al.params):
+				res += values[al.params[n-1].name]
+			default:
+				return "", fmt.Errorf("alias needs argument #%d but only %d arguments are given", n, len(args))
 			}
+		} else if value, found := values[ref.name]; found {
+			res += value
+		} else {
+			// not a reference, i.e. a comment of a remote command
+			res += "#" + al.proxy[i+1:i+1+ref.length]
 		}
-		res += string(al.proxy[i])
+		i += ref.length
 	}
 	return res, nil
 }
+
+func (c *Cli) aliasNames() []string {
+	names := make([]string, 0, len(c.aliases))
+	for name := range c.aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Cli) listAliases() {
+	if len(c.aliases) == 0 {
+		term.Warnf("No aliases defined\n")
+		return
+	}
+	width := 0
+	for _, al := range c.aliases {
+		if len(al.signature()) > width {
+			width = len(al.signature())
+		}
+	}
+	for _, name := range c.aliasNames() {
+		al := c.aliases[name]
+		sig := al.signature()
+		fmt.Printf("%s%s  %s\n", term.Blue(sig), strings.Repeat(" ", width-len(sig)), al.proxy)
+		if al.doc != "" {
+			fmt.Printf("%s  %s\n", strings.Repeat(" ", width), al.doc)
+		}
+	}
+}
+
+// aliasHelp prints help on an alias
+func (c *Cli) aliasHelp(al *alias) {
+	fmt.Printf("\nAlias: %s %s\n\n", term.Colored(al.name, term.CWhite, true), al.usage())
+	if al.doc != "" {
+		for _, line := range strings.Split(al.doc, "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("    Runs: %s\n", al.proxy)
+	for _, p := range al.params {
+		if p.hasDefault {
+			fmt.Printf("    %s defaults to \"%s\"\n", p.name, p.value)
+		}
+	}
+	fmt.Println()
+}
+
+// saveAliases writes the aliases to the rcfile. Definitions found in it
+// are updated in place, the ones of aliases removed are dropped, new
+// aliases are appended and the rest of the rcfile is kept as is
+func (c *Cli) saveAliases() error {
+	if c.rcfile == "" {
+		return fmt.Errorf("rcfile is not configured, set main.rc_file")
+	}
+	data, err := ioutil.ReadFile(c.rcfile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := make([]string, 0)
+	if text := strings.TrimSuffix(string(data), "\n"); text != "" {
+		lines = strings.Split(text, "\n")
+	}
+
+	saved := make(map[string]bool)
+	res := make([]string, 0, len(lines)+len(c.aliases))
+	for _, line := range lines {
+		cmd, rest := wsSplit([]rune(strings.TrimSpace(line)))
+		if string(cmd) != "alias" {
+			res = append(res, line)
+			continue
+		}
+		first, _ := wsSplit(rest)
+		name := strings.SplitN(string(first), "(", 2)[0]
+		if name == "list" || name == "save" {
+			res = append(res, line)
+			continue
+		}
+		al, found := c.aliases[name]
+		if !found || saved[al.name] {
+			continue
+		}
+		saved[al.name] = true
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		res = append(res, indent+"alias "+al.definition())
+	}
+	for _, name := range c.aliasNames() {
+		if !saved[name] {
+			res = append(res, "alias "+c.aliases[name].definition())
+		}
+	}
+	return ioutil.WriteFile(c.rcfile, []byte(strings.Join(res, "\n")+"\n"), 0644)
+}
@@ -0,0 +1,233 @@
+package cli
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// splitArgs splits an arguments line the way OneCmd does
+func splitArgs(argsLine string) []string {
+	if argsLine == "" {
+		return []string{}
+	}
+	return exprWhiteSpace.Split(argsLine, -1)
+}
+
+func TestParseAlias(t *testing.T) {
+	data := []struct {
+		def        string
+		definition string
+		doc        string
+		minArgs    int
+		classic    bool
+	}{
+		{"ls exec #1 ls", "ls exec #1 ls", "", 1, true},
+		{"  up   exec #* uptime ", "up exec #* uptime", "", 0, true},
+		{"ten echo #10", "ten echo #10", "", 10, true},
+		{"one echo #{1}0", "one echo #{1}0", "", 1, true},
+		{"up() uptime", "up() uptime", "", 0, false},
+		{
+			`deploy(hosts:expr, branch=master, rest...) "Deploys a branch" exec #hosts git checkout #branch #rest`,
+			`deploy(hosts:expr, branch=master, rest...) "Deploys a branch" exec #hosts git checkout #branch #rest`,
+			"Deploys a branch", 1, false,
+		},
+		{`greet(name="a b, c", x=) echo #name`, `greet(name="a b, c", x="") echo #name`, "", 0, false},
+		{`doc "multi\nline" echo #1`, `doc "multi\nline" echo #1`, "multi\nline", 1, true},
+		{"tail(a, rest...) echo #5", "tail(a, rest...) echo #5", "", 1, false},
+		{"hash(a) echo #a # #x #{a}", "hash(a) echo #a # #x #{a}", "", 1, false},
+	}
+	for _, d := range data {
+		al, err := parseAlias(d.def)
+		if err != nil {
+			t.Errorf("error parsing %q: %s", d.def, err)
+			continue
+		}
+		if def := al.definition(); def != d.definition {
+			t.Errorf("%q expected to be defined as %q, got %q", d.def, d.definition, def)
+		}
+		if al.doc != d.doc {
+			t.Errorf("%q expected to have docstring %q, got %q", d.def, d.doc, al.doc)
+		}
+		if al.minArgs != d.minArgs {
+			t.Errorf("%q expected to need %d arguments, got %d", d.def, d.minArgs, al.minArgs)
+		}
+		if (al.params == nil) != d.classic {
+			t.Errorf("%q classic is expected to be %v", d.def, d.classic)
+		}
+		// the definition parses to the same alias
+		again, err := parseAlias(al.definition())
+		if err != nil || again.definition() != al.definition() {
+			t.Errorf("definition %q doesn't parse back: %v", al.definition(), err)
+		}
+	}
+}
+
+func TestParseAliasErrors(t *testing.T) {
+	invalid := []string{
+		"",
+		"noproxy",
+		"a() ",
+		"a(b echo",
+		"a(b=1 echo",
+		"a(b, b) echo #b",
+		"a(b=1, c) echo #c",
+		"a(b..., c) echo",
+		"a(1b) echo",
+		"a(b c) echo",
+		"a(b) echo #2",
+		"a(b) echo #{c}",
+		"a() echo #1",
+		`a "unclosed echo`,
+		`a(b="x) echo`,
+	}
+	for _, def := range invalid {
+		if al, err := parseAlias(def); err == nil {
+			t.Errorf("parsing %q must fail, got %s", def, al.definition())
+		}
+	}
+}
+
+func TestParseAliasParams(t *testing.T) {
+	data := []struct {
+		s      string
+		params []*aliasParam
+		rest   string
+	}{
+		{") echo", []*aliasParam{}, " echo"},
+		{"  ) echo", []*aliasParam{}, " echo"},
+		{"a) echo", []*aliasParam{{name: "a"}}, " echo"},
+		{" a , b ) echo", []*aliasParam{{name: "a"}, {name: "b"}}, " echo"},
+		{"h:expr, rest...)x", []*aliasParam{{name: "h", expr: true}, {name: "rest", variadic: true}}, "x"},
+		{"h:expr...) x", []*aliasParam{{name: "h", expr: true, variadic: true}}, " x"},
+		{"b=master, c = 1 ) x", []*aliasParam{{name: "b", value: "master", hasDefault: true}, {name: "c", value: "1", hasDefault: true}}, " x"},
+		{`b="a,b)", c="") x`, []*aliasParam{{name: "b", value: "a,b)", hasDefault: true}, {name: "c", hasDefault: true}}, " x"},
+		{`b=) x`, []*aliasParam{{name: "b", hasDefault: true}}, " x"},
+	}
+	for _, d := range data {
+		params, rest, err := parseAliasParams(d.s)
+		if err != nil {
+			t.Errorf("error parsing %q: %s", d.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(params, d.params) {
+			t.Errorf("%q: unexpected parameters %+v", d.s, params)
+		}
+		if rest != d.rest {
+			t.Errorf("%q: rest expected to be %q, got %q", d.s, d.rest, rest)
+		}
+	}
+
+	for _, s := range []string{"", "a", "a, b", "a=1", `a="1`, `a="1" b)`} {
+		if _, _, err := parseAliasParams(s); err == nil {
+			t.Errorf("parsing parameters %q must fail", s)
+		}
+	}
+}
+
+func TestBindArgs(t *testing.T) {
+	data := []struct {
+		def      string
+		argsLine string
+		expected map[string]string
+	}{
+		{"ls exec #1 ls", "%web extra", map[string]string{}},
+		{"up() uptime", "", map[string]string{}},
+		{"d(h, b=master) x", "%web", map[string]string{"h": "%web", "b": "master"}},
+		{"d(h, b=master) x", "%web dev", map[string]string{"h": "%web", "b": "dev"}},
+		{"d(h, rest...) x", "%web", map[string]string{"h": "%web", "rest": ""}},
+		{"d(h, rest...) x", "%web ls  -la   /tmp", map[string]string{"h": "%web", "rest": "ls  -la   /tmp"}},
+		{"d(h, b=1, rest...) x", "%web 2 a b", map[string]string{"h": "%web", "b": "2", "rest": "a b"}},
+	}
+	for _, d := range data {
+		al, err := parseAlias(d.def)
+		if err != nil {
+			t.Fatalf("error parsing %q: %s", d.def, err)
+		}
+		values, err := bindArgs(al, d.argsLine, splitArgs(d.argsLine))
+		if err != nil {
+			t.Errorf("%q with %q: %s", d.def, d.argsLine, err)
+			continue
+		}
+		if !reflect.DeepEqual(values, d.expected) {
+			t.Errorf("%q with %q expected to bind %v, got %v", d.def, d.argsLine, d.expected, values)
+		}
+	}
+}
+
+func TestBindArgsErrors(t *testing.T) {
+	data := []struct {
+		def      string
+		argsLine string
+		err      string
+	}{
+		{"ls exec #1 #2", "%web", "1 arguments given while at least 2 needed, usage: ls <arg1> <arg2> [...]"},
+		{"d(h, b=master) x", "", "0 arguments given while at least 1 needed, usage: d <h> [<b>]"},
+		{"d(h, b=master) x", "1 2 3", "3 arguments given while at most 2 allowed, usage: d <h> [<b>]"},
+		{"up() uptime", "now", "1 arguments given while at most 0 allowed, usage: up "},
+		{"d(h, rest...) x", "", "0 arguments given while at least 1 needed, usage: d <h> [<rest>...]"},
+	}
+	for _, d := range data {
+		al, err := parseAlias(d.def)
+		if err != nil {
+			t.Fatalf("error parsing %q: %s", d.def, err)
+		}
+		_, err = bindArgs(al, d.argsLine, splitArgs(d.argsLine))
+		if err == nil || err.Error() != d.err {
+			t.Errorf("%q with %q expected to fail with %q, got %v", d.def, d.argsLine, d.err, err)
+		}
+	}
+}
+
+func TestExterpolate(t *testing.T) {
+	data := []struct {
+		def      string
+		argsLine string
+		expected string
+	}{
+		// classic aliases
+		{"ls exec #1 ls", "%web", "exec %web ls"},
+		{"ls exec #1 ls", "%web extra", "exec %web ls"},
+		{"sw exec #2 #1", "a b", "exec b a"},
+		{"up exec #* uptime", "%web %db", "exec %web %db uptime"},
+		{"up exec #* uptime", "", "exec  uptime"},
+		{"all echo #1 #*", "a b", "echo a a b"},
+		{"ten echo #10 #{1}0", "a b c d e f g h i j", "echo j a0"},
+		{"one echo #{1}0", "a", "echo a0"},
+		{"sharp echo # #! #1#", "a", "echo # #! a#"},
+		// aliases with parameters
+		{"d(h:expr, b=master) exec #h git checkout #b", "%web", "exec %web git checkout master"},
+		{"d(h:expr, b=master) exec #h git checkout #b", "%web dev", "exec %web git checkout dev"},
+		{"d(h, b=master) exec #1 git checkout #2", "%web", "exec %web git checkout master"},
+		{"d(h, b=master) exec #{h} #{b}_x", "%web dev", "exec %web dev_x"},
+		{`d(h, b="a b") echo #b`, "x", "echo a b"},
+		{"r(h, cmd...) exec #h #cmd", "%web ls  -la", "exec %web ls  -la"},
+		{"r(h, cmd...) exec #h #3", "%web ls -la", "exec %web -la"},
+		{"r(h, cmd...) exec #h #*", "%web ls", "exec %web %web ls"},
+		{"c(h) exec #h echo #hi #h_x # comment", "%web", "exec %web echo #hi #h_x # comment"},
+	}
+	for _, d := range data {
+		al, err := parseAlias(d.def)
+		if err != nil {
+			t.Fatalf("error parsing %q: %s", d.def, err)
+		}
+		res, err := exterpolate(al, d.argsLine, splitArgs(d.argsLine)...)
+		if err != nil {
+			t.Errorf("%q with %q: %s", d.def, d.argsLine, err)
+			continue
+		}
+		if res != d.expected {
+			t.Errorf("%q with %q expected to run %q, got %q", d.def, d.argsLine, d.expected, res)
+		}
+	}
+
+	// a variadic alias referencing an argument which is not given
+	al, err := parseAlias("r(h, cmd...) exec #h #4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = exterpolate(al, "%web ls", "%web", "ls")
+	if err == nil || !strings.Contains(err.Error(), "#4") {
+		t.Errorf("missing argument #4 expected to be reported, got %v", err)
+	}
+}
@@ -7,9 +7,11 @@ import (
 	"executer"
 	"fmt"
 	"io"
+	"journal"
 	"os"
 	"os/exec"
 	"os/signal"
+	"parser"
 	"path/filepath"
 	"regexp"
 	"remote"
@@ -31,11 +33,44 @@ const (
 	execModeSerial execMode = iota
 	execModeParallel
 	execModeCollapse
+	execModeRolling
 
 	maxAliasRecursion = 10
 	maxSSHThreadsSane = 1024
+
+	pseudoGroupFailed = "_failed"
+	pseudoGroupOK     = "_ok"
+)
+
+// Exit codes of non-interactive runs, the worst one of the
+// commands run is returned by ExitCode
+const (
+	ExitOK = iota
+	// ExitHostsFailed means some hosts failed or were skipped
+	ExitHostsFailed
+	// ExitError means a command could not be run at all
+	ExitError
 )
 
+// Options are the settings given on xc's command line. They're applied
+// before the rcfile so its commands run with them, and once again after
+// it so they take precedence over it and the configuration
+type Options struct {
+	Mode  string
+	User  string
+	Raise string
+	// Yes answers yes to confirmations
+	Yes bool
+}
+
+// lastExec keeps the last execution command to be repeated by "retry"
+type lastExec struct {
+	handler string
+	mode    execMode
+	// args is everything following the host expression
+	args string
+}
+
 // Cli represents a commandline interface class
 type Cli struct {
 	rl                  *readline.Instance
@@ -43,12 +78,19 @@ type Cli struct {
 	handlers            map[string]cmdHandler
 	mode                execMode
 	user                string
+	userSet             bool
 	raiseType           remote.RaiseType
 	raisePasswd         string
+	transport           remote.Transport
+	outputFormat        executer.OutputFormat
 	connectTimeout      string
 	curDir              string
 	aliasRecursionCount int
 	delay               int
+	timeout             int
+	batchSize           executer.Threshold
+	batchPause          int
+	maxErrors           executer.Threshold
 	debug               bool
 	aliases             map[string]*alias
 	remoteTmpDir        string
@@ -58,14 +100,34 @@ type Cli struct {
 	sshThreads          int
 	exitConfirm         bool
 	execConfirm         bool
+	assumeYes           bool
+	sshMultiplex        bool
+	sshMultiplexIdle    time.Duration
 
 	outputFileName string
 	outputFile     *os.File
 
+	// configFile and rcfile are the files settings and aliases are saved to
+	configFile string
+	rcfile     string
+
+	// journal records executed commands, nil if it's switched off
+	journal *journal.Journal
+
 	interpreter     string
 	sudoInterpreter string
 	suInterpreter   string
 
+	lastResult *executer.ExecResult
+	lastExec   *lastExec
+	exitCode   int
+
+	// vars are set by "set" and expanded in commands, cmdFailed
+	// tells if the last command run by a script has failed
+	vars        map[string]string
+	stopOnError bool
+	cmdFailed   bool
+
 	backend backend.Backend
 }
 
@@ -75,30 +137,51 @@ var (
 		execModeSerial:   "serial",
 		execModeParallel: "parallel",
 		execModeCollapse: "collapse",
+		execModeRolling:  "rolling",
+	}
+	raiseTypeMap = map[string]remote.RaiseType{
+		"none": remote.RaiseTypeNone,
+		"su":   remote.RaiseTypeSu,
+		"sudo": remote.RaiseTypeSudo,
+	}
+	transportMap = map[remote.Transport]string{
+		remote.TransportOpenSSH: "openssh",
+		remote.TransportNative:  "native",
+	}
+	outputFormatMap = map[executer.OutputFormat]string{
+		executer.OutputFormatText:   "text",
+		executer.OutputFormatJSON:   "json",
+		executer.OutputFormatNDJSON: "ndjson",
 	}
 )
 
-// NewCli creates a new Cli class instance
-func NewCli(cfg *config.XcConfig, bknd backend.Backend) (*Cli, error) {
+// NewCli creates a new Cli class instance, runs the rcfile
+// and applies the command line options given
+func NewCli(cfg *config.XcConfig, bknd backend.Backend, opts *Options) (*Cli, error) {
 	var err error
 	cli := new(Cli)
 	cli.backend = bknd
 	cli.stopped = false
 	cli.aliases = make(map[string]*alias)
+	cli.vars = make(map[string]string)
 	cli.setupCmdHandlers()
 
 	rlConfig := cfg.Readline
 	rlConfig.AutoComplete = cli.completer
+	rlConfig.FuncFilterInputRune = cli.completer.hosts.filterInput
 
 	cli.rl, err = readline.NewEx(rlConfig)
 	if err != nil {
 		return nil, err
 	}
+	cli.completer.hosts.setLine = cli.rl.Operation.SetBuffer
 
 	cli.mode = execModeParallel
-	cli.user = cfg.User
+	cli.setUser(cfg.User, cfg.UserSet)
 	cli.remoteTmpDir = cfg.RemoteTmpdir
 	cli.delay = cfg.Delay
+	cli.timeout = cfg.Timeout
+	cli.batchPause = cfg.BatchPause
 	cli.debug = cfg.Debug
 	cli.progressBar = cfg.ProgressBar
 	cli.prependHostnames = cfg.PrependHostnames
@@ -113,6 +196,18 @@ func NewCli(cfg *config.XcConfig, bknd backend.Backend) (*Cli, error) {
 
 	cli.outputFileName = ""
 	cli.outputFile = nil
+	cli.configFile = cfg.Filename
+	cli.rcfile = cfg.RCfile
+	if cfg.JournalFile != "" {
+		cli.journal, err = journal.Open(cfg.JournalFile)
+		if err != nil {
+			term.Errorf("Error opening journal: %s\n", err)
+		}
+		executer.SetCaptureLimit(cfg.JournalOutput)
+	}
+
+	parser.SetPseudoGroup(pseudoGroupFailed, []string{})
+	parser.SetPseudoGroup(pseudoGroupOK, []string{})
 
 	cli.curDir, err = os.Getwd()
 	if err != nil {
@@ -130,7 +225,10 @@ func NewCli(cfg *config.XcConfig, bknd backend.Backend) (*Cli, error) {
 	executer.SetProgressBar(cli.progressBar)
 	executer.SetRemoteTmpdir(cli.remoteTmpDir)
 	executer.SetPrependHostnames(cli.prependHostnames)
+	executer.SetTimeout(cli.timeout)
 
+	cli.doBatchSize("batch_size", cfg.BatchSize, cfg.BatchSize)
+	cli.doMaxErrors("max_errors", cfg.MaxErrors, cfg.MaxErrors)
 	cli.doRaise("raise", cfg.RaiseType, cfg.RaiseType)
 	cli.doMode("mode", cfg.Mode, cfg.Mode)
 	cli.setPrompt()
@@ -139,7 +237,18 @@ func NewCli(cfg *config.XcConfig, bknd backend.Backend) (*Cli, error) {
 		cli.connectTimeout,
 		cli.connectTimeout,
 	)
+	remote.SetKeyFiles(cfg.SSHKeyFiles)
+	cli.sshMultiplex = cfg.SSHMultiplex
+	cli.sshMultiplexIdle = time.Duration(cfg.SSHMultiplexIdle) * time.Second
+	cli.doTransport("transport", cfg.Transport, cfg.Transport)
+	cli.doOutputFormat("output_format", cfg.OutputFormat, cfg.OutputFormat)
+
+	err = cli.applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
 	cli.runRC(cfg.RCfile)
+	cli.applyOptions(opts)
 
 	return cli, nil
 }
@@ -155,12 +264,25 @@ func (c *Cli) runRC(rcfile string) {
 	}
 	defer f.Close()
 
-	sc := bufio.NewScanner(f)
+	lines, err := readLines(f)
+	if err == nil {
+		var stmts []*statement
+		stmts, err = parseScript(lines)
+		if err == nil {
+			c.runScript(stmts, true)
+			return
+		}
+	}
+	term.Errorf("Error loading rcfile: %s\n", err)
+}
+
+func readLines(r io.Reader) ([]string, error) {
+	lines := make([]string, 0)
+	sc := bufio.NewScanner(r)
 	for sc.Scan() {
-		cmd := sc.Text()
-		fmt.Println(term.Green(cmd))
-		c.OneCmd(cmd)
+		lines = append(lines, sc.Text())
 	}
+	return lines, sc.Err()
 }
 
 func (c *Cli) setupCmdHandlers() {
@@ -170,11 +292,13 @@ func (c *Cli) setupCmdHandlers() {
 	c.handlers["parallel"] = c.doParallel
 	c.handlers["collapse"] = c.doCollapse
 	c.handlers["serial"] = c.doSerial
+	c.handlers["rolling"] = c.doRolling
 	c.handlers["user"] = c.doUser
 	c.handlers["exec"] = c.doExec
 	c.handlers["c_exec"] = c.doCExec
 	c.handlers["s_exec"] = c.doSExec
 	c.handlers["p_exec"] = c.doPExec
+	c.handlers["r_exec"] = c.doRExec
 	c.handlers["hostlist"] = c.doHostlist
 	c.handlers["raise"] = c.doRaise
 	c.handlers["passwd"] = c.doPasswd
@@ -187,9 +311,15 @@ func (c *Cli) setupCmdHandlers() {
 	c.handlers["c_runscript"] = c.doCRunScript
 	c.handlers["p_runscript"] = c.doPRunScript
 	c.handlers["s_runscript"] = c.doSRunScript
+	c.handlers["r_runscript"] = c.doRRunScript
 	c.handlers["delay"] = c.doDelay
+	c.handlers["timeout"] = c.doTimeout
+	c.handlers["batch_size"] = c.doBatchSize
+	c.handlers["batch_pause"] = c.doBatchPause
+	c.handlers["max_errors"] = c.doMaxErrors
 	c.handlers["debug"] = c.doDebug
 	c.handlers["reload"] = c.doReload
+	c.handlers["inventory"] = c.doInventory
 	c.handlers["interpreter"] = c.doInterpreter
 	c.handlers["connect_timeout"] = c.doConnectTimeout
 	c.handlers["progressbar"] = c.doProgressBar
@@ -197,6 +327,15 @@ func (c *Cli) setupCmdHandlers() {
 	c.handlers["help"] = c.doHelp
 	c.handlers["output"] = c.doOutput
 	c.handlers["threads"] = c.doThreads
+	c.handlers["retry"] = c.doRetry
+	c.handlers["transport"] = c.doTransport
+	c.handlers["connections"] = c.doConnections
+	c.handlers["output_format"] = c.doOutputFormat
+	c.handlers["set"] = c.doSet
+	c.handlers["stop_on_error"] = c.doStopOnError
+	c.handlers["settings"] = c.doSettings
+	c.handlers["config"] = c.doConfig
+	c.handlers["journal"] = c.doJournal
 
 	commands := make([]string, len(c.handlers))
 	i := 0
@@ -224,6 +363,9 @@ func (c *Cli) setPrompt() {
 		pr = term.Yellow(pr)
 	case execModeCollapse:
 		pr = term.Green(pr)
+	case execModeRolling:
+		pr = fmt.Sprintf("[Rolling:%s]", c.batchSize)
+		pr = term.Colored(pr, term.CLightMagenta, false)
 	}
 
 	pr += " " + term.Colored(c.user, term.CLightBlue, true)
@@ -248,18 +390,42 @@ func (c *Cli) setPrompt() {
 		pr += term.Colored(rts, rtcolor, rtbold)
 	}
 
+	if cached, ok := c.backend.(backend.Cached); ok {
+		if cached.Refreshing() {
+			pr += term.Colored("[refreshing]", term.CLightMagenta, false)
+		} else if cached.CacheExpired() {
+			pr += term.Red(fmt.Sprintf("[cache %dh]", int(cached.CacheAge().Hours())))
+		}
+	}
+
 	pr += "> "
 	c.rl.SetPrompt(pr)
 }
 
-// CmdLoop reads commands and runs OneCmd
+// CmdLoop reads commands and runs them. Lines of an if or for block
+// are collected until the block is closed and then run at once
 func (c *Cli) CmdLoop() {
+	// persistent connections pay off in an interactive session only,
+	// a single command would spend more time on stopping them at exit
+	if c.sshMultiplex {
+		err := remote.SetMultiplexing(true, c.sshMultiplexIdle)
+		if err != nil {
+			term.Errorf("Error setting up persistent connections: %s\n", err)
+		}
+	}
+
+	var block []string
 	for !c.stopped {
 		// Python cmd-style run setPrompt every time in case something has changed
-		c.setPrompt()
+		if block == nil {
+			c.setPrompt()
+		} else {
+			c.rl.SetPrompt("...> ")
+		}
 
 		line, err := c.rl.Readline()
 		if err == readline.ErrInterrupt {
+			block = nil
 			continue
 		} else if err == io.EOF {
 			if !c.exitConfirm || c.confirm("Are you sure to exit?") {
@@ -267,8 +433,70 @@ func (c *Cli) CmdLoop() {
 			}
 			continue
 		}
-		c.aliasRecursionCount = maxAliasRecursion
-		c.OneCmd(line)
+
+		stmts, err := parseScript(append(block, line))
+		if err == errIncomplete {
+			block = append(block, line)
+			continue
+		}
+		block = nil
+		if err != nil {
+			term.Errorf("%s\n", err)
+			continue
+		}
+		c.runScript(stmts, false)
+	}
+}
+
+// RunBatch runs the script read from r until all of its commands are
+// done, exit is called or a command fails when stop_on_error is on
+func (c *Cli) RunBatch(r io.Reader) error {
+	lines, err := readLines(r)
+	if err != nil {
+		return err
+	}
+	stmts, err := parseScript(lines)
+	if err != nil {
+		return err
+	}
+	c.runScript(stmts, false)
+	return nil
+}
+
+// applyOptions applies the settings given on the command line
+func (c *Cli) applyOptions(opts *Options) error {
+	if opts.Mode != "" {
+		mode, found := parseMode(opts.Mode)
+		if !found {
+			return fmt.Errorf("unknown mode %s", opts.Mode)
+		}
+		c.mode = mode
+	}
+	if opts.User != "" {
+		c.setUser(opts.User, true)
+	}
+	c.assumeYes = opts.Yes
+	if opts.Raise != "" {
+		rt, found := raiseTypeMap[opts.Raise]
+		if !found {
+			return fmt.Errorf("unknown raise type %s", opts.Raise)
+		}
+		c.setRaise(rt)
+	}
+	return nil
+}
+
+// ExitCode returns the exit code reflecting results of the commands run
+func (c *Cli) ExitCode() int {
+	return c.exitCode
+}
+
+// fail marks the command being run as failed and
+// raises the exit code to the one given
+func (c *Cli) fail(code int) {
+	c.cmdFailed = true
+	if code > c.exitCode {
+		c.exitCode = code
 	}
 }
 
@@ -278,7 +506,7 @@ func (c *Cli) OneCmd(line string) {
 	var args []string
 	var argsLine string
 
-	line = strings.Trim(line, " \n\t")
+	line = strings.Trim(c.expandVars(line), " \n\t")
 
 	cmdRunes, rest := wsSplit([]rune(line))
 	cmd := string(cmdRunes)
@@ -299,6 +527,7 @@ func (c *Cli) OneCmd(line string) {
 		handler(cmd, argsLine, args...)
 	} else {
 		term.Errorf("Unknown command: %s\n", cmd)
+		c.fail(ExitError)
 	}
 }
 
@@ -308,6 +537,7 @@ func (c *Cli) Finalize() {
 		c.outputFile.Close()
 		c.outputFile = nil
 	}
+	remote.CloseConnections()
 }
 
 func (c *Cli) doExit(name string, argsLine string, args ...string) {
@@ -316,17 +546,24 @@ func (c *Cli) doExit(name string, argsLine string, args ...string) {
 
 func (c *Cli) doMode(name string, argsLine string, args ...string) {
 	if len(args) < 1 {
-		term.Errorf("Usage: mode <[serial,parallel,collapse]>\n")
+		term.Errorf("Usage: mode <[serial,parallel,collapse,rolling]>\n")
 		return
 	}
 	newMode := args[0]
+	if mode, found := parseMode(newMode); found {
+		c.mode = mode
+		return
+	}
+	term.Errorf("Unknown mode: %s\n", newMode)
+}
+
+func parseMode(name string) (execMode, bool) {
 	for mode, modeStr := range modeMap {
-		if newMode == modeStr {
-			c.mode = mode
-			return
+		if name == modeStr {
+			return mode, true
 		}
 	}
-	term.Errorf("Unknown mode: %s\n", newMode)
+	return 0, false
 }
 
 func (c *Cli) doCollapse(name string, argsLine string, args ...string) {
@@ -341,9 +578,19 @@ func (c *Cli) doSerial(name string, argsLine string, args ...string) {
 	c.doMode("mode", "serial", "serial")
 }
 
+func (c *Cli) doRolling(name string, argsLine string, args ...string) {
+	c.doMode("mode", "rolling", "rolling")
+}
+
 func (c *Cli) doHostlist(name string, argsLine string, args ...string) {
+	showDatacenters := false
+	if len(args) > 0 && args[0] == "-d" {
+		showDatacenters = true
+		args = args[1:]
+	}
+
 	if len(args) < 1 {
-		term.Errorf("Usage: hostlist <inventoree_expr>\n")
+		term.Errorf("Usage: hostlist [-d] <inventoree_expr>\n")
 		return
 	}
 
@@ -376,7 +623,11 @@ func (c *Cli) doHostlist(name string, argsLine string, args ...string) {
 	fmt.Println(term.Green(title))
 	fmt.Println(term.Green(hr))
 	for _, host := range hosts {
-		fmt.Println(host)
+		if showDatacenters {
+			fmt.Printf("%-*s  %s\n", maxlen, host, term.Blue(strings.Join(c.backend.DatacenterPath(host), "/")))
+		} else {
+			fmt.Println(host)
+		}
 	}
 	term.Successf("Total: %d hosts\n", len(hosts))
 }
@@ -388,6 +639,7 @@ func (c *Cli) doexec(mode execMode, argsLine string) {
 	expr, rest := wsSplit([]rune(argsLine))
 	if rest == nil {
 		term.Errorf("Usage: exec <inventoree_expr> commands...\n")
+		c.fail(ExitError)
 		return
 	}
 
@@ -395,33 +647,46 @@ func (c *Cli) doexec(mode execMode, argsLine string) {
 
 	if err != nil {
 		term.Errorf("Error parsing expression %s: %s\n", string(expr), err)
+		c.fail(ExitError)
 		return
 	}
 
 	if len(hosts) == 0 {
 		term.Errorf("Empty hostlist\n")
+		c.fail(ExitError)
 		return
 	}
+	c.completer.hosts.used(hosts)
 
 
-	c.acquirePasswd()
+	if !c.acquirePasswd() {
+		return
+	}
 	cmd := string(rest)
 	executer.SetUser(c.user)
 	executer.SetRaise(c.raiseType)
 	executer.SetPasswd(c.raisePasswd)
 
+	// the banner is skipped when there's nothing to confirm or
+	// when stdout is reserved for json records
+	banner := !c.assumeYes && c.outputFormat == executer.OutputFormatText
 	if c.execConfirm {
-		fmt.Printf("%s\n", term.Yellow(term.HR(len(cmd) + 5)))
-		fmt.Printf("%s\n%s\n\n", term.Yellow("Hosts:"), strings.Join(hosts, ", "))
-		fmt.Printf("%s\n%s\n\n", term.Yellow("Command:"), cmd)
-	    if ! c.confirm("Are you sure?") {
+		if banner {
+			fmt.Printf("%s\n", term.Yellow(term.HR(len(cmd)+5)))
+			fmt.Printf("%s\n%s\n\n", term.Yellow("Hosts:"), strings.Join(hosts, ", "))
+			fmt.Printf("%s\n%s\n\n", term.Yellow("Command:"), cmd)
+		}
+		if !c.confirm("Are you sure?") {
 			return
-	    }
-		fmt.Printf("%s\n\n", term.Yellow(term.HR(len(cmd) + 5)))
+		}
+		if banner {
+			fmt.Printf("%s\n\n", term.Yellow(term.HR(len(cmd)+5)))
+		}
 	}
 
 	executer.WriteOutput(fmt.Sprintf("==== exec %s\n", argsLine))
 
+	started := time.Now()
 	switch mode {
 	case execModeParallel:
 		r = executer.Parallel(hosts, cmd)
@@ -433,7 +698,13 @@ func (c *Cli) doexec(mode execMode, argsLine string) {
 	case execModeSerial:
 		r = executer.Serial(hosts, cmd, c.delay)
 		r.Print()
+	case execModeRolling:
+		r = executer.Rolling(hosts, cmd, c.batchSize, c.batchPause, c.maxErrors)
+		r.Print()
 	}
+	le := &lastExec{"exec", mode, cmd}
+	c.setLastResult(r, le)
+	c.record(le, string(expr), hosts, started, r)
 }
 
 func (c *Cli) doExec(name string, argsLine string, args ...string) {
@@ -452,12 +723,24 @@ func (c *Cli) doPExec(name string, argsLine string, args ...string) {
 	c.doexec(execModeParallel, argsLine)
 }
 
+func (c *Cli) doRExec(name string, argsLine string, args ...string) {
+	c.doexec(execModeRolling, argsLine)
+}
+
 func (c *Cli) doUser(name string, argsLine string, args ...string) {
 	if len(args) < 1 {
 		term.Errorf("Usage: user <username>\n")
 		return
 	}
-	c.user = args[0]
+	c.setUser(args[0], true)
+}
+
+// setUser sets the user to log in to hosts with, an explicit
+// one beats users set for hosts by backends
+func (c *Cli) setUser(user string, explicit bool) {
+	c.user = user
+	c.userSet = explicit
+	remote.SetExplicitUser(explicit)
 }
 
 func (c *Cli) doRaise(name string, argsLine string, args ...string) {
@@ -466,62 +749,64 @@ func (c *Cli) doRaise(name string, argsLine string, args ...string) {
 		return
 	}
 
-	currentRaiseType := c.raiseType
-	rt := args[0]
-	switch rt {
-	case "su":
-		c.raiseType = remote.RaiseTypeSu
-	case "sudo":
-		c.raiseType = remote.RaiseTypeSudo
-	case "none":
-		c.raiseType = remote.RaiseTypeNone
-	default:
-		term.Errorf("Unknown raise type: %s\n", rt)
+	rt, found := raiseTypeMap[args[0]]
+	if !found {
+		term.Errorf("Unknown raise type: %s\n", args[0])
+		return
 	}
+	c.setRaise(rt)
+}
 
-	if c.raiseType != currentRaiseType {
+func (c *Cli) setRaise(rt remote.RaiseType) {
+	if c.raiseType != rt {
 		// Drop passwd in case of changing raise type
 		c.raisePasswd = ""
 	}
+	c.raiseType = rt
 }
 
 func (c *Cli) doPasswd(name string, argsLine string, args ...string) {
-	passwd, err := c.rl.ReadPassword("Set su/sudo password: ")
+	passwd, err := c.readPasswd()
 	if err != nil {
 		term.Errorf("%s\n", err)
+		c.fail(ExitError)
 		return
 	}
-	c.raisePasswd = string(passwd)
+	c.raisePasswd = passwd
 }
 
 func (c *Cli) doSSH(name string, argsLine string, args ...string) {
 	if len(args) < 1 {
 		term.Errorf("Usage: ssh <inventoree_expr>\n")
+		c.fail(ExitError)
 		return
 	}
 
-	c.acquirePasswd()
+	if !c.acquirePasswd() {
+		return
+	}
 	expr, rest := wsSplit([]rune(argsLine))
 
 	hosts, err := c.backend.HostList([]rune(expr))
 	if err != nil {
-		term.Errorf("Error parsing expression %s: %s\n", expr, err)
+		term.Errorf("Error parsing expression %s: %s\n", string(expr), err)
+		c.fail(ExitError)
 		return
 	}
 
 	if len(hosts) == 0 {
 		term.Errorf("Empty hostlist\n")
+		c.fail(ExitError)
 		return
 	}
+	c.completer.hosts.used(hosts)
 
 	executer.SetUser(c.user)
 	executer.SetPasswd(c.raisePasswd)
 	executer.SetRaise(c.raiseType)
-	cmd := string(rest)
-	if len(cmd) > 0 {
-		executer.Serial(hosts, cmd, 0)
-	} else {
-		executer.Serial(hosts, "", 0)
+	r := executer.Serial(hosts, string(rest), 0)
+	if len(r.Error) > 0 {
+		c.fail(ExitHostsFailed)
 	}
 }
 
@@ -573,6 +858,7 @@ func (c *Cli) distributeCheck(argsLine string) (hosts []string, localFilename st
 		err = fmt.Errorf("empty hostlist")
 		return
 	}
+	c.completer.hosts.used(hosts)
 
 	localFilename = string(rest)
 	s, err := os.Stat(localFilename)
@@ -590,27 +876,36 @@ func (c *Cli) distributeCheck(argsLine string) (hosts []string, localFilename st
 func (c *Cli) doDistribute(name string, argsLine string, args ...string) {
 	hosts, localFilename, err := c.distributeCheck(argsLine)
 	if err != nil {
+		c.fail(ExitError)
 		if err.Error() == "usage" {
 			term.Errorf("Usage: distribute <inventoree_expr> filename\n")
 		}
 		return
 	}
 	executer.SetUser(c.user)
+	started := time.Now()
 	r := executer.Distribute(hosts, localFilename, localFilename)
 	r.Print()
+	le := &lastExec{"distribute", c.mode, localFilename}
+	c.setLastResult(r, le)
+	expr, _ := wsSplit([]rune(argsLine))
+	c.record(le, string(expr), hosts, started, r)
 }
 
 func (c *Cli) dorunscript(em execMode, argsLine string) {
 	var r *executer.ExecResult
 	hosts, localFilename, err := c.distributeCheck(argsLine)
 	if err != nil {
+		c.fail(ExitError)
 		if err.Error() == "usage" {
 			term.Errorf("Usage: runscript <inventoree_expr> filename\n")
 		}
 		return
 	}
 
-	c.acquirePasswd()
+	if !c.acquirePasswd() {
+		return
+	}
 	now := time.Now().Format("20060102-150405")
 	remoteFilename := fmt.Sprintf("tmp.xc.%s_%s", now, filepath.Base(localFilename))
 	remoteFilename = filepath.Join(c.remoteTmpDir, remoteFilename)
@@ -619,9 +914,10 @@ func (c *Cli) dorunscript(em execMode, argsLine string) {
 	executer.SetRaise(c.raiseType)
 	executer.SetPasswd(c.raisePasswd)
 
+	started := time.Now()
+	allHosts := hosts
 	er := executer.Distribute(hosts, localFilename, remoteFilename)
 
-	copyError := er.Error
 	hosts = er.Success
 
 	cmd := fmt.Sprintf("%s; rm %s", remoteFilename, remoteFilename)
@@ -632,12 +928,20 @@ func (c *Cli) dorunscript(em execMode, argsLine string) {
 		defer r.Print()
 	case execModeCollapse:
 		r = executer.Collapse(hosts, cmd)
+		defer r.Print()
 		defer r.PrintOutputMap()
 	case execModeSerial:
 		r = executer.Serial(hosts, cmd, c.delay)
 		defer r.Print()
+	case execModeRolling:
+		r = executer.Rolling(hosts, cmd, c.batchSize, c.batchPause, c.maxErrors)
+		defer r.Print()
 	}
-	r.Error = append(r.Error, copyError...)
+	r.AddCopyErrors(er)
+	le := &lastExec{"runscript", em, localFilename}
+	c.setLastResult(r, le)
+	expr, _ := wsSplit([]rune(argsLine))
+	c.record(le, string(expr), allHosts, started, r)
 }
 
 func (c *Cli) doRunScript(name string, argsLine string, args ...string) {
@@ -656,6 +960,48 @@ func (c *Cli) doPRunScript(name string, argsLine string, args ...string) {
 	c.dorunscript(execModeParallel, argsLine)
 }
 
+func (c *Cli) doRRunScript(name string, argsLine string, args ...string) {
+	c.dorunscript(execModeRolling, argsLine)
+}
+
+// setLastResult remembers the execution result and exposes its
+// failed and successful hosts as pseudo groups
+func (c *Cli) setLastResult(r *executer.ExecResult, le *lastExec) {
+	c.lastResult = r
+	c.lastExec = le
+	if len(r.Error) > 0 || len(r.Skipped) > 0 {
+		c.fail(ExitHostsFailed)
+	}
+	parser.SetPseudoGroup(pseudoGroupFailed, r.Error)
+	parser.SetPseudoGroup(pseudoGroupOK, r.Success)
+}
+
+func (c *Cli) doRetry(name string, argsLine string, args ...string) {
+	if c.lastExec == nil {
+		term.Errorf("Nothing to retry\n")
+		return
+	}
+	if len(c.lastResult.Error) == 0 {
+		term.Successf("There were no failed hosts in the last execution\n")
+		return
+	}
+
+	c.repeat(c.lastExec, "%"+pseudoGroupFailed)
+}
+
+// repeat runs a command like the one given on hosts of another expression
+func (c *Cli) repeat(le *lastExec, expr string) {
+	line := fmt.Sprintf("%s %s", expr, le.args)
+	switch le.handler {
+	case "exec":
+		c.doexec(le.mode, line)
+	case "runscript":
+		c.dorunscript(le.mode, line)
+	case "distribute":
+		c.doDistribute("distribute", line)
+	}
+}
+
 func (c *Cli) doDelay(name string, argsLine string, args ...string) {
 	if len(args) < 1 {
 		term.Errorf("Usage: delay <seconds>\n")
@@ -669,6 +1015,75 @@ func (c *Cli) doDelay(name string, argsLine string, args ...string) {
 	c.delay = int(sec)
 }
 
+func (c *Cli) doTimeout(name string, argsLine string, args ...string) {
+	if len(args) < 1 {
+		if c.timeout > 0 {
+			term.Warnf("Timeout is %d seconds\n", c.timeout)
+		} else {
+			term.Warnf("Timeout is off\n")
+		}
+		return
+	}
+	sec, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		term.Errorf("Invalid timeout format: %s\n", err)
+		return
+	}
+	if sec < 0 {
+		term.Errorf("Timeout can't be negative\n")
+		return
+	}
+	c.timeout = int(sec)
+	executer.SetTimeout(c.timeout)
+}
+
+func (c *Cli) doBatchSize(name string, argsLine string, args ...string) {
+	if len(args) < 1 {
+		term.Warnf("Batch size is %s\n", c.batchSize)
+		return
+	}
+	bs, err := executer.ParseThreshold(args[0])
+	if err != nil {
+		term.Errorf("Invalid batch size format: %s\n", err)
+		return
+	}
+	if bs.Value == 0 {
+		term.Errorf("Batch size can't be zero\n")
+		return
+	}
+	c.batchSize = bs
+}
+
+func (c *Cli) doBatchPause(name string, argsLine string, args ...string) {
+	if len(args) < 1 {
+		term.Warnf("Pause between batches is %d seconds\n", c.batchPause)
+		return
+	}
+	sec, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		term.Errorf("Invalid batch pause format: %s\n", err)
+		return
+	}
+	if sec < 0 {
+		term.Errorf("Batch pause can't be negative\n")
+		return
+	}
+	c.batchPause = int(sec)
+}
+
+func (c *Cli) doMaxErrors(name string, argsLine string, args ...string) {
+	if len(args) < 1 {
+		term.Warnf("Rolling execution is aborted when errors in a batch exceed %s\n", c.maxErrors)
+		return
+	}
+	me, err := executer.ParseThreshold(args[0])
+	if err != nil {
+		term.Errorf("Invalid max errors format: %s\n", err)
+		return
+	}
+	c.maxErrors = me
+}
+
 func (c *Cli) doDebug(name string, argsLine string, args ...string) {
 	if len(args) < 1 {
 		value := "off"
@@ -736,7 +1151,115 @@ func (c *Cli) doPrependHostnames(name string, argsLine string, args ...string) {
 }
 
 func (c *Cli) doReload(name string, argsLine string, args ...string) {
-	c.backend.Reload()
+	b := c.backend
+	if len(args) > 0 {
+		ns, ok := c.backend.(backend.Namespaced)
+		if !ok {
+			term.Errorf("There's only one backend configured\n")
+			return
+		}
+		var found bool
+		b, found = ns.Backend(args[0])
+		if !found {
+			term.Errorf("Unknown backend %s, configured backends are: %s\n", args[0], strings.Join(ns.Backends(), ", "))
+			return
+		}
+	}
+
+	err := b.Reload()
+	if err != nil {
+		term.Errorf("%s\n", err)
+		return
+	}
+	if cached, ok := b.(backend.Cached); ok && cached.Refreshing() {
+		term.Successf("Reloading data in background\n")
+	}
+}
+
+func (c *Cli) doInventory(name string, argsLine string, args ...string) {
+	snapshots, ok := c.backend.(backend.Snapshots)
+	if !ok {
+		term.Errorf("The backend doesn't keep inventory snapshots\n")
+		return
+	}
+	if len(args) == 0 {
+		term.Errorf("Usage: inventory <snapshots|diff [<snapshot_from> [<snapshot_to>]]>\n")
+		return
+	}
+
+	switch args[0] {
+	case "snapshots":
+		names, err := snapshots.Snapshots()
+		if err != nil {
+			term.Errorf("Error listing snapshots: %s\n", err)
+			return
+		}
+		if len(names) == 0 {
+			term.Warnf("No snapshots found\n")
+			return
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	case "diff":
+		from, to := "", ""
+		if len(args) > 1 {
+			from = args[1]
+		}
+		if len(args) > 2 {
+			to = args[2]
+		}
+		diff, err := snapshots.DiffSnapshots(from, to)
+		if err != nil {
+			term.Errorf("%s\n", err)
+			return
+		}
+		printInventoryDiff(diff)
+	default:
+		term.Errorf("Unknown inventory command %s\n", args[0])
+	}
+}
+
+func printInventoryDiff(diff *backend.InventoryDiff) {
+	term.Warnf("Changes from %s to %s\n", diff.From, diff.To)
+	if diff.Empty() {
+		term.Successf("No changes\n")
+		return
+	}
+
+	printItems := func(title string, added []string, removed []string) {
+		if len(added) == 0 && len(removed) == 0 {
+			return
+		}
+		fmt.Println(title)
+		for _, item := range added {
+			fmt.Println("    " + term.Green("+"+item))
+		}
+		for _, item := range removed {
+			fmt.Println("    " + term.Red("-"+item))
+		}
+	}
+	printChanges := func(title string, prefix string, changes []*backend.ListChange) {
+		if len(changes) == 0 {
+			return
+		}
+		fmt.Println(title)
+		for _, change := range changes {
+			items := make([]string, 0, len(change.Added)+len(change.Removed))
+			for _, item := range change.Added {
+				items = append(items, term.Green("+"+item))
+			}
+			for _, item := range change.Removed {
+				items = append(items, term.Red("-"+item))
+			}
+			fmt.Printf("    %s  %s\n", term.Blue(prefix+change.Name), strings.Join(items, " "))
+		}
+	}
+
+	printItems("Hosts:", diff.AddedHosts, diff.RemovedHosts)
+	printItems("Groups:", diff.AddedGroups, diff.RemovedGroups)
+	printChanges("Group membership:", "%", diff.Groups)
+	printChanges("Tags:", "", diff.Tags)
 }
 
 func (c *Cli) doConnectTimeout(name string, argsLine string, args ...string) {
@@ -750,32 +1273,151 @@ func (c *Cli) doConnectTimeout(name string, argsLine string, args ...string) {
 		return
 	}
 	c.connectTimeout = fmt.Sprintf("%d", int(ct))
-	remote.SSHOptions["ConnectTimeout"] = c.connectTimeout
+	remote.SetSSHOption("ConnectTimeout", c.connectTimeout)
 }
 
-func (c *Cli) acquirePasswd() {
-	switch c.raiseType {
-	case remote.RaiseTypeNone:
+func (c *Cli) doTransport(name string, argsLine string, args ...string) {
+	if len(args) < 1 {
+		term.Warnf("Using %s transport\n", transportMap[c.transport])
 		return
-	case remote.RaiseTypeSu:
-		// TODO GPG passwd acquire
-		if c.raisePasswd == "" {
-			c.doPasswd("passwd", "")
+	}
+	newTransport := args[0]
+	for transport, transportStr := range transportMap {
+		if newTransport == transportStr {
+			c.transport = transport
+			remote.SetTransport(c.transport)
+			return
 		}
-	case remote.RaiseTypeSudo:
-		if c.raisePasswd == "" {
-			c.doPasswd("passwd", "")
+	}
+	term.Errorf("Unknown transport: %s\n", newTransport)
+}
+
+func (c *Cli) doOutputFormat(name string, argsLine string, args ...string) {
+	if len(args) < 1 {
+		term.Warnf("Output format is %s\n", outputFormatMap[c.outputFormat])
+		return
+	}
+	newFormat := args[0]
+	for format, formatStr := range outputFormatMap {
+		if newFormat == formatStr {
+			c.outputFormat = format
+			executer.SetOutputFormat(c.outputFormat)
+			// diagnostics go to stderr not to break json records
+			term.SetMessagesToStderr(format != executer.OutputFormatText)
+			return
+		}
+	}
+	term.Errorf("Unknown output format: %s\n", newFormat)
+}
+
+func (c *Cli) doConnections(name string, argsLine string, args ...string) {
+	if len(args) == 0 {
+		conns := remote.Connections()
+		if len(conns) == 0 {
+			if remote.Multiplexing() {
+				term.Warnf("No persistent connections\n")
+			} else {
+				term.Warnf("Persistent connections are switched off\n")
+			}
+			return
+		}
+		now := time.Now()
+		for _, conn := range conns {
+			idle := "-"
+			if !conn.LastUsed.IsZero() {
+				idle = now.Sub(conn.LastUsed).Truncate(time.Second).String()
+			}
+			fmt.Printf("%s %-8s up %-10s idle %s\n",
+				term.Blue(conn.User+"@"+conn.Host),
+				transportMap[conn.Transport],
+				now.Sub(conn.Created).Truncate(time.Second),
+				idle,
+			)
 		}
+		term.Successf("Total: %d connections\n", len(conns))
+		return
+	}
+
+	if args[0] != "drop" {
+		term.Errorf("Usage: connections [drop [<host_expression>]]\n")
+		return
 	}
+
+	var hosts []string
+	if len(args) > 1 {
+		var err error
+		hosts, err = c.backend.HostList([]rune(args[1]))
+		if err != nil {
+			term.Errorf("Error parsing expression %s: %s\n", args[1], err)
+			return
+		}
+		if len(hosts) == 0 {
+			term.Errorf("Empty hostlist\n")
+			return
+		}
+	}
+	dropped := remote.DropConnections(hosts)
+	term.Successf("%d connections dropped\n", dropped)
+}
+
+// acquirePasswd asks for the su/sudo password if it's needed and
+// not set yet, it returns false if the password can't be read
+func (c *Cli) acquirePasswd() bool {
+	if c.raiseType == remote.RaiseTypeNone || c.raisePasswd != "" {
+		return true
+	}
+	passwd, err := c.readPasswd()
+	if err != nil {
+		term.Errorf("%s\n", err)
+		c.fail(ExitError)
+		return false
+	}
+	c.raisePasswd = passwd
+	return true
+}
+
+// readPasswd reads the su/sudo password. When stdin is busy with
+// commands, i.e. xc < batch.xc, the password is read from the terminal
+func (c *Cli) readPasswd() (string, error) {
+	const prompt = "Set su/sudo password: "
+	if readline.IsTerminal(int(os.Stdin.Fd())) {
+		passwd, err := c.rl.ReadPassword(prompt)
+		return string(passwd), err
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("Can't read su/sudo password as there's no terminal")
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, prompt)
+	passwd, err := readline.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	return string(passwd), err
 }
 
 func (c *Cli) confirm(msg string) bool {
+	if c.assumeYes {
+		return true
+	}
+	var in io.Reader = os.Stdin
+	var out io.Writer = os.Stdout
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		// stdin is busy with commands, the terminal is asked instead
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			term.Errorf("Can't ask \"%s\" as there's no terminal, use -y option to answer yes\n", msg)
+			c.fail(ExitError)
+			return false
+		}
+		defer tty.Close()
+		in, out = tty, tty
+	}
 	response := "_"
-	reader := bufio.NewReader(os.Stdin)
+	reader := bufio.NewReader(in)
 	result := true
 	for response != "y" && response != "n" && response != "" {
-		fmt.Print(msg)
-		fmt.Print(" [Y/n] ")
+		fmt.Fprint(out, msg)
+		fmt.Fprint(out, " [Y/n] ")
 		response, err := reader.ReadString('\n')
 		if err == nil {
 			if len(response) > 0 {
@@ -789,7 +1431,7 @@ func (c *Cli) confirm(msg string) bool {
 				break
 			}
 		}
-		fmt.Println()
+		fmt.Fprintln(out)
 	}
 	return result
 }
@@ -3,6 +3,7 @@ package cli
 import (
 	"backend"
 	"os"
+	"parser"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -14,22 +15,34 @@ type xcCompleter struct {
 	commands   []string
 	completers map[string]completeFunc
 	backend    backend.Backend
+	hosts      *hostSearch
 }
 
 func newXcCompleter(backend backend.Backend, commands []string) *xcCompleter {
-	x := &xcCompleter{commands, make(map[string]completeFunc), backend}
-	x.completers["mode"] = staticCompleter([]string{"collapse", "serial", "parallel"})
+	x := &xcCompleter{commands, make(map[string]completeFunc), backend, newHostSearch()}
+	x.completers["mode"] = staticCompleter([]string{"collapse", "serial", "parallel", "rolling"})
 	x.completers["debug"] = staticCompleter([]string{"on", "off"})
 	x.completers["progressbar"] = staticCompleter([]string{"on", "off"})
 	x.completers["prepend_hostnames"] = staticCompleter([]string{"on", "off"})
 	x.completers["raise"] = staticCompleter([]string{"none", "su", "sudo"})
 	x.completers["interpreter"] = staticCompleter([]string{"none", "su", "sudo"})
+	x.completers["transport"] = staticCompleter([]string{"openssh", "native"})
+	x.completers["output_format"] = staticCompleter([]string{"text", "json", "ndjson"})
+	x.completers["stop_on_error"] = staticCompleter([]string{"on", "off"})
+	x.completers["settings"] = staticCompleter([]string{"save"})
+	x.completers["config"] = staticCompleter([]string{"show", "set"})
+	x.completers["alias"] = staticCompleter([]string{"list", "save"})
 	x.completers["exec"] = x.completeExec
 	x.completers["s_exec"] = x.completeExec
 	x.completers["c_exec"] = x.completeExec
 	x.completers["p_exec"] = x.completeExec
+	x.completers["r_exec"] = x.completeExec
 	x.completers["ssh"] = x.completeExec
-	x.completers["hostlist"] = x.completeExec
+	x.completers["hostlist"] = x.completeHostlist
+	x.completers["connections"] = x.completeConnections
+	x.completers["inventory"] = x.completeInventory
+	x.completers["journal"] = x.completeJournal
+	x.completers["reload"] = x.completeReload
 	x.completers["cd"] = completeFiles
 	x.completers["output"] = completeFiles
 	x.completers["distribute"] = x.completeDistribute
@@ -37,8 +50,9 @@ func newXcCompleter(backend backend.Backend, commands []string) *xcCompleter {
 	x.completers["c_runscript"] = x.completeDistribute
 	x.completers["p_runscript"] = x.completeDistribute
 	x.completers["s_runscript"] = x.completeDistribute
+	x.completers["r_runscript"] = x.completeDistribute
 
-	helpTopics := append(commands, "expressions", "config", "rcfiles")
+	helpTopics := append(commands, "expressions", "rcfiles", "cmdline", "scripting")
 	x.completers["help"] = staticCompleter(helpTopics)
 	return x
 }
@@ -61,6 +75,15 @@ func runeIndex(line []rune, sym rune) int {
 	return -1
 }
 
+func runeIndexAny(line []rune, syms string) int {
+	for i := 0; i < len(line); i++ {
+		if strings.ContainsRune(syms, line[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
 func toRunes(src []string) [][]rune {
 	dst := make([][]rune, len(src))
 	for i := 0; i < len(src); i++ {
@@ -99,6 +122,7 @@ func (x *xcCompleter) removeCommand(name string) {
 
 func (x *xcCompleter) Do(line []rune, pos int) (newLine [][]rune, length int) {
 	postfix := line[pos:]
+	x.hosts.line, x.hosts.postfix = line[:pos], postfix
 	result, length := x.complete(line[:pos])
 	if len(postfix) > 0 {
 		for i := 0; i < len(result); i++ {
@@ -168,8 +192,8 @@ func (x *xcCompleter) completeExec(line []rune) (newLine [][]rune, length int) {
 		return [][]rune{}, 0
 	}
 
-	// are we in complex pattern? look for comma
-	ci := runeIndex(line, ',')
+	// are we in complex pattern? look for comma, intersection or parenthesis
+	ci := runeIndexAny(line, ",&()")
 	if ci >= 0 {
 		return x.completeExec(line[ci+1:])
 	}
@@ -180,6 +204,16 @@ func (x *xcCompleter) completeExec(line []rune) (newLine [][]rune, length int) {
 		return x.completeExec(line[1:])
 	}
 
+	if pl := parser.NamespacePrefix(line); pl > 0 {
+		// the rest of the token is completed by the prefixed backend only
+		if ns, ok := x.backend.(backend.Namespaced); ok {
+			if b, found := ns.Backend(string(line[:pl-1])); found {
+				sub := &xcCompleter{x.commands, x.completers, b, x.hosts}
+				return sub.completeExec(line[pl:])
+			}
+		}
+	}
+
 	if len(line) > 0 && line[0] == '%' {
 		return x.completeGroup(line)
 	}
@@ -191,12 +225,79 @@ func (x *xcCompleter) completeExec(line []rune) (newLine [][]rune, length int) {
 	return x.completeHost(line)
 }
 
+// completeAlias returns the completer of an alias completing
+// the arguments which are host expressions
+func (x *xcCompleter) completeAlias(al *alias) completeFunc {
+	return func(line []rune) (newLine [][]rune, length int) {
+		args := exprWhiteSpace.Split(string(line), -1)
+		last := len(args) - 1
+		if !al.isExpr(last) {
+			return [][]rune{}, 0
+		}
+		return x.completeExec([]rune(args[last]))
+	}
+}
+
+func (x *xcCompleter) completeHostlist(line []rune) (newLine [][]rune, length int) {
+	flag, expr := wsSplit(line)
+	if expr != nil && string(flag) == "-d" {
+		return x.completeExec(expr)
+	}
+	return x.completeExec(line)
+}
+
+func (x *xcCompleter) completeConnections(line []rune) (newLine [][]rune, length int) {
+	subcmd, expr := wsSplit(line)
+	if expr == nil {
+		return staticCompleter([]string{"drop"})(subcmd)
+	}
+	return x.completeExec(expr)
+}
+
+func (x *xcCompleter) completeJournal(line []rune) (newLine [][]rune, length int) {
+	subcmd, expr := wsSplit(line)
+	if expr == nil {
+		return staticCompleter([]string{"list", "show", "rerun"})(subcmd)
+	}
+	if string(subcmd) == "list" {
+		return x.completeExec(expr)
+	}
+	return [][]rune{}, 0
+}
+
+func (x *xcCompleter) completeInventory(line []rune) (newLine [][]rune, length int) {
+	subcmd, rest := wsSplit(line)
+	if rest == nil {
+		return staticCompleter([]string{"snapshots", "diff"})(subcmd)
+	}
+	snapshots, ok := x.backend.(backend.Snapshots)
+	if string(subcmd) != "diff" || !ok {
+		return nil, 0
+	}
+	names, err := snapshots.Snapshots()
+	if err != nil {
+		return nil, 0
+	}
+	// both snapshot arguments are completed the same way
+	_, last := wsSplit(rest)
+	if last == nil {
+		last = rest
+	}
+	return staticCompleter(names)(last)
+}
+
 func (x *xcCompleter) completeGroup(line []rune) (newLine [][]rune, length int) {
 	ai := runeIndex(line, '@')
 	if ai >= 0 {
 		return x.completeDatacenter(line[ai:])
 	}
 	groups := x.backend.CompleteGroup(string(line))
+	expr := strings.TrimPrefix(string(line), "%")
+	for _, pg := range parser.PseudoGroupNames() {
+		if strings.HasPrefix(pg, expr) {
+			groups = append(groups, pg[len(expr):])
+		}
+	}
 	return toRunes(groups), len(line)
 }
 
@@ -209,11 +310,37 @@ func (x *xcCompleter) completeWorkGroup(line []rune) (newLine [][]rune, length i
 	return toRunes(wgroups), len(line)
 }
 
+// completeHost completes host names starting with the fragment typed,
+// recently used ones go first. If there are no such names, the backend
+// is searched for names containing the fragment
 func (x *xcCompleter) completeHost(line []rune) (newLine [][]rune, length int) {
+	if x.hosts.cycle() {
+		return nil, 0
+	}
 	hosts := x.backend.CompleteHost(string(line))
+	x.hosts.rank(string(line), hosts)
+	if ns, ok := x.backend.(backend.Namespaced); ok {
+		for _, name := range ns.Backends() {
+			if strings.HasPrefix(name, string(line)) {
+				hosts = append(hosts, name[len(line):]+":")
+			}
+		}
+	}
+	if searcher, ok := x.backend.(backend.HostSearcher); ok && len(hosts) == 0 {
+		x.hosts.search(searcher, line)
+		return nil, 0
+	}
 	return toRunes(hosts), len(line)
 }
 
+func (x *xcCompleter) completeReload(line []rune) (newLine [][]rune, length int) {
+	ns, ok := x.backend.(backend.Namespaced)
+	if !ok {
+		return nil, 0
+	}
+	return staticCompleter(ns.Backends())(line)
+}
+
 func (x *xcCompleter) completeDatacenter(line []rune) (newLine [][]rune, length int) {
 	dcs := x.backend.CompleteDatacenter(string(line))
 	return toRunes(dcs), len(line)
@@ -20,7 +20,7 @@ var (
 List of hosts is represented by <host_expression> in its own syntax which can be learned 
 by using "help expressions" command.
 
-exec can proceed in 3 different modes: serial, parallel and collapse.
+exec can proceed in 4 different modes: serial, parallel, collapse and rolling.
 
 In ` + term.Colored("serial", term.CWhite, true) + ` mode the command will be called server by server sequentally. Between servers in list 
 xc will hold for a delay which can be set with command "delay".
@@ -35,12 +35,18 @@ the execution is over. In this mode xc prints the result grouped by the output s
 between hosts become more obvious. Try running "exec %group cat /etc/redhat-release" on a big
 group of hosts in collapse mode to see if they have the same version of OS for example.
 
+The ` + term.Colored("rolling", term.CWhite, true) + ` mode is meant for safe rollouts. Hosts are split into batches of "batch_size" hosts,
+every batch runs in parallel and xc holds for "batch_pause" seconds between batches. If the number
+of errors in a batch exceeds "max_errors", the execution is aborted and the rest of hosts are skipped.
+Both batch_size and max_errors may be set either as a number of hosts or as a percentage, i.e. "10%".
+
 While the execution mode can be switched by "mode" command, there's a couple of shortcuts: 
     c_exec 
     p_exec
     s_exec 
-which are capable to run exec in collapse, parallel or serial mode correspondingly without switching
-the execution mode`,
+    r_exec
+which are capable to run exec in collapse, parallel, serial or rolling mode correspondingly without 
+switching the execution mode`,
 	}
 
 	runScriptHelp = &helpItem{
@@ -53,20 +59,34 @@ runscript simply copies the script to every server in the list and then
 run it according to current execution mode (Type "help exec" to learn more 
 on execution modes), i.e. it can run in parallel or sequentally like exec does.
 
-There are also shortcut aliases c_runscript, s_runscript and p_runscript for calling runscript
-in a particular execution mode without permanent switching to it.`,
+There are also shortcut aliases c_runscript, s_runscript, p_runscript and r_runscript for calling 
+runscript in a particular execution mode without permanent switching to it.`,
 	}
 
 	modeHelp = `Switches execution mode
 
 To learn more about execution modes type "help exec".
 
-Xc has shortcuts for switching modes: just type "parallel", "serial" or "collapse" and it will 
-switch the mode correspondingly.`
+Xc has shortcuts for switching modes: just type "parallel", "serial", "collapse" or "rolling" and
+it will switch the mode correspondingly.`
+
+	thresholdHelp = `Sets parameters of the rolling execution mode. When called without arguments, prints the current value.
+
+batch_size is the number of hosts processed in parallel at once. It can be set as a number of hosts
+or as a percentage of the whole list, i.e. "batch_size 5" or "batch_size 10%".
+
+batch_pause is the number of seconds xc holds for between batches. Ctrl-C during the pause aborts
+the execution.
+
+max_errors is the number of failed hosts a batch may have. If a batch has more errors than that the 
+rolling execution is aborted and the rest of hosts are skipped. It can be set as a number of hosts
+or as a percentage of the batch, "max_errors 0" aborts on the first failed host.
+
+To learn more about execution modes type "help exec".`
 
 	helpStrings = map[string]*helpItem{
 		"alias": &helpItem{
-			usage: "<aliasname> <cmd> [<args>]",
+			usage: "<aliasname>[(<params>)] [\"<docstring>\"] <cmd> [<args>] | list | save",
 			help: `Creates a local alias. This is handy for longer commands which are often in use.
         
 Example: 
@@ -75,8 +95,42 @@ Example:
                                       <ARG> will be taken from the alias command and put into p_exec command,
                                       i.e. uptime %mygroup will run p_exec %mygroup uptime
 
-Every alias created disappears after xc exits. To make an alias persistent put it into rcfile. 
-See "help rcfiles" for further info.`,
+Arguments are referenced as #1, #2 and so on, #* stands for all of them. Use #{1} to put text right after
+an argument, i.e. #{1}0 is the first argument followed by 0 while #10 is the tenth argument. Such aliases
+take any number of arguments but fail if there are fewer than the ones referenced.
+
+An alias may declare named parameters and a docstring shown by "help <aliasname>":
+    alias deploy(hosts:expr, branch=master, opts...) "Deploys a branch" p_exec #hosts deploy.sh #branch #opts
+Parameters are referenced as #name or #{name}, the alias fails if arguments without default values are
+missing or if there are more arguments than parameters. Parameter options are:
+    name=value          - the default value used when the argument is omitted, quote it if it contains
+                          spaces, commas or parentheses: name="a, b"
+    name:expr           - the argument is a host expression, it's completed with tab
+    name...             - the last parameter may take the rest of arguments as is
+Arguments put in place of the host expression of exec-like commands are completed as host expressions
+too, i.e. the first argument of the uptime alias above.
+
+"alias <aliasname>" without a command removes the alias.
+
+"alias list" shows every alias with the command it expands to.
+
+Every alias created disappears after xc exits. To make aliases persistent run "alias save" which writes
+them to the rcfile: definitions found in it are updated in place, the ones of removed aliases are dropped
+and new aliases are appended, other lines and comments are kept. See "help rcfiles" for further info.
+Names "list" and "save" can't be used for aliases.`,
+		},
+
+		"batch_size": &helpItem{
+			usage: "[<hosts>/<percentage>%]",
+			help:  thresholdHelp,
+		},
+		"batch_pause": &helpItem{
+			usage: "[<seconds>]",
+			help:  thresholdHelp,
+		},
+		"max_errors": &helpItem{
+			usage: "[<hosts>/<percentage>%]",
+			help:  thresholdHelp,
 		},
 
 		"cd": &helpItem{
@@ -86,7 +140,17 @@ See "help rcfiles" for further info.`,
 
 		"config": &helpItem{
 			isTopic: true,
-			help: `Configuration file is located in ~/.xc.conf.
+			help: `Configuration file is located in ~/.xc.conf, another one may be given with the -c option.
+
+The config command shows and changes the file from the shell, comments and the rest of settings
+are kept as is. Changes made with it are applied when xc is restarted:
+    config show                     - shows all the settings of the file
+    config show executer            - shows the settings of a section
+    config show main.mode           - shows a single setting
+    config set main.mode serial     - sets a setting, adding it to the file if it's missing
+Passwords and tokens are shown masked.
+"settings save" writes the current mode, user, delay, threads and interpreters to the file,
+see "help settings".
 			
 The first time xc starts it creates a default configuration file with all the settings set
 to default values:
@@ -101,6 +165,7 @@ raise = none
 exit_confirm = true
 backend_type = conductor
 local_file = ~/.xc_hosts
+output_format = text
 
 [executer]
 ssh_threads = 50
@@ -109,12 +174,41 @@ ping_count = 5
 progress_bar = true
 remote_tmpdir = /tmp
 delay = 0
+timeout = 0
+batch_size = 10%
+batch_pause = 0
+max_errors = 0
+transport = openssh
+ssh_keys = ~/.ssh/id_rsa,~/.ssh/id_ecdsa,~/.ssh/id_ed25519
+ssh_multiplex = true
+ssh_multiplex_idle = 600
 
 [inventoree]
 url = http://c.inventoree.ru
 work_groups = 
+incremental = false
+timeout = 30
+retries = 2
+token = 
+user = 
+password = 
+ca_file = 
+cert_file = 
+key_file = 
+proxy = 
+
+[exec]
+command = 
+resolve = false
+timeout = 30
+
+[ansible]
+inventory = /etc/ansible/hosts
 
-Configuration is split to 3 sections: main, executer and inventoree.
+[sshconfig]
+file = ~/.ssh/config
+
+Configuration is split to 6 sections: main, executer, inventoree, exec, ansible and sshconfig.
 
 main.user is the user which will be set on xc startup. If empty, the current system user is used.
 
@@ -124,15 +218,48 @@ main.history_file sets the history file
 
 main.cache_dir sets the cache dir for data derived from inventoree
 
+main.cache_ttl sets the number of hours the inventoree cache is considered fresh. An expired cache is still
+	used on startup of the interactive shell while the data is being reloaded in background, the prompt
+	shows the cache age then. Commands given on the command line or in a batch wait for the reload
+
+main.cache_hard_ttl sets the number of hours after which the cache is not used on startup, xc waits for
+	inventoree instead. The cache is still used if inventoree is unavailable. 0 means no limit
+
+main.cache_snapshots sets the number of inventory snapshots kept for "inventory diff", 0 switches them off
+
 main.rc_file is the rcfile which will be executed on xc startup. See "help rcfiles" for more info.
 
 main.raise is the raise mode which will be set on xc startup
 
 main.exit_confirm is boolean setting for disable or enable confirmation on exit
 
-main.backend_type is type of backend, conductor or localjson or localini now
+main.backend_type is type of backend: conductor, localjson, localini, exec, ansible or sshconfig
+
+main.backends is a comma-separated list of backends used simultaneously instead of main.backend_type.
+	Every backend is configured in its own section, "type" sets its type and any other setting may be
+	overridden using its full name, settings not overridden are taken from the regular sections:
+	    [main]
+	    backends = prod,lab
+	    [backend.prod]
+	    type = conductor
+	    inventoree.work_groups = prod
+	    [backend.lab]
+	    type = localini
+	    main.local_file = ~/lab.ini
+	Expressions may refer to a particular backend like lab:%web, see "help expressions"
+
+main.local_file is path to json or ini local file, used when backend_type is localjson or localini. Both
+	may be a plain map of group names to lists of hosts, a json file may also use the extended format:
+	{"datacenters": [{"name", "parent"}], "workgroups": [{"name"}], "groups": [{"name", "parents",
+	"workgroup", "tags", "hosts"}], "hosts": [{"fqdn", "aliases", "tags", "groups", "datacenter", "attributes"}]}
+	which makes workgroups, datacenters, tags and nested groups available in expressions
+
+main.output_format sets the format of exec results on xc startup. See "help output_format" for more info
 
-main.local_file is path to json or ini local file, used when backend_type is localjson or localini
+main.journal_file is the journal of executed commands, i.e. ~/.xc_journal. It's empty by default which
+	switches the journal off. See "help journal"
+
+main.journal_output sets the number of bytes of every host's output kept in the journal, 0 means no output is kept
 
 executer.ssh_threads limits the number of simultaneously running ssh commands.
 
@@ -146,18 +273,153 @@ executer.remote_tmpdir is a temporary directory used on remote servers for vario
 
 executer.delay sets a delay in seconds between hosts when executing in serial mode. See "help delay" for more info
 
+executer.batch_size, executer.batch_pause and executer.max_errors set parameters of the rolling mode. See "help batch_size" for more info
+
+executer.timeout sets the number of seconds a command may run on a host, 0 means no limit. See "help timeout" for more info
+
+executer.transport sets the way xc reaches remote hosts, openssh or native. See "help transport" for more info
+
+executer.ssh_keys is a comma-separated list of private key files used by the native transport
+
+executer.ssh_multiplex keeps persistent connections to hosts for the whole interactive session. See "help connections" for more info
+
+executer.ssh_multiplex_idle sets the number of seconds an unused persistent connection is kept open
+
 inventoree.url sets the url of the inventoree service
 
 inventoree.work_groups is a comma-separated list of work_groups which will be downloaded from inventoree. 
 	If empty all work groups (i.e. all groups and all hosts as well) are downloaded without filtering which
-    may cause startup delays`,
+    may cause startup delays
+
+inventoree.incremental makes reloads request only the changes made since the cached data was loaded. The
+	request has a "since" parameter along with If-None-Match/If-Modified-Since headers, the server may respond
+	with 304 Not Modified or with {"incremental": true, "data": {...changed objects...}, "removed": {"hosts": [ids],
+	"groups": [ids], "datacenters": [ids], "work_groups": [ids]}}. A server not supporting it responds with the
+	full data as usual. If the incremental request fails, all the data is reloaded
+
+inventoree.timeout sets the number of seconds a request to inventoree may take, 0 means no limit
+
+inventoree.retries sets the number of times a failed request is repeated with growing delays. Only network
+	errors and server-side failures are retried. Responses which are not successful json never get to the cache
+
+inventoree.token is a token sent as "Authorization: Bearer <token>". If it's empty and inventoree.user
+	is set, basic auth with inventoree.user and inventoree.password is used
+
+inventoree.ca_file is a PEM file with CA certificates to verify inventoree's certificate with,
+	the system ones are used if empty
+
+inventoree.cert_file and inventoree.key_file are PEM files with a client certificate and its key
+
+inventoree.proxy is the url of a proxy to reach inventoree through. If empty HTTPS_PROXY/HTTP_PROXY
+	environment variables are used
+
+exec.command is a program (with optional arguments) the exec backend takes the inventory from. It's called
+	as "<command> dump" and must print JSON like {"datacenters": [{"name", "parent"}], "workgroups": [{"name"}],
+	"groups": [{"name", "parents", "workgroup", "tags", "hosts"}], "hosts": [{"fqdn", "aliases", "tags", "groups",
+	"datacenter", "attributes"}]}. Groups, workgroups and datacenters referenced by hosts may be omitted.
+
+exec.resolve makes the exec backend pass expressions to the program as "<command> resolve <expr>" instead of
+	resolving them locally, the program must print a JSON array of hostnames
+
+exec.timeout sets the number of seconds the exec backend program may run
+
+ansible.inventory is an Ansible inventory file used by the ansible backend, YAML if its extension is .yml
+	or .yaml and INI otherwise. Host ranges like web[01:20] and nested groups are supported. Groups
+	which are not children of other groups (except for "all") are also available as workgroups, "tags"
+	variables of hosts and groups provide tags, "datacenter" variables provide datacenters, and all the
+	host variables (inherited from groups) are available as attributes, i.e. %web[ansible_user=deploy]
+
+sshconfig.file is an ssh client config used by the sshconfig backend, Include directives are followed.
+	Every name of a Host line without wildcards is a host. Wildcard patterns make groups named after the
+	pattern without wildcards, i.e. "Host *.db.example.com" makes %db.example.com and "Host web-*" makes %web.
+	A "# xc-groups: group1,group2" comment puts hosts of the next Host block into the listed groups.
+	User, Port and HostName settings are used for connections and are available as attributes,
+	i.e. %web[user=deploy]. User applies unless a user is set by main.user, the -u option or the user
+	command. The openssh transport is given the file with -F so the rest of its settings apply as well`,
+		},
+
+		"cmdline": &helpItem{
+			isTopic: true,
+			help: `xc may run commands without the interactive shell, i.e. from cron jobs or CI pipelines:
+    xc -c conf -m parallel -u root exec %grp uptime     - runs a single command given as arguments
+    xc -f batch.xc                                      - runs commands from a file, one per line
+    xc < batch.xc                                       - runs commands from stdin if it's not a terminal
+Empty lines and lines starting with # are skipped in batch files, see "help scripting" for
+variables, conditions and loops.
+
+Options:
+    -c <file>       configuration file to use instead of ~/.xc.conf
+    -b <name>       backend to use, a name from main.backends or a backend type
+    -m <mode>       execution mode: serial, parallel, collapse or rolling
+    -u <user>       remote user
+    -r <type>       privilege raise type: none, su or sudo
+    -f <file>       file to read commands from, - stands for stdin, a command can't be given along with it
+    -y              answer yes to confirmations, i.e. the ones exec_confirm asks
+The options are applied before the rcfile so its commands run with them, and once again after it
+so mode, user and raise type options take precedence over the ones it sets.
+
+The exit code reflects the results of all the commands run:
+    0               all the hosts succeeded
+    1               some hosts failed or were skipped
+    2               a command could not be run, i.e. it's unknown or its host expression is invalid or empty,
+                    or the inventory could not be loaded
+When commands are read from a pipe, confirmations and su/sudo passwords are asked on the terminal.
+Without a terminal, i.e. in cron jobs, commands needing confirmation are refused unless -y is given
+and raised commands fail as the password can't be read.`,
 		},
 
 		"rcfiles": &helpItem{
 			isTopic: true,
 			help: `Rcfile configured in .xc.conf file is executed every time xc starts.
 It may be useful for configuring aliases (as they are dropped when xc exits) and other options.
-Rcfile is just a number of xc commands in a text file.`,
+Rcfile is just a number of xc commands in a text file, it may use variables and blocks
+described in "help scripting".`,
+		},
+
+		"scripting": &helpItem{
+			isTopic: true,
+			help: `Rcfiles, batch files and commands typed in the shell may use variables and blocks.
+
+Variables are set by the set command and expanded in commands as $NAME or ${NAME}:
+    set GRP %web
+    exec $GRP uptime
+Undefined variables are left as is, so remote commands may still use their own ones.
+
+An if block runs its commands depending on the result of the last command:
+    exec %web service nginx reload
+    if last_failed
+        exec %web service nginx status
+    else
+        local echo reloaded
+    end
+Conditions are last_failed and last_ok, either may be negated with not. A command fails when
+some of the hosts fail or it can't be run at all.
+
+A for block runs its commands for every host of a host expression in turn:
+    for h in %web
+        exec $h curl -s localhost/health
+    end
+The loop variable is restored to its previous value (or unset) when the loop is over.
+
+"stop_on_error on" makes batch files and rcfiles stop at the first failed command.
+Blocks may be typed in the shell too, they are run when closed with end.`,
+		},
+
+		"connections": &helpItem{
+			usage: "[drop [<host_expression>]]",
+			help: `Lists or drops persistent connections to remote hosts.
+
+When executer.ssh_multiplex is on, an interactive xc session keeps a master connection
+to every host it has run commands on, so the following exec, runscript and distribute commands skip connecting
+and authenticating. The openssh transport uses ControlMaster sockets for that, the native
+transport keeps its own connections. Connections not used for executer.ssh_multiplex_idle
+seconds are closed automatically, all of them are closed when xc exits. Commands given
+on the command line, in a batch file or on stdin don't use persistent connections.
+
+Examples:
+    connections                     - lists the current persistent connections
+    connections drop                - closes all the persistent connections
+    connections drop %group1        - closes connections to hosts of group1`,
 		},
 
 		"debug": &helpItem{
@@ -193,13 +455,46 @@ Some self-explanatory examples:
     %group1                             - a group of hosts taken from inventoree
     %group1,host1                       - all hosts from group1, plus host1
     %group1,-host2                      - all hosts from group1, excluding(!) host2
-    %group2@dc1                         - all hosts from group2, located in datacenter dc1
+    %group2@dc1                         - all hosts from group2, located in datacenter dc1 or any of its children
+    %group2@=dc1                        - all hosts from group2, located exactly in datacenter dc1
     *myworkgroup@dc2,-%group3,host5     - all hosts from wg "myworkgroup" excluding hosts from group3, plus host5
     %group5#tag1                        - all hosts from group5 tagged with tag1
     
+There are also pseudo groups made of the last execution result (exec, runscript or distribute):
+    %_failed                            - hosts which failed during the last execution
+    %_ok                                - hosts which succeeded during the last execution
+    
 You may combine any number of tokens keeping in mind that they are resolved left to right, so exclusions
 almost always should be on the righthand side. For example, "-host1,host1" will end up with host1 in list
-despite being excluded previously.`,
+despite being excluded previously.
+
+Tokens may also be intersected with "&" which binds tighter than comma, and grouped with parentheses:
+    %group1&%group2                     - hosts which are in both group1 and group2
+    %group1&-%group2                    - hosts from group1 which are not in group2
+    %group1&(%group2,%group3)           - hosts from group1 which are in group2 or group3
+    *wg1,-(%group1&#tag1)               - all hosts from wg1 excluding hosts from group1 tagged with tag1
+
+Any token may be filtered by host attributes given in square brackets after the token's name, datacenter
+and tags (but before the regexp filter). Filters are comma-separated and all of them must match:
+    %group1[dc=~msk.*,alias=foo]        - hosts from group1 in datacenters matching msk.* having alias foo
+    *wg1[tag!=deprecated]               - hosts from wg1 not tagged with deprecated
+    %group1[fqdn!~^db]/web/             - hosts from group1 matching /web/ but not starting with "db"
+Operators are = (equals), != (not equals), =~ (matches regexp) and !~ (doesn't match regexp). Attributes
+with multiple values match if any of the values does, negative operators match if none of them does.
+A comma or a bracket may be screened with a backslash, i.e. %web[tag=a\,b] matches hosts tagged "a,b".
+Available attributes depend on the backend: conductor provides fqdn, alias, tag, group, workgroup and
+dc (the latter includes parent datacenters), localfile provides fqdn and group.
+
+When several backends are configured with main.backends, tokens are resolved by all of them and the
+results are merged. A token may be prefixed with a backend name to be resolved by that backend only:
+    lab:%web                            - hosts of group web from the lab backend
+    prod:*wg1,lab:%web                  - hosts of workgroup wg1 from prod plus hosts of group web from lab
+    %web,-lab:%web                      - hosts of group web from all the backends except lab
+
+Pressing tab completes host names starting with the text typed, recently used hosts are listed first.
+If no host name starts with it, the text is replaced with the best host name containing it, or at least
+containing all of its characters in the same order, i.e. "web3" may become "db-web3.example.com" and
+"dbw3" may become "db-web3.example.com" as well. Pressing tab again puts the next matching host instead.`,
 			isTopic: true,
 		},
 
@@ -207,6 +502,7 @@ despite being excluded previously.`,
 		"s_exec": execHelp,
 		"c_exec": execHelp,
 		"p_exec": execHelp,
+		"r_exec": execHelp,
 
 		"exit": &helpItem{
 			usage: "",
@@ -219,9 +515,10 @@ despite being excluded previously.`,
 		},
 
 		"hostlist": &helpItem{
-			usage: "<host_expression>",
+			usage: "[-d] <host_expression>",
 			help: `Resolves the host expression and prints the resulting hostlist. To learn more about expressions
-use "help expressions" command`,
+use "help expressions" command. With -d flag every host is printed along with its datacenter path,
+i.e. europe/de/fra1, if the backend knows about datacenters`,
 		},
 
 		"local": &helpItem{
@@ -235,7 +532,7 @@ aliases like so: alias ping local ping #*. This will create an alias "ping" so y
 		},
 
 		"mode": &helpItem{
-			usage: "<serial/parallel/collapse>",
+			usage: "<serial/parallel/collapse/rolling>",
 			help:  modeHelp,
 		},
 
@@ -251,6 +548,10 @@ aliases like so: alias ping local ping #*. This will create an alias "ping" so y
 			usage: "",
 			help:  modeHelp,
 		},
+		"rolling": &helpItem{
+			usage: "",
+			help:  modeHelp,
+		},
 
 		"prepend_hostnames": &helpItem{
 			usage: "<on/off>",
@@ -275,15 +576,46 @@ Switching them off is useful for copy-pasting the results.`,
 If the value is "none", no attempts to raise privileges will be made.`,
 		},
 
+		"inventory": &helpItem{
+			usage: "<snapshots|diff [<snapshot_from> [<snapshot_to>]]>",
+			help: `Shows the history of inventory data.
+
+Every time the data is loaded from inventoree, xc keeps a timestamped snapshot of it in
+the cache directory, the number of snapshots kept is set by main.cache_snapshots.
+"inventory diff" compares two snapshots and reports hosts added or removed, changes of
+group membership (including hosts of child groups) and changes of host tags, which
+explains why a group expression resolves to different hosts. If snapshot_to is omitted
+the latest snapshot is used, if snapshot_from is omitted the one preceding snapshot_to is used.
+
+Examples:
+    inventory snapshots                              - lists the kept snapshots
+    inventory diff                                   - compares the two latest snapshots
+    inventory diff 20190301-120000                   - compares the snapshot with the latest one
+    inventory diff 20190301-120000 20190305-093000   - compares two given snapshots`,
+		},
+
 		"reload": &helpItem{
+			usage: "[<backend>]",
+			help: `Reloads hosts and groups data from inventoree and rewrites the cache.
+In the interactive shell the data is loaded in background, the current data
+is used until the new one is ready. The prompt shows [refreshing] while the
+reload is in progress. Otherwise the next command waits for the reload.
+When several backends are configured with main.backends, all of them are
+reloaded unless a backend name is given.`,
+		},
+
+		"retry": &helpItem{
 			usage: "",
-			help:  `Reloads hosts and groups data from inventoree and rewrites the cache`,
+			help: `Repeats the last exec, runscript or distribute command on the hosts which failed during
+its execution, in the same execution mode. This is a shortcut for running the same command with
+the %_failed host expression. See "help expressions" for more info on pseudo groups.`,
 		},
 
 		"runscript":   runScriptHelp,
 		"c_runscript": runScriptHelp,
 		"p_runscript": runScriptHelp,
 		"s_runscript": runScriptHelp,
+		"r_runscript": runScriptHelp,
 
 		"interpreter": &helpItem{
 			usage: "[raise_type interpreter]",
@@ -304,6 +636,61 @@ the current output filename (or a message saying that the output logging is swit
 and exits.`,
 		},
 
+		"output_format": &helpItem{
+			usage: "[text/json/ndjson]",
+			help: `Sets the format exec, runscript and distribute commands print their results in. When called
+without arguments, prints the current value.
+
+The ` + term.Colored("text", term.CWhite, true) + ` format is the default colored output meant for humans.
+
+With the ` + term.Colored("ndjson", term.CWhite, true) + ` format every chunk of output is printed as a separate JSON record as soon
+as it's received:
+    {"type":"output","host":"host1","stream":"stdout","timestamp":"...","data":"..."}
+and the execution ends with a summary record:
+    {"type":"summary","codes":{"host1":0},"success":["host1"],"error":[],"stopped":0}
+
+The ` + term.Colored("json", term.CWhite, true) + ` format collects the same records and prints a single JSON document
+{"output":[...],"summary":{...}} when the execution is over.
+
+Progressbar, host banners and the exec_confirm banner are switched off in json and ndjson
+formats, errors and warnings are printed to stderr so stdout carries JSON only. Serial mode
+runs commands in a terminal so its output is printed as is, followed by the summary record.`,
+		},
+
+		"settings": &helpItem{
+			usage: "save",
+			help: `Writes the settings changed in the session to the config file so they are used the next time
+xc starts. The settings saved are mode (main.mode), user (main.user), delay (executer.delay),
+threads (executer.ssh_threads) and interpreters (executer.interpreter, executer.interpreter_sudo and
+executer.interpreter_su). Comments and other settings of the file are kept as is.`,
+		},
+
+		"journal": &helpItem{
+			usage: "list [<host_expression>] | show <id> | rerun <id>",
+			help: `Shows and repeats commands recorded in the journal. Every exec, runscript and distribute command
+is appended to the journal file set by main.journal_file (the journal is off unless it's set) as
+a JSON line with the host expression, the hosts it resolved to, user, raise type, mode, exit codes
+of hosts and duration. Output of hosts goes to the file named after the journal with .output suffix,
+it's limited by main.journal_output bytes per host. Output of serial mode goes right to the terminal
+and is not kept. Several xc instances may share the journal. The files are not rotated by xc, use
+logrotate or alike with copytruncate if needed.
+
+Examples:
+    journal list                    - lists the latest entries
+    journal list %web               - lists the latest entries involving hosts of %web
+    journal show 42                 - shows the entry 42 with exit codes and output of every host
+    journal rerun 42                - runs the command of entry 42 again on the same hosts in the same mode
+
+Rerun uses the current user and raise type, a warning is printed if they differ from the ones recorded.`,
+		},
+
+		"set": &helpItem{
+			usage: "[<name> [<value>]]",
+			help: `Sets a variable expanded in commands as $name or ${name}. "set <name>" without a value
+removes the variable. When called without arguments, prints all the variables. See
+"help scripting" for more info.`,
+		},
+
 		"ssh": &helpItem{
 			usage: "<host_expression>",
 			help: `Starts ssh session to hosts one by one, raising the privileges if raise type is not "none" 
@@ -311,12 +698,43 @@ and exits.`,
 xc moves on to the next server.`,
 		},
 
+		"stop_on_error": &helpItem{
+			usage: "[on/off]",
+			help: `Makes scripts stop at the first failed command. A command fails when some of the hosts
+fail or it can't be run at all. When called without arguments, prints the current value.`,
+		},
+
 		"threads": &helpItem{
 			usage: "[num_threads]",
 			help: `Sets max number of simultaneously running ssh threads to <num_threads>. When called
 without arguments, prints the current value.`,
 		},
 
+		"timeout": &helpItem{
+			usage: "[<seconds>]",
+			help: `Limits the time a command may run on every host. When the limit is reached only the overdue
+command is killed while the others keep running. Timed out hosts are counted as failed and listed
+separately in the execution summary. "timeout 0" switches the limit off. When called without
+arguments, prints the current value.
+
+The timeout applies to exec and runscript commands in every mode. Interactive ssh sessions
+are never limited.`,
+		},
+
+		"transport": &helpItem{
+			usage: "[openssh/native]",
+			help: `Sets the transport used by exec, runscript and distribute commands. When called without arguments,
+prints the current value.
+
+The ` + term.Colored("openssh", term.CWhite, true) + ` transport forks ssh and scp processes for every host.
+
+The ` + term.Colored("native", term.CWhite, true) + ` transport uses a built-in ssh client which authenticates with ssh-agent keys
+and key files listed in executer.ssh_keys config option. It doesn't fork any processes so it's a lot
+lighter on big lists of hosts, and it reports real exit codes of remote commands.
+
+Interactive ssh sessions and serial mode always use openssh.`,
+		},
+
 		"user": &helpItem{
 			usage: "<username>",
 			help:  `Sets the username for all the execution commands. This is used to get access to hosts via ssh/scp.`,
@@ -330,7 +748,9 @@ func (c *Cli) doHelp(name string, argsLine string, args ...string) {
 		return
 	}
 
-	if hs, found := helpStrings[args[0]]; found {
+	if al, found := c.aliases[args[0]]; found {
+		c.aliasHelp(al)
+	} else if hs, found := helpStrings[args[0]]; found {
 		if hs.isTopic {
 			fmt.Printf("\nTopic: %s\n\n", term.Colored(args[0], term.CWhite, true))
 		} else {
@@ -347,29 +767,43 @@ func (c *Cli) doHelp(name string, argsLine string, args ...string) {
 }
 
 func generalHelp() {
-	fmt.Println(`
+	fmt.Print(`
 List of commands:
     alias                                  creates a local alias command
+    batch_size/batch_pause/max_errors      set parameters of the rolling mode
     cd                                     changes current working directory
     collapse                               shortcut for "mode collapse"
+    config                                 shows and changes settings of the config file
+    connections                            lists or drops persistent connections
     debug                                  one shouldn't use this
     delay                                  sets a delay between hosts in serial mode
     distribute                             copies a file to a number of hosts in parallel
-    exec/c_exec/s_exec/p_exec              executes a remote command on a number of hosts
+    exec/c_exec/s_exec/p_exec/r_exec       executes a remote command on a number of hosts
     exit                                   exits the xc
     help                                   shows help on various topics
     hostlist                               resolves a host expression to a list of hosts
     interpreter							   sets interpreter for each type of privileges raising
+    inventory                              shows inventory snapshots and changes between them
+    journal                                lists, shows and repeats commands executed before
     local                                  starts a local command
     mode                                   switches between execution modes
+    output_format                          sets the format of exec results
     parallel                               shortcut for "mode parallel"
     passwd                                 sets passwd for privilege raise
     progressbar                            controls progressbar
     raise                                  sets the privilege raise mode
     reload                                 reloads hosts and groups data from inventoree
+    retry                                  repeats the last command on failed hosts
+    rolling                                shortcut for "mode rolling"
     runscript                              runs a local script on a number of remote hosts
     serial                                 shortcut for "mode serial"
+    set                                    sets a variable expanded in commands
+    settings                               saves the session settings to the config file
     ssh                                    starts ssh session to a number of hosts sequentally
+    stop_on_error                          makes scripts stop at the first failed command
+    timeout                                limits the time a command may run on a host
+    transport                              switches between openssh and native ssh transports
     user                                   sets current user
+
 `)
 }
@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"backend"
+	"sort"
+	"sync"
+
+	"github.com/chzyer/readline"
+)
+
+// maxRecentHosts limits the number of hosts remembered as recently used
+const maxRecentHosts = 1000
+
+// hostSearch completes hosts by a part of the name. Readline can only
+// append completions to the line, so when no host name starts with the
+// fragment typed, the fragment is replaced with the best match found by
+// the backend and pressing tab again puts the next match instead.
+//
+// Completion runs in the readline goroutine while hosts are marked as
+// used by commands in the main one, recent is protected by lock
+type hostSearch struct {
+	// setLine replaces the line being edited
+	setLine func(string)
+	// key is the last key pressed
+	key rune
+
+	// the line being completed split by the cursor position
+	line    []rune
+	postfix []rune
+
+	// head is the part of the line before the match put into it
+	head    string
+	matches []string
+	current int
+
+	lock   sync.Mutex
+	recent map[string]int
+	seq    int
+}
+
+func newHostSearch() *hostSearch {
+	return &hostSearch{recent: make(map[string]int)}
+}
+
+// filterInput is a readline input filter recording the keys pressed
+func (hs *hostSearch) filterInput(r rune) (rune, bool) {
+	hs.key = r
+	return r, true
+}
+
+// used marks the hosts as recently used
+func (hs *hostSearch) used(hosts []string) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	for _, host := range hosts {
+		hs.seq++
+		hs.recent[host] = hs.seq
+	}
+	if len(hs.recent) <= maxRecentHosts {
+		return
+	}
+
+	// forget the hosts used long ago
+	seqs := make([]int, 0, len(hs.recent))
+	for _, seq := range hs.recent {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	oldest := seqs[len(seqs)-maxRecentHosts]
+	for host, seq := range hs.recent {
+		if seq < oldest {
+			delete(hs.recent, host)
+		}
+	}
+}
+
+// rank orders hostnames by the time they were used, recently used
+// ones go first and the order of the rest is kept
+func (hs *hostSearch) rank(prefix string, names []string) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	if len(hs.recent) == 0 {
+		return
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return hs.recent[prefix+names[i]] > hs.recent[prefix+names[j]]
+	})
+}
+
+// cycle puts the next match of the last search into the line if
+// the line has not been changed since the previous one was put
+func (hs *hostSearch) cycle() bool {
+	if hs.matches == nil {
+		return false
+	}
+	if hs.key != readline.CharTab || string(hs.line) != hs.head+hs.matches[hs.current] {
+		hs.matches = nil
+		return false
+	}
+	hs.current = (hs.current + 1) % len(hs.matches)
+	hs.setLine(hs.head + hs.matches[hs.current])
+	return true
+}
+
+// search looks up hosts containing the fragment at the end of the line
+// and replaces the fragment with the best of them
+func (hs *hostSearch) search(searcher backend.HostSearcher, fragment []rune) {
+	// the line is only changed by tab and when the cursor is at the end,
+	// as setting the line moves the cursor there
+	if hs.setLine == nil || hs.key != readline.CharTab || len(hs.postfix) > 0 || len(fragment) == 0 {
+		return
+	}
+	found := searcher.SearchHosts(string(fragment))
+	if len(found) == 0 {
+		return
+	}
+	matches := make([]string, len(found))
+	for i, m := range found {
+		matches[i] = m.Name
+	}
+	hs.rank("", matches)
+
+	hs.head = string(hs.line[:len(hs.line)-len(fragment)])
+	hs.matches = matches
+	hs.current = 0
+	hs.setLine(hs.head + matches[0])
+}
@@ -0,0 +1,214 @@
+package cli
+
+import (
+	"executer"
+	"fmt"
+	"journal"
+	"remote"
+	"strconv"
+	"strings"
+	"term"
+	"time"
+)
+
+// journalListSize is the number of the latest entries "journal list" shows
+const journalListSize = 30
+
+func raiseName(rt remote.RaiseType) string {
+	for name, t := range raiseTypeMap {
+		if t == rt {
+			return name
+		}
+	}
+	return ""
+}
+
+// record adds the command executed to the journal
+func (c *Cli) record(le *lastExec, expr string, hosts []string, started time.Time, r *executer.ExecResult) {
+	if c.journal == nil {
+		return
+	}
+	e := &journal.Entry{
+		Time:     started,
+		Command:  le.handler,
+		Args:     le.args,
+		Expr:     expr,
+		Hosts:    hosts,
+		User:     c.user,
+		Raise:    raiseName(c.raiseType),
+		Mode:     modeMap[le.mode],
+		Codes:    r.Codes,
+		Duration: time.Since(started).Seconds(),
+	}
+	if len(r.Output) > 0 {
+		e.Output = make(map[string]string)
+		for host, output := range r.Output {
+			e.Output[host] = string(output)
+		}
+	}
+	err := c.journal.Add(e)
+	if err != nil {
+		term.Errorf("Error writing journal: %s\n", err)
+	}
+}
+
+func (c *Cli) doJournal(name string, argsLine string, args ...string) {
+	if c.journal == nil {
+		term.Errorf("Journal is switched off, set main.journal_file to switch it on\n")
+		return
+	}
+	if len(args) < 1 {
+		term.Errorf("Usage: journal list [<host_expression>] | show <id> | rerun <id>\n")
+		return
+	}
+
+	switch args[0] {
+	case "list":
+		_, expr := wsSplit([]rune(argsLine))
+		c.journalList(expr)
+	case "show", "rerun":
+		if len(args) != 2 {
+			term.Errorf("Usage: journal %s <id>\n", args[0])
+			return
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+		if err != nil {
+			term.Errorf("Invalid journal entry id %s\n", args[1])
+			return
+		}
+		e, err := c.journal.Get(id)
+		if err != nil {
+			term.Errorf("Error reading journal: %s\n", err)
+			c.fail(ExitError)
+			return
+		}
+		if args[0] == "show" {
+			journalShow(e)
+		} else {
+			c.journalRerun(e)
+		}
+	default:
+		term.Errorf("Unknown journal subcommand %s, use one of \"list\", \"show\" and \"rerun\"\n", args[0])
+	}
+}
+
+// journalList prints the latest entries of the journal, only the ones
+// involving hosts of the expression if it's given
+func (c *Cli) journalList(expr []rune) {
+	entries, err := c.journal.List()
+	if err != nil {
+		term.Errorf("Error reading journal: %s\n", err)
+		c.fail(ExitError)
+		return
+	}
+
+	if len(expr) > 0 {
+		hosts, err := c.backend.HostList(expr)
+		if err != nil {
+			term.Errorf("Error parsing expression %s: %s\n", string(expr), err)
+			c.fail(ExitError)
+			return
+		}
+		wanted := make(map[string]bool)
+		for _, host := range hosts {
+			wanted[host] = true
+		}
+		filtered := make([]*journal.Entry, 0)
+		for _, e := range entries {
+			for _, host := range e.Hosts {
+				if wanted[host] {
+					filtered = append(filtered, e)
+					break
+				}
+			}
+		}
+		entries = filtered
+	}
+
+	if len(entries) == 0 {
+		term.Warnf("No journal entries found\n")
+		return
+	}
+	if len(entries) > journalListSize {
+		entries = entries[len(entries)-journalListSize:]
+	}
+	for _, e := range entries {
+		fmt.Printf("%s  %s  %-8s  %-12s  %s %s %s  %s\n",
+			term.Blue(fmt.Sprintf("%5d", e.ID)),
+			e.Time.Local().Format("2006-01-02 15:04:05"),
+			e.Mode,
+			entryUser(e),
+			e.Command,
+			e.Expr,
+			e.Args,
+			entryStatus(e),
+		)
+	}
+}
+
+func entryUser(e *journal.Entry) string {
+	if e.Raise == "" || e.Raise == "none" {
+		return e.User
+	}
+	return e.User + "/" + e.Raise
+}
+
+// entryStatus sums up the results of hosts
+func entryStatus(e *journal.Entry) string {
+	failed := 0
+	for _, code := range e.Codes {
+		if code != 0 {
+			failed++
+		}
+	}
+	status := fmt.Sprintf("[%d ok, %d failed", len(e.Codes)-failed, failed)
+	if skipped := len(e.Hosts) - len(e.Codes); skipped > 0 {
+		status += fmt.Sprintf(", %d skipped", skipped)
+	}
+	status += fmt.Sprintf(", %.1fs]", e.Duration)
+	if failed > 0 || len(e.Codes) < len(e.Hosts) {
+		return term.Red(status)
+	}
+	return term.Green(status)
+}
+
+func journalShow(e *journal.Entry) {
+	fmt.Printf("%s %d\n", term.Yellow("Entry:   "), e.ID)
+	fmt.Printf("%s %s\n", term.Yellow("Time:    "), e.Time.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("%s %s %s %s\n", term.Yellow("Command: "), e.Command, e.Expr, e.Args)
+	fmt.Printf("%s %s\n", term.Yellow("Mode:    "), e.Mode)
+	fmt.Printf("%s %s\n", term.Yellow("User:    "), entryUser(e))
+	fmt.Printf("%s %.1fs\n", term.Yellow("Duration:"), e.Duration)
+	fmt.Printf("%s %s\n\n", term.Yellow("Result:  "), entryStatus(e))
+
+	for _, host := range e.Hosts {
+		code, found := e.Codes[host]
+		switch {
+		case !found:
+			fmt.Printf("%s %s\n", term.Blue(host), term.Yellow("skipped"))
+		case code == 0:
+			fmt.Printf("%s %s\n", term.Blue(host), term.Green("exit code 0"))
+		default:
+			fmt.Printf("%s %s\n", term.Blue(host), term.Red(fmt.Sprintf("exit code %d", code)))
+		}
+		if output := e.Output[host]; output != "" {
+			fmt.Print(output)
+			if !strings.HasSuffix(output, "\n") {
+				fmt.Println()
+			}
+		}
+	}
+}
+
+// journalRerun repeats the command of the entry on the same hosts
+// in the same mode, using the current user and raise type
+func (c *Cli) journalRerun(e *journal.Entry) {
+	mode, found := parseMode(e.Mode)
+	if !found {
+		mode = c.mode
+	}
+	if e.User != c.user || e.Raise != raiseName(c.raiseType) {
+		term.Warnf("The entry was run as %s, rerunning as %s\n", entryUser(e), entryUser(&journal.Entry{User: c.user, Raise: raiseName(c.raiseType)}))
+	}
+	c.repeat(&lastExec{e.Command, mode, e.Args}, strings.Join(e.Hosts, ","))
+}
@@ -0,0 +1,283 @@
+package cli
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"term"
+)
+
+type stmtKind int
+
+const (
+	stmtCommand stmtKind = iota
+	stmtIf
+	stmtFor
+)
+
+// statement is a single command or a block of them. Scripts are
+// the rcfile, batch files and commands typed in the shell
+type statement struct {
+	kind   stmtKind
+	lineNo int
+	// line is the command of stmtCommand
+	line string
+	// cond is the condition of stmtIf
+	cond string
+	// varName and expr are the loop variable and the host expression of stmtFor
+	varName string
+	expr    string
+
+	body     []*statement
+	elseBody []*statement
+}
+
+// errIncomplete is returned by parseScript when a block is not closed yet
+var errIncomplete = fmt.Errorf("block is not closed with end")
+
+var (
+	variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	variableRef  = regexp.MustCompile(`\$(\{[A-Za-z_][A-Za-z0-9_]*\}|[A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// scriptParser builds statements of script lines
+type scriptParser struct {
+	lines []string
+	pos   int
+}
+
+// parseScript parses script lines, empty lines and lines
+// starting with # are skipped
+func parseScript(lines []string) ([]*statement, error) {
+	sp := &scriptParser{lines: lines}
+	stmts, closing, err := sp.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	if closing != "" {
+		return nil, fmt.Errorf("line %d: %s without if or for", sp.pos, closing)
+	}
+	return stmts, nil
+}
+
+// parseBlock parses statements until the end of the script or
+// a closing keyword which is returned
+func (sp *scriptParser) parseBlock() ([]*statement, string, error) {
+	stmts := make([]*statement, 0)
+	for sp.pos < len(sp.lines) {
+		line := strings.TrimSpace(sp.lines[sp.pos])
+		sp.pos++
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		keyword, rest := wsSplit([]rune(line))
+		st := &statement{kind: stmtCommand, lineNo: sp.pos, line: line}
+		switch string(keyword) {
+		case "end", "else":
+			if rest != nil {
+				return nil, "", fmt.Errorf("line %d: %s takes no arguments", sp.pos, string(keyword))
+			}
+			return stmts, string(keyword), nil
+
+		case "if":
+			if rest == nil {
+				return nil, "", fmt.Errorf("line %d: if needs a condition", sp.pos)
+			}
+			st.kind = stmtIf
+			st.cond = string(rest)
+			err := sp.parseBody(st, true)
+			if err != nil {
+				return nil, "", err
+			}
+
+		case "for":
+			tokens := exprWhiteSpace.Split(string(rest), -1)
+			if len(tokens) != 3 || tokens[1] != "in" || !variableName.MatchString(tokens[0]) {
+				return nil, "", fmt.Errorf("line %d: for loop must look like \"for <var> in <host_expression>\"", sp.pos)
+			}
+			st.kind = stmtFor
+			st.varName = tokens[0]
+			st.expr = tokens[2]
+			err := sp.parseBody(st, false)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		stmts = append(stmts, st)
+	}
+	return stmts, "", nil
+}
+
+// parseBody parses the block of if or for up to its end
+func (sp *scriptParser) parseBody(st *statement, elseAllowed bool) error {
+	body, closing, err := sp.parseBlock()
+	if err != nil {
+		return err
+	}
+	st.body = body
+	if closing == "else" && elseAllowed {
+		st.elseBody, closing, err = sp.parseBlock()
+		if err != nil {
+			return err
+		}
+	}
+	switch closing {
+	case "end":
+		return nil
+	case "":
+		return errIncomplete
+	default:
+		return fmt.Errorf("line %d: unexpected %s", sp.pos, closing)
+	}
+}
+
+// runScript runs the statements. It returns false if the script is
+// stopped by exit or by a failed command when stop_on_error is on
+func (c *Cli) runScript(stmts []*statement, echo bool) bool {
+	for _, st := range stmts {
+		if c.stopped {
+			return false
+		}
+		switch st.kind {
+		case stmtCommand:
+			if echo {
+				fmt.Println(term.Green(st.line))
+			}
+			c.cmdFailed = false
+			c.aliasRecursionCount = maxAliasRecursion
+			c.OneCmd(st.line)
+			if c.cmdFailed && c.stopOnError {
+				term.Errorf("Stopping as the command failed: %s\n", st.line)
+				return false
+			}
+
+		case stmtIf:
+			ok, err := c.condition(st.cond)
+			if err != nil {
+				term.Errorf("Line %d: %s\n", st.lineNo, err)
+				c.fail(ExitError)
+				return false
+			}
+			body := st.elseBody
+			if ok {
+				body = st.body
+			}
+			if !c.runScript(body, echo) {
+				return false
+			}
+
+		case stmtFor:
+			expr := c.expandVars(st.expr)
+			hosts, err := c.backend.HostList([]rune(expr))
+			if err != nil {
+				term.Errorf("Line %d: error parsing expression %s: %s\n", st.lineNo, expr, err)
+				c.fail(ExitError)
+				return false
+			}
+			prev, defined := c.vars[st.varName]
+			ok := true
+			for _, host := range hosts {
+				c.vars[st.varName] = host
+				if ok = c.runScript(st.body, echo); !ok {
+					break
+				}
+			}
+			// the loop variable doesn't outlive the loop
+			if defined {
+				c.vars[st.varName] = prev
+			} else {
+				delete(c.vars, st.varName)
+			}
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// condition evaluates a condition of if
+func (c *Cli) condition(cond string) (bool, error) {
+	tokens := exprWhiteSpace.Split(strings.TrimSpace(cond), -1)
+	negate := false
+	if tokens[0] == "not" {
+		negate = true
+		tokens = tokens[1:]
+	}
+	if len(tokens) != 1 {
+		return false, fmt.Errorf("invalid condition %s", cond)
+	}
+
+	var res bool
+	switch tokens[0] {
+	case "last_failed":
+		res = c.cmdFailed
+	case "last_ok":
+		res = !c.cmdFailed
+	default:
+		return false, fmt.Errorf("unknown condition %s", tokens[0])
+	}
+	return res != negate, nil
+}
+
+// expandVars replaces $VAR and ${VAR} with values of variables. Unknown
+// variables are left as is since they may belong to remote commands
+func (c *Cli) expandVars(line string) string {
+	if len(c.vars) == 0 {
+		return line
+	}
+	return variableRef.ReplaceAllStringFunc(line, func(ref string) string {
+		name := strings.Trim(ref[1:], "{}")
+		if value, found := c.vars[name]; found {
+			return value
+		}
+		return ref
+	})
+}
+
+func (c *Cli) doSet(name string, argsLine string, args ...string) {
+	varName, value := wsSplit([]rune(argsLine))
+	if len(varName) == 0 {
+		names := make([]string, 0, len(c.vars))
+		for name := range c.vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s = %s\n", name, c.vars[name])
+		}
+		return
+	}
+	if !variableName.MatchString(string(varName)) {
+		term.Errorf("Invalid variable name %s\n", string(varName))
+		c.fail(ExitError)
+		return
+	}
+	if value == nil {
+		delete(c.vars, string(varName))
+		return
+	}
+	c.vars[string(varName)] = string(value)
+}
+
+func (c *Cli) doStopOnError(name string, argsLine string, args ...string) {
+	if len(args) < 1 {
+		value := "off"
+		if c.stopOnError {
+			value = "on"
+		}
+		term.Warnf("stop_on_error is %s\n", value)
+		return
+	}
+
+	switch args[0] {
+	case "on":
+		c.stopOnError = true
+	case "off":
+		c.stopOnError = false
+	default:
+		term.Errorf("Invalid stop_on_error value. Use either \"on\" or \"off\"\n")
+	}
+}
@@ -0,0 +1,261 @@
+package cli
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testBackend resolves host expressions by a map
+type testBackend map[string][]string
+
+func (b testBackend) Reload() error { return nil }
+func (b testBackend) Load() error   { return nil }
+func (b testBackend) HostList(expr []rune) ([]string, error) {
+	hosts, found := b[string(expr)]
+	if !found {
+		return nil, fmt.Errorf("unknown expression %s", string(expr))
+	}
+	return hosts, nil
+}
+func (b testBackend) CompleteHost(line string) []string       { return nil }
+func (b testBackend) CompleteGroup(line string) []string      { return nil }
+func (b testBackend) CompleteWorkGroup(line string) []string  { return nil }
+func (b testBackend) CompleteDatacenter(line string) []string { return nil }
+func (b testBackend) DatacenterPath(host string) []string     { return nil }
+
+// newTestCli creates a Cli with the set command, a "run" command
+// recording its arguments to the slice returned and a "fail" command
+func newTestCli(b testBackend) (*Cli, *[]string) {
+	c := &Cli{
+		backend:  b,
+		handlers: make(map[string]cmdHandler),
+		vars:     make(map[string]string),
+	}
+	run := make([]string, 0)
+	c.handlers["set"] = c.doSet
+	c.handlers["run"] = func(name string, argsLine string, args ...string) {
+		run = append(run, argsLine)
+	}
+	c.handlers["fail"] = func(name string, argsLine string, args ...string) {
+		c.fail(ExitError)
+	}
+	return c, &run
+}
+
+// dumpScript renders statements in a compact form to compare them
+func dumpScript(stmts []*statement) string {
+	parts := make([]string, 0, len(stmts))
+	for _, st := range stmts {
+		switch st.kind {
+		case stmtCommand:
+			parts = append(parts, fmt.Sprintf("%d:%s", st.lineNo, st.line))
+		case stmtIf:
+			s := fmt.Sprintf("%d:if %s {%s}", st.lineNo, st.cond, dumpScript(st.body))
+			if st.elseBody != nil {
+				s += fmt.Sprintf(" else {%s}", dumpScript(st.elseBody))
+			}
+			parts = append(parts, s)
+		case stmtFor:
+			parts = append(parts, fmt.Sprintf("%d:for %s in %s {%s}", st.lineNo, st.varName, st.expr, dumpScript(st.body)))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+func TestParseScript(t *testing.T) {
+	data := []struct {
+		script   string
+		expected string
+	}{
+		{"", ""},
+		{"# comment\n\nrun a\n  run b  ", "3:run a; 4:run b"},
+		{"if last_ok\nrun a\nend", "1:if last_ok {2:run a}"},
+		{"if not last_failed\nrun a\nelse\nrun b\nend", "1:if not last_failed {2:run a} else {4:run b}"},
+		{"if last_ok\nelse\nend", "1:if last_ok {} else {}"},
+		{"for h in %web\nrun $h\nend", "1:for h in %web {2:run $h}"},
+		{
+			"for h in %web\n  if last_failed\n    for d in %db\n      run $h $d\n    end\n  else\n    run $h\n  end\nend\nrun done",
+			"1:for h in %web {2:if last_failed {3:for d in %db {4:run $h $d}} else {7:run $h}}; 10:run done",
+		},
+		// words starting like keywords are commands
+		{"ending\niffy\nforce", "1:ending; 2:iffy; 3:force"},
+	}
+	for _, d := range data {
+		stmts, err := parseScript(strings.Split(d.script, "\n"))
+		if err != nil {
+			t.Errorf("error parsing %q: %s", d.script, err)
+			continue
+		}
+		if dump := dumpScript(stmts); dump != d.expected {
+			t.Errorf("%q expected to be parsed as %q, got %q", d.script, d.expected, dump)
+		}
+	}
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	data := []struct {
+		script     string
+		incomplete bool
+	}{
+		{"if last_ok\nrun a", true},
+		{"if last_ok\nrun a\nelse\nrun b", true},
+		{"for h in %web\nif last_ok\nrun a\nend", true},
+		{"for h in %web", true},
+		{"end", false},
+		{"else", false},
+		{"run a\nend\n", false},
+		{"if last_ok\nrun a\nend extra", false},
+		{"if\nend", false},
+		{"for h %web\nend", false},
+		{"for 1h in %web\nend", false},
+		{"for h in %web %db\nend", false},
+		{"for h in %web\nrun a\nelse\nend", false},
+		{"if last_ok\nelse\nelse\nend", false},
+	}
+	for _, d := range data {
+		_, err := parseScript(strings.Split(d.script, "\n"))
+		if err == nil {
+			t.Errorf("parsing %q must fail", d.script)
+			continue
+		}
+		if (err == errIncomplete) != d.incomplete {
+			t.Errorf("parsing %q: unexpected error %v", d.script, err)
+		}
+	}
+}
+
+// TestParseScriptContinuation feeds lines one by one like the shell does
+// while it gets errIncomplete and shows the "...> " prompt
+func TestParseScriptContinuation(t *testing.T) {
+	lines := []string{"for h in %web", "if last_ok", "run $h", "end", "end"}
+	block := make([]string, 0)
+	for i, line := range lines {
+		block = append(block, line)
+		stmts, err := parseScript(block)
+		if i < len(lines)-1 {
+			if err != errIncomplete {
+				t.Fatalf("line %d: errIncomplete expected, got %v", i+1, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dump := dumpScript(stmts); dump != "1:for h in %web {2:if last_ok {3:run $h}}" {
+			t.Errorf("unexpected script %q", dump)
+		}
+	}
+}
+
+func TestCondition(t *testing.T) {
+	data := []struct {
+		cond      string
+		cmdFailed bool
+		expected  bool
+	}{
+		{"last_ok", false, true},
+		{"last_ok", true, false},
+		{"last_failed", true, true},
+		{"last_failed", false, false},
+		{"not last_failed", false, true},
+		{"not   last_ok", false, false},
+		{" last_ok ", false, true},
+	}
+	for _, d := range data {
+		c, _ := newTestCli(nil)
+		c.cmdFailed = d.cmdFailed
+		res, err := c.condition(d.cond)
+		if err != nil {
+			t.Errorf("error evaluating %q: %s", d.cond, err)
+			continue
+		}
+		if res != d.expected {
+			t.Errorf("%q expected to be %v when cmdFailed is %v", d.cond, d.expected, d.cmdFailed)
+		}
+	}
+
+	c, _ := newTestCli(nil)
+	for _, cond := range []string{"ok", "not", "not not last_ok", "last_ok last_failed"} {
+		if _, err := c.condition(cond); err == nil {
+			t.Errorf("condition %q must be invalid", cond)
+		}
+	}
+}
+
+func TestExpandVars(t *testing.T) {
+	c, _ := newTestCli(nil)
+	c.vars["GRP"] = "%web"
+	c.vars["h"] = "web1"
+	data := map[string]string{
+		"exec $GRP uptime":      "exec %web uptime",
+		"exec ${GRP}x uptime":   "exec %webx uptime",
+		"exec $GRPx uptime":     "exec $GRPx uptime",
+		"ssh $h":                "ssh web1",
+		"exec $h echo $HOME":    "exec web1 echo $HOME",
+		"exec $h echo ${HOME}":  "exec web1 echo ${HOME}",
+		"exec $h echo $$h $1 $": "exec web1 echo $web1 $1 $",
+		"no variables":          "no variables",
+		"${h}${GRP}":            "web1%web",
+	}
+	for line, expected := range data {
+		if expanded := c.expandVars(line); expanded != expected {
+			t.Errorf("%q expected to expand to %q, got %q", line, expected, expanded)
+		}
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	b := testBackend{"%web": {"web1", "web2"}, "%db": {"db1"}}
+	data := []struct {
+		script   string
+		expected []string
+	}{
+		{"run a\nfail\nif last_failed\nrun failed\nelse\nrun ok\nend", []string{"a", "failed"}},
+		{"run a\nif last_failed\nrun failed\nelse\nrun ok\nend", []string{"a", "ok"}},
+		{"for h in %web\nfor d in %db\nrun $h $d\nend\nend", []string{"web1 db1", "web2 db1"}},
+		{"set GRP %db\nfor h in $GRP\nrun $h\nend", []string{"db1"}},
+		// the loop variable is unset after the loop
+		{"for h in %web\nrun $h\nend\nrun $h", []string{"web1", "web2", "$h"}},
+		// and restored if it has been set before
+		{"set h x\nfor h in %web\nend\nrun $h", []string{"x"}},
+		{"set h x\nfor h in %web\nfor h in %db\nrun $h\nend\nrun $h\nend\nrun $h", []string{"db1", "web1", "db1", "web2", "x"}},
+	}
+	for _, d := range data {
+		c, run := newTestCli(b)
+		stmts, err := parseScript(strings.Split(d.script, "\n"))
+		if err != nil {
+			t.Errorf("error parsing %q: %s", d.script, err)
+			continue
+		}
+		if !c.runScript(stmts, false) {
+			t.Errorf("script %q expected to run to the end", d.script)
+		}
+		if !reflect.DeepEqual(*run, d.expected) {
+			t.Errorf("script %q expected to run %q, got %q", d.script, d.expected, *run)
+		}
+	}
+}
+
+func TestRunScriptStopOnError(t *testing.T) {
+	b := testBackend{"%web": {"web1", "web2"}}
+	c, run := newTestCli(b)
+	c.stopOnError = true
+	stmts, err := parseScript([]string{"set h x", "for h in %web", "run $h", "fail", "end", "run after"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.runScript(stmts, false) {
+		t.Error("script expected to stop")
+	}
+	if !reflect.DeepEqual(*run, []string{"web1"}) {
+		t.Errorf("script expected to stop after the first host, got %q", *run)
+	}
+	if c.vars["h"] != "x" {
+		t.Errorf("loop variable expected to be restored when the loop stops, got %q", c.vars["h"])
+	}
+	if c.exitCode != ExitError {
+		t.Errorf("exit code %d expected, got %d", ExitError, c.exitCode)
+	}
+}
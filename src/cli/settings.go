@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"config"
+	"fmt"
+	"strconv"
+	"strings"
+	"term"
+)
+
+// secretOptions are names of options whose values are not shown,
+// they apply to options of backend sections as well
+var secretOptions = map[string]bool{
+	"password": true,
+	"token":    true,
+}
+
+// displayValue masks the value of a secret option
+func displayValue(key string, value string) string {
+	if value != "" && secretOptions[key[strings.LastIndex(key, ".")+1:]] {
+		return "********"
+	}
+	return value
+}
+
+// sessionSettings returns the config file options
+// reflecting the settings changed in the session
+func (c *Cli) sessionSettings() []config.Option {
+	user := c.user
+	if !c.userSet {
+		// the default user is left to be the local one
+		user = ""
+	}
+	return []config.Option{
+		{Key: "main.mode", Value: modeMap[c.mode]},
+		{Key: "main.user", Value: user},
+		{Key: "executer.delay", Value: strconv.Itoa(c.delay)},
+		{Key: "executer.ssh_threads", Value: strconv.Itoa(c.sshThreads)},
+		{Key: "executer.interpreter", Value: c.interpreter},
+		{Key: "executer.interpreter_sudo", Value: c.sudoInterpreter},
+		{Key: "executer.interpreter_su", Value: c.suInterpreter},
+	}
+}
+
+func (c *Cli) doSettings(name string, argsLine string, args ...string) {
+	if len(args) != 1 || args[0] != "save" {
+		term.Errorf("Usage: settings save\n")
+		return
+	}
+	err := config.SetOptions(c.configFile, c.sessionSettings()...)
+	if err != nil {
+		term.Errorf("Error saving settings: %s\n", err)
+		c.fail(ExitError)
+		return
+	}
+	term.Successf("Settings saved to %s\n", c.configFile)
+}
+
+func (c *Cli) doConfig(name string, argsLine string, args ...string) {
+	if len(args) < 1 {
+		term.Errorf("Usage: config show [<section>[.<key>]] or config set <section>.<key> <value>\n")
+		return
+	}
+
+	switch args[0] {
+	case "show":
+		options, err := config.ListOptions(c.configFile)
+		if err != nil {
+			term.Errorf("Error reading config: %s\n", err)
+			c.fail(ExitError)
+			return
+		}
+		filter := ""
+		if len(args) > 1 {
+			filter = args[1]
+		}
+		shown := 0
+		for _, opt := range options {
+			if filter == "" || opt.Key == filter || strings.HasPrefix(opt.Key, filter+".") {
+				fmt.Printf("%s = %s\n", term.Blue(opt.Key), displayValue(opt.Key, opt.Value))
+				shown++
+			}
+		}
+		if shown == 0 && filter != "" {
+			term.Warnf("%s is not set in %s\n", filter, c.configFile)
+		}
+
+	case "set":
+		_, rest := wsSplit([]rune(argsLine))
+		key, value := wsSplit(rest)
+		if len(key) == 0 || !strings.Contains(string(key), ".") {
+			term.Errorf("Usage: config set <section>.<key> <value>\n")
+			return
+		}
+		err := config.SetOptions(c.configFile, config.Option{Key: string(key), Value: string(value)})
+		if err != nil {
+			term.Errorf("Error saving config: %s\n", err)
+			c.fail(ExitError)
+			return
+		}
+		term.Successf("%s is set to \"%s\", restart xc to apply it\n", string(key), displayValue(string(key), string(value)))
+
+	default:
+		term.Errorf("Unknown config subcommand %s, use either \"show\" or \"set\"\n", args[0])
+	}
+}
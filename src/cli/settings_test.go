@@ -0,0 +1,25 @@
+package cli
+
+import "testing"
+
+func TestDisplayValue(t *testing.T) {
+	data := []struct {
+		key      string
+		value    string
+		expected string
+	}{
+		{"main.user", "root", "root"},
+		{"inventoree.user", "admin", "admin"},
+		{"inventoree.password", "secret", "********"},
+		{"inventoree.token", "abc", "********"},
+		{"backend.prod.password", "secret", "********"},
+		{"backend.prod.token", "abc", "********"},
+		{"inventoree.password", "", ""},
+		{"main.password_file", "~/.pw", "~/.pw"},
+	}
+	for _, d := range data {
+		if value := displayValue(d.key, d.value); value != d.expected {
+			t.Errorf("%s = %s expected to be shown as %q, got %q", d.key, d.value, d.expected, value)
+		}
+	}
+}
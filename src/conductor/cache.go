@@ -1,5 +1,15 @@
 package conductor
 
+import (
+	"hostindex"
+	"parser"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/viert/sekwence"
+)
+
 type dcstore struct {
 	_id  map[string]*Datacenter
 	name map[string]*Datacenter
@@ -20,11 +30,15 @@ type wgstore struct {
 	name map[string]*WorkGroup
 }
 
+// store indexes the data it's built from. Stores are shared by
+// goroutines without locking so they must not be changed once built
 type store struct {
+	data        *ExecuterRootData
 	datacenters *dcstore
 	groups      *groupstore
 	hosts       *hoststore
 	workgroups  *wgstore
+	hostnames   *hostindex.Index
 }
 
 func newStore() *store {
@@ -41,5 +55,259 @@ func newStore() *store {
 	s.workgroups = new(wgstore)
 	s.workgroups._id = make(map[string]*WorkGroup)
 	s.workgroups.name = make(map[string]*WorkGroup)
+	s.hostnames = hostindex.New(nil)
 	return s
 }
+
+// indexHosts builds the host name index, it must be called
+// when all the hosts are added to the store
+func (s *store) indexHosts() {
+	names := make([]string, 0, len(s.hosts.fqdn))
+	for fqdn := range s.hosts.fqdn {
+		names = append(names, fqdn)
+	}
+	s.hostnames = hostindex.New(names)
+}
+
+func (s *store) completeHost(line string) []string {
+	hostnames := s.hostnames.Prefix(line)
+	res := make([]string, len(hostnames))
+	for i, hostname := range hostnames {
+		res[i] = hostname[len(line):]
+	}
+	return res
+}
+
+func (s *store) completeGroup(line string) []string {
+	expr := line
+	if strings.HasPrefix(expr, "%") {
+		expr = expr[1:]
+	}
+	res := make([]string, 0)
+	for groupname := range s.groups.name {
+		if expr == "" || strings.HasPrefix(groupname, expr) {
+			res = append(res, groupname[len(expr):])
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (s *store) completeWorkGroup(line string) []string {
+	expr := line
+	if strings.HasPrefix(expr, "*") {
+		expr = expr[1:]
+	}
+	res := make([]string, 0)
+	for wgname := range s.workgroups.name {
+		if expr == "" || strings.HasPrefix(wgname, expr) {
+			res = append(res, wgname[len(expr):])
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (s *store) completeDatacenter(line string) []string {
+	expr := line
+	if strings.HasPrefix(expr, "@") {
+		expr = expr[1:]
+	}
+	expr = strings.TrimPrefix(expr, "=")
+	res := make([]string, 0)
+	for dcname := range s.datacenters.name {
+		if expr == "" || strings.HasPrefix(dcname, expr) {
+			res = append(res, dcname[len(expr):])
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// resolveToken returns the list of hosts a single expression token represents
+func (s *store) resolveToken(token *parser.Token) ([]string, error) {
+	hostlist := make([]string, 0)
+
+	switch token.Type {
+	case parser.TTypeHostRegexp:
+		for _, host := range s.matchHost(token.RegexpFilter) {
+			hostlist = append(hostlist, host)
+		}
+	case parser.TTypeHost:
+
+		hosts, err := sekwence.ExpandPattern(token.Value)
+		if err != nil {
+			hosts = []string{token.Value}
+		}
+
+		for _, host := range hosts {
+			if len(token.TagsFilter) > 0 {
+				invhost, found := s.hosts.fqdn[host]
+				if !found {
+					continue
+				}
+				for _, tag := range token.TagsFilter {
+					if !contains(invhost.AllTags, tag) {
+						continue
+					}
+				}
+			}
+			hostlist = append(hostlist, host)
+		}
+
+	case parser.TTypeGroup:
+		if group, found := s.groups.name[token.Value]; found {
+			hosts := group.AllHosts(s)
+
+		hostLoop1:
+			for _, host := range hosts {
+				if token.DatacenterFilter != "" && !matchDatacenter(host, token) {
+					continue
+				}
+
+				for _, tag := range token.TagsFilter {
+					if !contains(host.AllTags, tag) {
+						continue hostLoop1
+					}
+				}
+
+				if token.RegexpFilter != nil {
+					if !token.RegexpFilter.Match([]byte(host.FQDN)) {
+						continue
+					}
+				}
+				hostlist = append(hostlist, host.FQDN)
+			}
+		}
+
+	case parser.TTypePseudoGroup:
+	hostLoop3:
+		for _, host := range parser.PseudoGroup(token.Value) {
+			if token.DatacenterFilter != "" || len(token.TagsFilter) > 0 {
+				invhost, found := s.hosts.fqdn[host]
+				if !found {
+					continue
+				}
+				if token.DatacenterFilter != "" && !matchDatacenter(invhost, token) {
+					continue
+				}
+				for _, tag := range token.TagsFilter {
+					if !contains(invhost.AllTags, tag) {
+						continue hostLoop3
+					}
+				}
+			}
+
+			if token.RegexpFilter != nil {
+				if !token.RegexpFilter.MatchString(host) {
+					continue
+				}
+			}
+			hostlist = append(hostlist, host)
+		}
+
+	case parser.TTypeWorkGroup:
+		workgroups := make([]*WorkGroup, 0)
+		if token.Value == "" {
+			for _, wg := range s.workgroups.name {
+				workgroups = append(workgroups, wg)
+			}
+		} else {
+			wg, found := s.workgroups.name[token.Value]
+			if found {
+				workgroups = []*WorkGroup{wg}
+			}
+		}
+
+		if len(workgroups) > 0 {
+			hosts := make([]*Host, 0)
+			for _, wg := range workgroups {
+				groups := wg.Groups
+				for _, group := range groups {
+					hosts = append(hosts, group.Hosts...)
+				}
+			}
+
+		hostLoop2:
+			for _, host := range hosts {
+				if token.DatacenterFilter != "" && !matchDatacenter(host, token) {
+					continue
+				}
+
+				for _, tag := range token.TagsFilter {
+					if !contains(host.AllTags, tag) {
+						continue hostLoop2
+					}
+				}
+
+				if token.RegexpFilter != nil {
+					if !token.RegexpFilter.Match([]byte(host.FQDN)) {
+						continue
+					}
+				}
+
+				hostlist = append(hostlist, host.FQDN)
+			}
+		}
+	}
+
+	if len(token.AttrFilters) > 0 {
+		filtered := make([]string, 0)
+		for _, host := range hostlist {
+			if token.MatchAttrs(s.hostAttributes(host)) {
+				filtered = append(filtered, host)
+			}
+		}
+		hostlist = filtered
+	}
+	return hostlist, nil
+}
+
+// hostAttributes returns the attributes of a host attribute filters
+// are matched against. Datacenter attribute includes all the parent
+// datacenters so dc=msk matches hosts from any of msk's children
+func (s *store) hostAttributes(hostname string) map[string][]string {
+	attrs := map[string][]string{
+		"fqdn": {hostname},
+	}
+	host, found := s.hosts.fqdn[hostname]
+	if !found {
+		return attrs
+	}
+
+	attrs["alias"] = host.Aliases
+	attrs["tag"] = host.AllTags
+	if group, found := s.groups._id[host.GroupID]; found {
+		attrs["group"] = []string{group.Name}
+		if wg, found := s.workgroups._id[group.WorkGroupID]; found {
+			attrs["workgroup"] = []string{wg.Name}
+		}
+	}
+	attrs["dc"] = s.datacenterPath(hostname)
+	return attrs
+}
+
+func (s *store) matchHost(pattern *regexp.Regexp) []string {
+	res := make([]string, 0)
+	for hostname := range s.hosts.fqdn {
+		if pattern.MatchString(hostname) {
+			res = append(res, hostname)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// datacenterPath returns the names of the host's datacenter
+// and all its ancestors, starting from the root one
+func (s *store) datacenterPath(hostname string) []string {
+	host, found := s.hosts.fqdn[hostname]
+	if !found {
+		return nil
+	}
+	path := make([]string, 0)
+	for _, dc := range datacenterChain(host.Datacenter) {
+		path = append([]string{dc.Name}, path...)
+	}
+	return path
+}
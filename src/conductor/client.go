@@ -0,0 +1,155 @@
+package conductor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"config"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	retryBackoff = time.Second
+	maxErrorBody = 200
+)
+
+// errNotModified is returned when inventoree responds with
+// 304 Not Modified to a conditional request
+var errNotModified = errors.New("not modified")
+
+// newHTTPClient creates a client according to the configuration given
+func newHTTPClient(cfg *config.HTTPConfig) (*http.Client, error) {
+	tlsConfig := new(tls.Config)
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url %s: %s", cfg.Proxy, err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	transport := &http.Transport{
+		Proxy:               proxy,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+		// compression is handled explicitly in doGet()
+		DisableCompression: true,
+	}
+	return &http.Client{Transport: transport, Timeout: cfg.Timeout}, nil
+}
+
+// httpGet requests the url retrying on network errors and server-side
+// failures. Only a successful json response is returned so an error
+// page never gets to the cache. Extra headers are added to the request,
+// response headers are returned along with the body
+func (c *Conductor) httpGet(rawURL string, header http.Header) ([]byte, http.Header, error) {
+	client, err := newHTTPClient(&c.config.HTTP)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		body, respHeader, retry, err := c.doGet(client, rawURL, header)
+		if err == nil {
+			return body, respHeader, nil
+		}
+		if !retry || attempt >= c.config.HTTP.Retries {
+			return nil, respHeader, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// doGet makes a single request, retry reports if the error is
+// temporary and the request is worth repeating
+func (c *Conductor) doGet(client *http.Client, rawURL string, header http.Header) (body []byte, respHeader http.Header, retry bool, err error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Accept-Encoding", "gzip")
+	if c.config.HTTP.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.HTTP.Token)
+	} else if c.config.HTTP.User != "" {
+		req.SetBasicAuth(c.config.HTTP.User, c.config.HTTP.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, resp.Header, false, errNotModified
+	}
+
+	var reader io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, resp.Header, true, fmt.Errorf("error decompressing response: %s", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	body, err = ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, resp.Header, true, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return nil, resp.Header, retry, fmt.Errorf("inventoree responded with %s: %s", resp.Status, errorBody(body))
+	}
+
+	ct := resp.Header.Get("Content-Type")
+	trimmed := bytes.TrimSpace(body)
+	if (ct != "" && !strings.Contains(ct, "json")) || len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil, resp.Header, false, fmt.Errorf("inventoree responded with non-json data (%s): %s", ct, errorBody(body))
+	}
+	return body, resp.Header, false, nil
+}
+
+// errorBody shortens a response body to be shown in an error message
+func errorBody(body []byte) string {
+	s := strings.Join(strings.Fields(string(body)), " ")
+	if len(s) > maxErrorBody {
+		s = s[:maxErrorBody] + "..."
+	}
+	return s
+}
@@ -1,54 +1,86 @@
 package conductor
 
 import (
+	"backend"
+	"config"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hostindex"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"parser"
 	"path"
+	"path/filepath"
 	"regexp"
-	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"term"
 	"time"
-
-	"github.com/viert/sekwence"
 )
 
-// ConductorConfig represents configuration for ConductorClass
-type ConductorConfig struct {
-	CacheTTL      time.Duration
-	CacheDir      string
-	WorkGroupList []string
-	RemoteUrl     string
-}
-
 type Conductor struct {
-	config *ConductorConfig
-	data   *ExecuterRootData
-	cache  *store
+	config *config.ConductorConfig
+
+	// current holds the *store in use. A store is never changed
+	// after it's been built, a refresh builds a new one and swaps it in
+	current atomic.Value
+
+	// lock protects refreshing and background
+	lock       sync.Mutex
+	refreshing bool
+	background bool
 }
 
 var (
-	cGlobal        *Conductor
 	exprWhiteSpace = regexp.MustCompile(`\s+`)
 )
 
+func init() {
+	backend.Register("conductor", func(xc *config.XcConfig) (backend.Backend, error) {
+		return NewConductor(xc.Conductor), nil
+	})
+}
+
 // NewConductor creates a new Conductor instance according to a
 // given configuration
-func NewConductor(config *ConductorConfig) *Conductor {
-	cGlobal = &Conductor{config, &ExecuterRootData{}, newStore()}
-	return cGlobal
+func NewConductor(cfg *config.ConductorConfig) *Conductor {
+	c := &Conductor{config: cfg}
+	c.set(build(&ExecuterRootData{}))
+	return c
 }
 
-func (c *Conductor) getCacheFilename() string {
-	wglist := "all"
+// store returns the current cache store
+func (c *Conductor) store() *store {
+	return c.current.Load().(*store)
+}
+
+// set replaces the cache store with a new one
+func (c *Conductor) set(cache *store) {
+	c.current.Store(cache)
+}
+
+// swap replaces the data and builds the cache store for it
+func (c *Conductor) swap(data *ExecuterRootData) {
+	c.set(build(data))
+}
+
+// cacheKey distinguishes caches of different work group lists
+// and inventoree servers, the latter are told apart by url hashes
+func (c *Conductor) cacheKey() string {
+	key := "all"
 	if len(c.config.WorkGroupList) > 0 {
-		wglist = strings.Join(c.config.WorkGroupList, "_")
+		key = strings.Join(c.config.WorkGroupList, "_")
 	}
-	cacheFilename := "cache_" + wglist + ".json"
+	sum := sha1.Sum([]byte(c.config.RemoteUrl))
+	return key + "_" + hex.EncodeToString(sum[:4])
+}
+
+func (c *Conductor) getCacheFilename() string {
+	cacheFilename := "cache_" + c.cacheKey() + ".json"
 	cacheFilename = path.Join(c.config.CacheDir, cacheFilename)
 	return cacheFilename
 }
@@ -89,331 +121,355 @@ func (c *Conductor) loadJSONCache() ([]byte, error) {
 	return data, nil
 }
 
-func (c *Conductor) loadJSONHTTP() ([]byte, error) {
-	term.Warnf("Reloading data from inventoree\n")
+func (c *Conductor) dataURL() string {
 	wglist := strings.Join(c.config.WorkGroupList, ",")
-	url := fmt.Sprintf("%s/api/v1/open/executer_data?work_groups=%s&recursive=true", c.config.RemoteUrl, wglist)
-	resp, err := http.Get(url)
+	return fmt.Sprintf("%s/api/v1/open/executer_data?work_groups=%s&recursive=true", c.config.RemoteUrl, wglist)
+}
+
+func (c *Conductor) loadJSONHTTP() ([]byte, http.Header, error) {
+	c.warnf("Reloading data from inventoree\n")
+	body, header, err := c.httpGet(c.dataURL(), nil)
+	if err != nil {
+		c.errorf("Error getting data by HTTP: %s\n", err)
+		return nil, nil, err
+	}
+	return body, header, nil
+}
+
+func (c *Conductor) saveCache(data *ExecuterRootData) error {
+	c.snapshotPrevious(c.getCacheFilename())
+	raw, err := c.writeCache(data)
+	if err != nil {
+		return err
+	}
+	c.saveSnapshot(raw, data.CreatedAt)
+	return nil
+}
+
+// writeCache writes the cache file without taking a snapshot, it's
+// used when only the creation time of unchanged data is updated
+func (c *Conductor) writeCache(data *ExecuterRootData) ([]byte, error) {
+	if data == nil {
+		return nil, fmt.Errorf("Nothing to save to cache")
+	}
+	raw, err := json.Marshal(data)
 	if err != nil {
-		term.Errorf("Error getting data by HTTP: %s\n", err)
+		c.errorf("Error encoding cache data, this might be a bug: %s\n", err)
 		return nil, err
 	}
-	body, err := ioutil.ReadAll(resp.Body)
+	cacheFilename := c.getCacheFilename()
+	err = writeFile(cacheFilename, raw)
 	if err != nil {
-		term.Errorf("Error reading data: %s\n", err)
+		c.errorf("Error writing cachefile %s: %s\n", cacheFilename, err)
 		return nil, err
 	}
-	return body, nil
+	return raw, nil
 }
 
-func (c *Conductor) saveCache() error {
-	if c.data == nil {
-		return fmt.Errorf("Nothing to save to cache")
-	}
-	c.data.CreatedAt = time.Now()
-	data, err := json.Marshal(c.data)
+// writeFile writes data to a temporary file in the directory of filename
+// and renames it into place, so the file is never left half written
+// even if xc exits in the middle of a background refresh
+func writeFile(filename string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
 	if err != nil {
-		term.Errorf("Error encoding cache data, this might be a bug: %s\n", err)
 		return err
 	}
-	cacheFilename := c.getCacheFilename()
-	err = ioutil.WriteFile(cacheFilename, data, 0644)
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
 	if err != nil {
-		term.Errorf("Error writing cachefile %s: %s\n", cacheFilename, err)
+		os.Remove(tmp.Name())
 	}
 	return err
 }
 
-func (c *Conductor) decodeJSON(jsonData []byte) error {
-	return json.Unmarshal(jsonData, c.data)
+func decodeJSON(jsonData []byte) (*ExecuterRootData, error) {
+	data := new(ExecuterRootData)
+	err := json.Unmarshal(jsonData, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
 // Load loads groups from cache or inventoree
 func (c *Conductor) Load() error {
-	return c.load(false)
-}
+	var cached *ExecuterRootData
+	raw, err := c.loadJSONCache()
+	if err == nil {
+		cached, err = decodeJSON(raw)
+		if err != nil {
+			term.Errorf("Error decoding cache: %s\n", err)
+		}
+	}
 
-func (c *Conductor) reload() error {
-	data, err := c.loadJSONHTTP()
+	if cached != nil {
+		age := time.Since(cached.CreatedAt)
+		if age < c.config.CacheTTL {
+			c.swap(cached)
+			return nil
+		}
+		if c.backgroundRefresh() && (c.config.CacheHardTTL <= 0 || age < c.config.CacheHardTTL) {
+			// stale data is served while the fresh one is being loaded
+			c.swap(cached)
+			c.startRefresh()
+			return nil
+		}
+	}
+
+	if cached != nil && c.config.Incremental {
+		// the changes are applied to the cached data
+		c.swap(cached)
+	}
+	err = c.refresh()
 	if err != nil {
-		return err
+		if cached == nil {
+			return fmt.Errorf("Can't load data neither from cache nor from http")
+		}
+		// Something's wrong with backend, falling back to expired cache
+		term.Warnf("Using expired cache\n")
+		if !c.config.Incremental {
+			c.swap(cached)
+		}
+	}
+	return nil
+}
+
+// refresh loads fresh data from inventoree and swaps it in
+func (c *Conductor) refresh() error {
+	// there is nothing to sync on a cold start
+	if c.config.Incremental && !c.store().data.CreatedAt.IsZero() {
+		err := c.sync()
+		if err == nil {
+			return nil
+		}
+		c.warnf("Incremental sync failed: %s, reloading all the data\n", err)
 	}
-	err = c.decodeJSON(data)
+	data, err := c.fetch()
 	if err != nil {
-		// Something's wrong with backend, falling back to (expired) cache
 		return err
 	}
-	c.saveCache()
-	c.build()
+	c.swap(data)
 	return nil
 }
 
-func (c *Conductor) load(expiredOk bool) error {
-	var data []byte
-	var err error
+// fetch loads data from inventoree and saves it to the cache file
+func (c *Conductor) fetch() (*ExecuterRootData, error) {
+	startedAt := time.Now()
+	raw, header, err := c.loadJSONHTTP()
+	if err != nil {
+		return nil, err
+	}
+	data, err := decodeJSON(raw)
+	if err != nil {
+		c.errorf("Error decoding data: %s\n", err)
+		return nil, err
+	}
+	data.CreatedAt = startedAt
+	data.ETag = header.Get("ETag")
+	data.LastModified = header.Get("Last-Modified")
+	c.saveCache(data)
+	return data, nil
+}
+
+// SetBackgroundRefresh makes expired data be served while the fresh one
+// is loaded in background. It pays off in an interactive session only,
+// otherwise xc may exit before the refresh is done so the data is
+// refreshed before it's used
+func (c *Conductor) SetBackgroundRefresh(enabled bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.background = enabled
+}
 
-	cacheLoaded := false
-	data, err = c.loadJSONCache()
-	if err == nil {
-		err = c.decodeJSON(data)
-		if err == nil {
-			expTime := c.data.CreatedAt.Add(c.config.CacheTTL)
-			if expTime.After(time.Now()) || expiredOk {
-				cacheLoaded = true
-			}
-		}
+func (c *Conductor) backgroundRefresh() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.background
+}
+
+// warnf and errorf report the progress of a refresh. The messages of
+// a background refresh are dropped as they would break the prompt,
+// the expired cache shown in it tells the refresh has failed
+func (c *Conductor) warnf(format string, args ...interface{}) {
+	if !c.Refreshing() {
+		term.Warnf(format, args...)
 	}
+}
 
-	if !cacheLoaded {
-		if expiredOk {
-			// at this point something's wrong with cache
-			// either it can't be loaded or decoded
-			// and we've already tried http load and failed
-			return fmt.Errorf("Can't load data neither from cache nor from http")
-		}
-		data, err = c.loadJSONHTTP()
-		if err != nil {
-			// Something's wrong with backend, falling back to (expired) cache
-			return c.load(true)
-		}
-		err = c.decodeJSON(data)
-		if err != nil {
-			// Something's wrong with backend, falling back to (expired) cache
-			return c.load(true)
-		}
-		c.saveCache()
+func (c *Conductor) errorf(format string, args ...interface{}) {
+	if !c.Refreshing() {
+		term.Errorf(format, args...)
 	}
-	c.build()
-	return nil
 }
 
-func (c *Conductor) build() {
-	for _, dc := range c.data.Data.Datacenters {
-		c.cache.datacenters._id[dc.ID] = dc
-		c.cache.datacenters.name[dc.Name] = dc
+// startRefresh fetches data from inventoree in background and swaps
+// it in when done. It returns false if a refresh is already running
+func (c *Conductor) startRefresh() bool {
+	c.lock.Lock()
+	if c.refreshing {
+		c.lock.Unlock()
+		return false
+	}
+	c.refreshing = true
+	c.lock.Unlock()
+
+	go func() {
+		c.refresh()
+		c.lock.Lock()
+		c.refreshing = false
+		c.lock.Unlock()
+	}()
+	return true
+}
+
+// CacheAge returns the time passed since the data was loaded from inventoree
+func (c *Conductor) CacheAge() time.Duration {
+	return time.Since(c.store().data.CreatedAt)
+}
+
+// CacheExpired reports if the data is older than the cache ttl
+func (c *Conductor) CacheExpired() bool {
+	return c.CacheAge() >= c.config.CacheTTL
+}
+
+// Refreshing reports if a background refresh is in progress
+func (c *Conductor) Refreshing() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.refreshing
+}
+
+// build creates a cache store indexing the data given. The objects
+// of data are linked to each other so data must not be shared with
+// another store
+func build(data *ExecuterRootData) *store {
+	cache := newStore()
+	cache.data = data
+	for _, dc := range data.Data.Datacenters {
+		cache.datacenters._id[dc.ID] = dc
+		cache.datacenters.name[dc.Name] = dc
 	}
 
-	for _, dc := range c.data.Data.Datacenters {
-		if pdc, found := c.cache.datacenters._id[dc.ParentID]; found {
+	for _, dc := range data.Data.Datacenters {
+		if pdc, found := cache.datacenters._id[dc.ParentID]; found {
 			dc.Parent = pdc
 		}
 	}
 
-	for _, workgroup := range c.data.Data.WorkGroups {
+	for _, workgroup := range data.Data.WorkGroups {
 		workgroup.Groups = make([]*Group, 0)
-		c.cache.workgroups._id[workgroup.ID] = workgroup
-		c.cache.workgroups.name[workgroup.Name] = workgroup
+		cache.workgroups._id[workgroup.ID] = workgroup
+		cache.workgroups.name[workgroup.Name] = workgroup
 	}
 
-	for _, group := range c.data.Data.Groups {
+	for _, group := range data.Data.Groups {
 		group.Hosts = make([]*Host, 0)
-		c.cache.groups._id[group.ID] = group
-		c.cache.groups.name[group.Name] = group
-		wg := c.cache.workgroups._id[group.WorkGroupID]
+		cache.groups._id[group.ID] = group
+		cache.groups.name[group.Name] = group
+		wg := cache.workgroups._id[group.WorkGroupID]
 		if wg != nil {
-			wg.Groups = append(c.cache.workgroups._id[group.WorkGroupID].Groups, group)
+			wg.Groups = append(wg.Groups, group)
 		}
 	}
 
-	for _, host := range c.data.Data.Hosts {
-		c.cache.hosts._id[host.ID] = host
-		c.cache.hosts.fqdn[host.FQDN] = host
+	for _, host := range data.Data.Hosts {
+		cache.hosts._id[host.ID] = host
+		cache.hosts.fqdn[host.FQDN] = host
 		if host.GroupID != "" {
-			c.cache.groups._id[host.GroupID].Hosts = append(c.cache.groups._id[host.GroupID].Hosts, host)
+			if group, found := cache.groups._id[host.GroupID]; found {
+				group.Hosts = append(group.Hosts, host)
+			}
 		}
 		if host.DatacenterID != "" {
-			host.Datacenter = c.cache.datacenters._id[host.DatacenterID]
+			host.Datacenter = cache.datacenters._id[host.DatacenterID]
 		}
 	}
+	cache.indexHosts()
+	return cache
 }
 
 func (c *Conductor) CompleteHost(line string) []string {
-	res := make([]string, 0)
-	for hostname := range cGlobal.cache.hosts.fqdn {
-		if line == "" || strings.HasPrefix(hostname, line) {
-			res = append(res, hostname[len(line):])
-		}
-	}
-	sort.Strings(res)
-	return res
+	return c.store().completeHost(line)
 }
 
 func (c *Conductor) CompleteGroup(line string) []string {
-	expr := line
-	if strings.HasPrefix(expr, "%") {
-		expr = expr[1:]
-	}
-	res := make([]string, 0)
-	for groupname := range cGlobal.cache.groups.name {
-		if expr == "" || strings.HasPrefix(groupname, expr) {
-			res = append(res, groupname[len(expr):])
-		}
-	}
-	sort.Strings(res)
-	return res
+	return c.store().completeGroup(line)
 }
 
 func (c *Conductor) CompleteWorkGroup(line string) []string {
-	expr := line
-	if strings.HasPrefix(expr, "*") {
-		expr = expr[1:]
-	}
-	res := make([]string, 0)
-	for wgname := range cGlobal.cache.workgroups.name {
-		if expr == "" || strings.HasPrefix(wgname, expr) {
-			res = append(res, wgname[len(expr):])
-		}
-	}
-	sort.Strings(res)
-	return res
+	return c.store().completeWorkGroup(line)
 }
 
 func (c *Conductor) CompleteDatacenter(line string) []string {
-	expr := line
-	if strings.HasPrefix(expr, "@") {
-		expr = expr[1:]
-	}
-	res := make([]string, 0)
-	for dcname := range cGlobal.cache.datacenters.name {
-		if expr == "" || strings.HasPrefix(dcname, expr) {
-			res = append(res, dcname[len(expr):])
-		}
-	}
-	sort.Strings(res)
-	return res
+	return c.store().completeDatacenter(line)
 }
 
+// SearchHosts looks up host names by prefix, substring or fuzzy match
+func (c *Conductor) SearchHosts(query string) []hostindex.Match {
+	return c.store().hostnames.Search(query)
+}
+
+// HostList resolves an expression. All the tokens are resolved
+// against the same store even if a refresh is done meanwhile
 func (c *Conductor) HostList(expr []rune) ([]string, error) {
-	tokens, err := parser.ParseExpression(expr)
+	ast, err := parser.Parse(expr)
 	if err != nil {
 		return nil, err
 	}
+	return ast.Evaluate(c.store().resolveToken)
+}
 
-	hostlist := make([]string, 0)
-
-	for _, token := range tokens {
-		switch token.Type {
-		case parser.TTypeHostRegexp:
-			for _, host := range c.MatchHost(token.RegexpFilter) {
-				parser.MaybeAddHost(&hostlist, host, token.Exclude)
-			}
-		case parser.TTypeHost:
-
-			hosts, err := sekwence.ExpandPattern(token.Value)
-			if err != nil {
-				hosts = []string{token.Value}
-			}
-
-			for _, host := range hosts {
-				if len(token.TagsFilter) > 0 {
-					invhost, found := cGlobal.cache.hosts.fqdn[host]
-					if !found {
-						continue
-					}
-					for _, tag := range token.TagsFilter {
-						if !contains(invhost.AllTags, tag) {
-							continue
-						}
-					}
-				}
-				parser.MaybeAddHost(&hostlist, host, token.Exclude)
-			}
-
-		case parser.TTypeGroup:
-			if group, found := cGlobal.cache.groups.name[token.Value]; found {
-				hosts := group.AllHosts()
-
-			hostLoop1:
-				for _, host := range hosts {
-					if token.DatacenterFilter != "" {
-						if host.Datacenter == nil {
-							continue
-						}
-						if host.Datacenter.Name != token.DatacenterFilter {
-							// TODO tree
-							continue
-						}
-					}
-
-					for _, tag := range token.TagsFilter {
-						if !contains(host.AllTags, tag) {
-							continue hostLoop1
-						}
-					}
-
-					if token.RegexpFilter != nil {
-						if !token.RegexpFilter.Match([]byte(host.FQDN)) {
-							continue
-						}
-					}
-					parser.MaybeAddHost(&hostlist, host.FQDN, token.Exclude)
-				}
-			}
+// ResolveToken returns the list of hosts a single expression token represents
+func (c *Conductor) ResolveToken(token *parser.Token) ([]string, error) {
+	return c.store().resolveToken(token)
+}
 
-		case parser.TTypeWorkGroup:
-			workgroups := make([]*WorkGroup, 0)
-			if token.Value == "" {
-				for _, wg := range cGlobal.cache.workgroups.name {
-					workgroups = append(workgroups, wg)
-				}
-			} else {
-				wg, found := cGlobal.cache.workgroups.name[token.Value]
-				if found {
-					workgroups = []*WorkGroup{wg}
-				}
-			}
+func (c *Conductor) MatchHost(pattern *regexp.Regexp) []string {
+	return c.store().matchHost(pattern)
+}
 
-			if len(workgroups) > 0 {
-				hosts := make([]*Host, 0)
-				for _, wg := range workgroups {
-					groups := wg.Groups
-					for _, group := range groups {
-						hosts = append(hosts, group.Hosts...)
-					}
-				}
-
-			hostLoop2:
-				for _, host := range hosts {
-					if token.DatacenterFilter != "" {
-						if host.Datacenter == nil {
-							continue
-						}
-						if host.Datacenter.Name != token.DatacenterFilter {
-							// TODO tree
-							continue
-						}
-					}
-
-					for _, tag := range token.TagsFilter {
-						if !contains(host.AllTags, tag) {
-							continue hostLoop2
-						}
-					}
-
-					if token.RegexpFilter != nil {
-						if !token.RegexpFilter.Match([]byte(host.FQDN)) {
-							continue
-						}
-					}
-
-					parser.MaybeAddHost(&hostlist, host.FQDN, token.Exclude)
-				}
-			}
+// matchDatacenter checks if the host is located in the token's datacenter
+// or, unless the exact form @=dc is used, in any of its descendants
+func matchDatacenter(host *Host, token *parser.Token) bool {
+	if host.Datacenter == nil {
+		return false
+	}
+	if token.DatacenterExact {
+		return host.Datacenter.Name == token.DatacenterFilter
+	}
+	for _, dc := range datacenterChain(host.Datacenter) {
+		if dc.Name == token.DatacenterFilter {
+			return true
 		}
 	}
-	return hostlist, nil
+	return false
 }
 
-func (c *Conductor) MatchHost(pattern *regexp.Regexp) []string {
-	res := make([]string, 0)
-	for hostname := range cGlobal.cache.hosts.fqdn {
-		if pattern.MatchString(hostname) {
-			res = append(res, hostname)
-		}
+// datacenterChain returns the datacenter and its ancestors, the nearest
+// first. Every datacenter is returned once, so a parent loop in
+// inventoree data doesn't make the walk endless
+func datacenterChain(dc *Datacenter) []*Datacenter {
+	chain := make([]*Datacenter, 0)
+	visited := make(map[string]bool)
+	for ; dc != nil && !visited[dc.ID]; dc = dc.Parent {
+		visited[dc.ID] = true
+		chain = append(chain, dc)
 	}
-	sort.Strings(res)
-	return res
+	return chain
+}
 
+// DatacenterPath returns the names of the host's datacenter
+// and all its ancestors, starting from the root one
+func (c *Conductor) DatacenterPath(hostname string) []string {
+	return c.store().datacenterPath(hostname)
 }
 
 func contains(array []string, elem string) bool {
@@ -425,10 +481,19 @@ func contains(array []string, elem string) bool {
 	return false
 }
 
-// Reload tries to load groups from inventoree only
+// Reload loads groups from inventoree. With background refresh enabled
+// it's done in background and the current data is used until the new
+// one is loaded
 func (c *Conductor) Reload() error {
-	if cGlobal == nil {
-		return fmt.Errorf("conductor object is not initialized")
+	if !c.backgroundRefresh() {
+		err := c.refresh()
+		if err != nil {
+			return fmt.Errorf("Can't reload data from inventoree")
+		}
+		return nil
 	}
-	return cGlobal.reload()
+	if !c.startRefresh() {
+		return fmt.Errorf("data is already being reloaded")
+	}
+	return nil
 }
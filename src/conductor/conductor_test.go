@@ -0,0 +1,310 @@
+package conductor
+
+import (
+	"config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+const otherData = `{"data": {
+	"work_groups": [{"_id": "w1", "name": "ops"}],
+	"groups": [
+		{"_id": "g1", "name": "web", "work_group_id": "w1"},
+		{"_id": "g3", "name": "db", "work_group_id": "w1"}
+	],
+	"hosts": [
+		{"_id": "h5", "fqdn": "web3", "group_id": "g1"},
+		{"_id": "h6", "fqdn": "db2", "group_id": "g3"}
+	]
+}}`
+
+func waitRefreshed(t *testing.T, c *Conductor) {
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Refreshing() {
+		if time.Now().After(deadline) {
+			t.Fatal("refresh takes too long")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+	c, cleanup := newTestConductor(t, ts.URL)
+	defer cleanup()
+	c.SetBackgroundRefresh(true)
+
+	err := c.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an expression must be resolved against one of the data versions,
+	// never against a mix of them
+	expected := [][]string{
+		{"db1", "web1", "web2"},
+		{"db2", "web3"},
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				hosts, err := c.HostList([]rune("%web,%db"))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				sort.Strings(hosts)
+				if !reflect.DeepEqual(hosts, expected[0]) && !reflect.DeepEqual(hosts, expected[1]) {
+					t.Errorf("inconsistent result %v", hosts)
+					return
+				}
+				c.CompleteHost("web")
+				c.CompleteGroup("%w")
+				c.CompleteWorkGroup("*o")
+				c.CompleteDatacenter("@eu")
+				c.DatacenterPath("web1")
+				c.CacheAge()
+			}
+		}()
+	}
+
+	for i := 0; i < 6; i++ {
+		ts.Lock()
+		if i%2 == 0 {
+			ts.full = otherData
+		} else {
+			ts.full = fullData
+		}
+		ts.Unlock()
+		for c.Reload() != nil {
+			time.Sleep(time.Millisecond)
+		}
+		waitRefreshed(t, c)
+	}
+	close(stop)
+	wg.Wait()
+
+	checkHosts(t, c, "%web,%db", expected[0]...)
+}
+
+// writeExpiredCache writes a cache of otherData which is expired
+// but may still be used as it's younger than the hard ttl
+func writeExpiredCache(t *testing.T, c *Conductor) {
+	c.config.CacheHardTTL = 24 * time.Hour
+	data, err := decodeJSON([]byte(otherData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.CreatedAt = time.Now().Add(-2 * c.config.CacheTTL)
+	_, err = c.writeCache(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadExpiredCache(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	// xc may exit before a background refresh is done, so
+	// without it the expired data is refreshed before it's used
+	c, cleanup := newTestConductor(t, ts.URL)
+	defer cleanup()
+	writeExpiredCache(t, c)
+	err := c.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Refreshing() || c.CacheExpired() {
+		t.Error("data expected to be refreshed on load")
+	}
+	checkHosts(t, c, "%web", "web1", "web2")
+
+	// reloading without background refresh is done at once as well
+	ts.Lock()
+	ts.full = otherData
+	ts.Unlock()
+	err = c.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHosts(t, c, "%web", "web3")
+
+	c, cleanup = newTestConductor(t, ts.URL)
+	defer cleanup()
+	c.SetBackgroundRefresh(true)
+	writeExpiredCache(t, c)
+	ts.Lock()
+	ts.full = fullData
+	ts.Unlock()
+	err = c.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitRefreshed(t, c)
+	if c.CacheExpired() {
+		t.Error("data expected to be refreshed in background")
+	}
+	checkHosts(t, c, "%web", "web1", "web2")
+}
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xc_conductor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "cache.json")
+	for _, contents := range []string{"first", "second"} {
+		err = writeFile(filename, []byte(contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "second" {
+		t.Errorf("file contents expected to be \"second\", got %q", raw)
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0644 {
+		t.Errorf("file expected to have 0644 permissions, got %v", fi.Mode())
+	}
+
+	// temporary files must not be left
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("the only file expected to be in the directory, got %d", len(files))
+	}
+
+	err = writeFile(filepath.Join(dir, "missing", "cache.json"), []byte("data"))
+	if err == nil {
+		t.Error("writing to a missing directory must fail")
+	}
+}
+
+func TestIndependentConductors(t *testing.T) {
+	ts1 := newTestServer()
+	defer ts1.Close()
+	ts2 := newTestServer()
+	ts2.full = otherData
+	defer ts2.Close()
+
+	c1, cleanup1 := newTestConductor(t, ts1.URL)
+	defer cleanup1()
+	c2, cleanup2 := newTestConductor(t, ts2.URL)
+	defer cleanup2()
+
+	var wg sync.WaitGroup
+	for _, c := range []*Conductor{c1, c2} {
+		wg.Add(1)
+		go func(c *Conductor) {
+			defer wg.Done()
+			err := c.Load()
+			if err != nil {
+				t.Error(err)
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	checkHosts(t, c1, "%web", "web1", "web2")
+	checkHosts(t, c2, "%web", "web3")
+	if completions := c2.CompleteHost("web"); !reflect.DeepEqual(completions, []string{"3"}) {
+		t.Errorf("completions of the second conductor expected to be [3], got %v", completions)
+	}
+}
+
+func TestCachesOfDifferentServers(t *testing.T) {
+	ts1 := newTestServer()
+	defer ts1.Close()
+	ts2 := newTestServer()
+	ts2.full = otherData
+	defer ts2.Close()
+
+	c1, cleanup := newTestConductor(t, ts1.URL)
+	defer cleanup()
+	c1.config.Snapshots = 10
+	cfg := *c1.config
+	cfg.RemoteUrl = ts2.URL
+	c2 := NewConductor(&cfg)
+
+	for _, c := range []*Conductor{c1, c2} {
+		if err := c.Load(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c1.getCacheFilename() == c2.getCacheFilename() || c1.snapshotPrefix() == c2.snapshotPrefix() {
+		t.Fatal("conductors of different servers must not share cache files")
+	}
+
+	// the servers are gone, the data comes from the caches
+	ts1.Close()
+	ts2.Close()
+	c1 = NewConductor(c1.config)
+	c2 = NewConductor(&cfg)
+	for _, c := range []*Conductor{c1, c2} {
+		if err := c.Load(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkHosts(t, c1, "%web", "web1", "web2")
+	checkHosts(t, c2, "%web", "web3")
+}
+
+func TestDatacenterLoop(t *testing.T) {
+	dcs := []*Datacenter{
+		{ID: "d1", Name: "a", ParentID: "d2"},
+		{ID: "d2", Name: "b", ParentID: "d1"},
+		{ID: "d3", Name: "c", ParentID: "d3"},
+	}
+	groups := []*Group{{ID: "g1", Name: "web"}}
+	hosts := []*Host{
+		{ID: "h1", FQDN: "web1", GroupID: "g1", DatacenterID: "d1"},
+		{ID: "h2", FQDN: "web2", GroupID: "g1", DatacenterID: "d3"},
+	}
+	c := NewConductor(&config.ConductorConfig{})
+	c.set(build(&ExecuterRootData{Data: ExecuterData{Datacenters: dcs, Groups: groups, Hosts: hosts}}))
+
+	// a datacenter being its own ancestor must not hang resolving
+	checkHosts(t, c, "%web@a", "web1")
+	checkHosts(t, c, "%web@b", "web1")
+	checkHosts(t, c, "%web@c", "web2")
+	checkHosts(t, c, "%web@unknown")
+
+	paths := map[string][]string{
+		"web1": {"b", "a"},
+		"web2": {"c"},
+	}
+	for host, expected := range paths {
+		if path := c.DatacenterPath(host); !reflect.DeepEqual(path, expected) {
+			t.Errorf("%s datacenter path expected to be %v, got %v", host, expected, path)
+		}
+	}
+}
@@ -5,13 +5,13 @@ import (
 )
 
 type Datacenter struct {
-	ID          string   `json:"_id"`
-	ChildIds    []string `json:"child_ids"`
-	Description string   `json:"description"`
-	Name        string   `json:"name"`
-	ParentID    string   `json:"parent_id"`
-	RootID      string   `json:"root_id"`
-	Parent      *Datacenter
+	ID          string      `json:"_id"`
+	ChildIds    []string    `json:"child_ids"`
+	Description string      `json:"description"`
+	Name        string      `json:"name"`
+	ParentID    string      `json:"parent_id"`
+	RootID      string      `json:"root_id"`
+	Parent      *Datacenter `json:"-"`
 }
 
 type Group struct {
@@ -22,24 +22,24 @@ type Group struct {
 	Description string   `json:"description"`
 	Name        string   `json:"name"`
 	WorkGroupID string   `json:"work_group_id"`
-	Hosts       []*Host
+	Hosts       []*Host  `json:"-"`
 }
 
 type Host struct {
-	ID           string   `json:"_id"`
-	Aliases      []string `json:"aliases"`
-	AllTags      []string `json:"all_tags"`
-	FQDN         string   `json:"fqdn"`
-	GroupID      string   `json:"group_id"`
-	DatacenterID string   `json:"datacenter_id"`
-	Datacenter   *Datacenter
+	ID           string      `json:"_id"`
+	Aliases      []string    `json:"aliases"`
+	AllTags      []string    `json:"all_tags"`
+	FQDN         string      `json:"fqdn"`
+	GroupID      string      `json:"group_id"`
+	DatacenterID string      `json:"datacenter_id"`
+	Datacenter   *Datacenter `json:"-"`
 }
 
 type WorkGroup struct {
-	ID          string `json:"_id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Groups      []*Group
+	ID          string   `json:"_id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Groups      []*Group `json:"-"`
 }
 
 type ExecuterData struct {
@@ -52,27 +52,33 @@ type ExecuterData struct {
 type ExecuterRootData struct {
 	Data      ExecuterData `json:"data"`
 	CreatedAt time.Time    `json:"created_at"`
+	// ETag and LastModified are validators of the inventoree
+	// response the data was taken from
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
 }
 
-func (g *Group) Children() []*Group {
+// Children returns all the descendants of the group, child groups are
+// taken from the cache store given
+func (g *Group) Children(cache *store) []*Group {
 	children := make([]*Group, 0)
 	if len(g.ChildIds) > 0 {
 		for i := 0; i < len(g.ChildIds); i++ {
-			if child, found := cGlobal.cache.groups._id[g.ChildIds[i]]; found {
+			if child, found := cache.groups._id[g.ChildIds[i]]; found {
 				children = append(children, child)
 			}
 		}
 		lower := make([]*Group, 0)
 		for _, child := range children {
-			lower = append(lower, child.Children()...)
+			lower = append(lower, child.Children(cache)...)
 		}
 		children = append(children, lower...)
 	}
 	return children
 }
 
-func (g *Group) AllHosts() []*Host {
-	all_groups := g.Children()
+func (g *Group) AllHosts(cache *store) []*Host {
+	all_groups := g.Children(cache)
 	all_groups = append(all_groups, g)
 	hosts := make([]*Host, 0)
 	for _, group := range all_groups {
@@ -0,0 +1,232 @@
+package conductor
+
+import (
+	"backend"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const snapshotTimeFormat = "20060102-150405"
+
+func (c *Conductor) snapshotPrefix() string {
+	return filepath.Join(c.config.CacheDir, "snapshot_"+c.cacheKey()+"_")
+}
+
+// saveSnapshot writes a timestamped copy of the cache
+// and removes the snapshots exceeding the limit
+func (c *Conductor) saveSnapshot(raw []byte, createdAt time.Time) {
+	if c.config.Snapshots <= 0 {
+		return
+	}
+	filename := c.snapshotPrefix() + createdAt.Format(snapshotTimeFormat) + ".json"
+	err := writeFile(filename, raw)
+	if err != nil {
+		c.errorf("Error writing snapshot %s: %s\n", filename, err)
+		return
+	}
+
+	names, err := c.Snapshots()
+	if err != nil {
+		return
+	}
+	for len(names) > c.config.Snapshots {
+		os.Remove(c.snapshotPrefix() + names[0] + ".json")
+		names = names[1:]
+	}
+}
+
+// snapshotPrevious keeps the cache which is about to be overwritten
+// as a snapshot if there are no snapshots yet, so the very first
+// reload already has something to compare with
+func (c *Conductor) snapshotPrevious(cacheFilename string) {
+	if c.config.Snapshots <= 0 {
+		return
+	}
+	names, err := c.Snapshots()
+	if err != nil || len(names) > 0 {
+		return
+	}
+	raw, err := ioutil.ReadFile(cacheFilename)
+	if err != nil {
+		return
+	}
+	data, err := decodeJSON(raw)
+	if err != nil {
+		return
+	}
+	c.saveSnapshot(raw, data.CreatedAt)
+}
+
+// Snapshots returns names of the kept snapshots, the oldest first
+func (c *Conductor) Snapshots() ([]string, error) {
+	prefix := c.snapshotPrefix()
+	files, err := filepath.Glob(prefix + "*.json")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		name := strings.TrimSuffix(strings.TrimPrefix(file, prefix), ".json")
+		if _, err := time.Parse(snapshotTimeFormat, name); err == nil {
+			names = append(names, name)
+		}
+	}
+	// the time format makes lexical order chronological
+	sort.Strings(names)
+	return names, nil
+}
+
+func (c *Conductor) loadSnapshot(name string) (*ExecuterRootData, error) {
+	raw, err := ioutil.ReadFile(c.snapshotPrefix() + name + ".json")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("snapshot %s not found", name)
+		}
+		return nil, err
+	}
+	return decodeJSON(raw)
+}
+
+// DiffSnapshots compares two snapshots. If to is empty the latest
+// snapshot is used, if from is empty the one preceding to is used
+func (c *Conductor) DiffSnapshots(from string, to string) (*backend.InventoryDiff, error) {
+	names, err := c.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	if to == "" {
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no snapshots found")
+		}
+		to = names[len(names)-1]
+	}
+	if from == "" {
+		for _, name := range names {
+			if name < to {
+				from = name
+			}
+		}
+		if from == "" {
+			return nil, fmt.Errorf("no snapshots older than %s found", to)
+		}
+	}
+
+	fromData, err := c.loadSnapshot(from)
+	if err != nil {
+		return nil, err
+	}
+	toData, err := c.loadSnapshot(to)
+	if err != nil {
+		return nil, err
+	}
+	diff := diffData(fromData, toData)
+	diff.From = from
+	diff.To = to
+	return diff, nil
+}
+
+func diffData(from *ExecuterRootData, to *ExecuterRootData) *backend.InventoryDiff {
+	fromStore := build(from)
+	toStore := build(to)
+	diff := new(backend.InventoryDiff)
+
+	fromHosts := make([]string, 0, len(fromStore.hosts.fqdn))
+	for fqdn := range fromStore.hosts.fqdn {
+		fromHosts = append(fromHosts, fqdn)
+	}
+	toHosts := make([]string, 0, len(toStore.hosts.fqdn))
+	for fqdn := range toStore.hosts.fqdn {
+		toHosts = append(toHosts, fqdn)
+	}
+	diff.AddedHosts, diff.RemovedHosts = diffLists(fromHosts, toHosts)
+
+	fromGroups := make([]string, 0, len(fromStore.groups.name))
+	for name := range fromStore.groups.name {
+		fromGroups = append(fromGroups, name)
+	}
+	toGroups := make([]string, 0, len(toStore.groups.name))
+	for name := range toStore.groups.name {
+		toGroups = append(toGroups, name)
+	}
+	diff.AddedGroups, diff.RemovedGroups = diffLists(fromGroups, toGroups)
+
+	diff.Groups = make([]*backend.ListChange, 0)
+	for _, name := range unique(append(fromGroups, toGroups...)) {
+		added, removed := diffLists(groupHosts(fromStore, name), groupHosts(toStore, name))
+		if len(added) > 0 || len(removed) > 0 {
+			diff.Groups = append(diff.Groups, &backend.ListChange{Name: name, Added: added, Removed: removed})
+		}
+	}
+
+	diff.Tags = make([]*backend.ListChange, 0)
+	for _, fqdn := range unique(toHosts) {
+		fromHost, found := fromStore.hosts.fqdn[fqdn]
+		if !found {
+			continue
+		}
+		added, removed := diffLists(fromHost.AllTags, toStore.hosts.fqdn[fqdn].AllTags)
+		if len(added) > 0 || len(removed) > 0 {
+			diff.Tags = append(diff.Tags, &backend.ListChange{Name: fqdn, Added: added, Removed: removed})
+		}
+	}
+	return diff
+}
+
+func groupHosts(cache *store, name string) []string {
+	group, found := cache.groups.name[name]
+	if !found {
+		return nil
+	}
+	hosts := make([]string, 0)
+	for _, host := range group.AllHosts(cache) {
+		hosts = append(hosts, host.FQDN)
+	}
+	return hosts
+}
+
+// diffLists returns sorted lists of items added to and removed from a list
+func diffLists(from []string, to []string) ([]string, []string) {
+	fromSet := make(map[string]bool)
+	for _, item := range from {
+		fromSet[item] = true
+	}
+	toSet := make(map[string]bool)
+	for _, item := range to {
+		toSet[item] = true
+	}
+
+	added := make([]string, 0)
+	for item := range toSet {
+		if !fromSet[item] {
+			added = append(added, item)
+		}
+	}
+	removed := make([]string, 0)
+	for item := range fromSet {
+		if !toSet[item] {
+			removed = append(removed, item)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+func unique(list []string) []string {
+	set := make(map[string]bool)
+	res := make([]string, 0, len(list))
+	for _, item := range list {
+		if !set[item] {
+			set[item] = true
+			res = append(res, item)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
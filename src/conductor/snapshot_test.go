@@ -0,0 +1,257 @@
+package conductor
+
+import (
+	"backend"
+	"config"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const diffBase = `{"data": {
+	"work_groups": [{"_id": "w1", "name": "ops"}],
+	"groups": [
+		{"_id": "g1", "name": "web", "work_group_id": "w1", "child_ids": ["g2"]},
+		{"_id": "g2", "name": "web-eu", "work_group_id": "w1"},
+		{"_id": "g3", "name": "db", "work_group_id": "w1"}
+	],
+	"hosts": [
+		{"_id": "h1", "fqdn": "web1", "group_id": "g1", "all_tags": ["nginx"]},
+		{"_id": "h2", "fqdn": "web2", "group_id": "g2", "all_tags": ["nginx", "eu"]},
+		{"_id": "h3", "fqdn": "db1", "group_id": "g3"}
+	]
+}}`
+
+func decodeTestData(t *testing.T, raw string) *ExecuterRootData {
+	data, err := decodeJSON([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// normalizeDiff replaces empty lists with nil so diffs
+// can be compared to the expected ones
+func normalizeDiff(diff *backend.InventoryDiff) {
+	lists := []*[]string{
+		&diff.AddedHosts, &diff.RemovedHosts,
+		&diff.AddedGroups, &diff.RemovedGroups,
+	}
+	for _, change := range append(diff.Groups, diff.Tags...) {
+		lists = append(lists, &change.Added, &change.Removed)
+	}
+	for _, list := range lists {
+		if len(*list) == 0 {
+			*list = nil
+		}
+	}
+	if len(diff.Groups) == 0 {
+		diff.Groups = nil
+	}
+	if len(diff.Tags) == 0 {
+		diff.Tags = nil
+	}
+}
+
+func TestDiffData(t *testing.T) {
+	data := []struct {
+		name     string
+		to       string
+		expected backend.InventoryDiff
+	}{
+		{
+			name: "unchanged",
+			to:   diffBase,
+		},
+		{
+			name: "hosts added and removed",
+			to: `{"data": {
+				"groups": [
+					{"_id": "g1", "name": "web", "child_ids": ["g2"]},
+					{"_id": "g2", "name": "web-eu"},
+					{"_id": "g3", "name": "db"}
+				],
+				"hosts": [
+					{"_id": "h1", "fqdn": "web1", "group_id": "g1", "all_tags": ["nginx"]},
+					{"_id": "h2", "fqdn": "web2", "group_id": "g2", "all_tags": ["nginx", "eu"]},
+					{"_id": "h4", "fqdn": "db2", "group_id": "g3"}
+				]
+			}}`,
+			expected: backend.InventoryDiff{
+				AddedHosts:   []string{"db2"},
+				RemovedHosts: []string{"db1"},
+				Groups: []*backend.ListChange{
+					{Name: "db", Added: []string{"db2"}, Removed: []string{"db1"}},
+				},
+			},
+		},
+		{
+			name: "groups added and removed",
+			to: `{"data": {
+				"groups": [
+					{"_id": "g1", "name": "web", "child_ids": ["g2"]},
+					{"_id": "g2", "name": "web-eu"},
+					{"_id": "g4", "name": "cache"}
+				],
+				"hosts": [
+					{"_id": "h1", "fqdn": "web1", "group_id": "g1", "all_tags": ["nginx"]},
+					{"_id": "h2", "fqdn": "web2", "group_id": "g2", "all_tags": ["nginx", "eu"]},
+					{"_id": "h3", "fqdn": "db1", "group_id": "g4"}
+				]
+			}}`,
+			expected: backend.InventoryDiff{
+				AddedGroups:   []string{"cache"},
+				RemovedGroups: []string{"db"},
+				Groups: []*backend.ListChange{
+					{Name: "cache", Added: []string{"db1"}},
+					{Name: "db", Removed: []string{"db1"}},
+				},
+			},
+		},
+		{
+			name: "host moved to a child group",
+			to: `{"data": {
+				"groups": [
+					{"_id": "g1", "name": "web", "child_ids": ["g2"]},
+					{"_id": "g2", "name": "web-eu"},
+					{"_id": "g3", "name": "db"}
+				],
+				"hosts": [
+					{"_id": "h1", "fqdn": "web1", "group_id": "g2", "all_tags": ["nginx"]},
+					{"_id": "h2", "fqdn": "web2", "group_id": "g2", "all_tags": ["nginx", "eu"]},
+					{"_id": "h3", "fqdn": "db1", "group_id": "g3"}
+				]
+			}}`,
+			expected: backend.InventoryDiff{
+				// web still resolves to web1 through web-eu
+				Groups: []*backend.ListChange{
+					{Name: "web-eu", Added: []string{"web1"}},
+				},
+			},
+		},
+		{
+			name: "child group detached",
+			to: `{"data": {
+				"groups": [
+					{"_id": "g1", "name": "web"},
+					{"_id": "g2", "name": "web-eu"},
+					{"_id": "g3", "name": "db", "child_ids": ["g2"]}
+				],
+				"hosts": [
+					{"_id": "h1", "fqdn": "web1", "group_id": "g1", "all_tags": ["nginx"]},
+					{"_id": "h2", "fqdn": "web2", "group_id": "g2", "all_tags": ["nginx", "eu"]},
+					{"_id": "h3", "fqdn": "db1", "group_id": "g3"}
+				]
+			}}`,
+			expected: backend.InventoryDiff{
+				Groups: []*backend.ListChange{
+					{Name: "db", Added: []string{"web2"}},
+					{Name: "web", Removed: []string{"web2"}},
+				},
+			},
+		},
+		{
+			name: "tags changed",
+			to: `{"data": {
+				"groups": [
+					{"_id": "g1", "name": "web", "child_ids": ["g2"]},
+					{"_id": "g2", "name": "web-eu"},
+					{"_id": "g3", "name": "db"}
+				],
+				"hosts": [
+					{"_id": "h1", "fqdn": "web1", "group_id": "g1", "all_tags": ["nginx"]},
+					{"_id": "h2", "fqdn": "web2", "group_id": "g2", "all_tags": ["eu", "front"]},
+					{"_id": "h3", "fqdn": "db1", "group_id": "g3", "all_tags": ["mysql"]},
+					{"_id": "h4", "fqdn": "web3", "group_id": "g1", "all_tags": ["nginx"]}
+				]
+			}}`,
+			expected: backend.InventoryDiff{
+				AddedHosts: []string{"web3"},
+				Groups: []*backend.ListChange{
+					{Name: "web", Added: []string{"web3"}},
+				},
+				// tags of added hosts are not listed
+				Tags: []*backend.ListChange{
+					{Name: "db1", Added: []string{"mysql"}},
+					{Name: "web2", Added: []string{"front"}, Removed: []string{"nginx"}},
+				},
+			},
+		},
+	}
+
+	for _, d := range data {
+		diff := diffData(decodeTestData(t, diffBase), decodeTestData(t, d.to))
+		if diff.Empty() != reflect.DeepEqual(d.expected, backend.InventoryDiff{}) {
+			t.Errorf("%s: diff emptiness expected to be %v", d.name, !diff.Empty())
+		}
+		normalizeDiff(diff)
+		if !reflect.DeepEqual(*diff, d.expected) {
+			t.Errorf("%s: diff expected to be %+v, got %+v", d.name, d.expected, *diff)
+			for _, change := range append(diff.Groups, diff.Tags...) {
+				t.Errorf("%s: change %+v", d.name, *change)
+			}
+		}
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xc_conductor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := NewConductor(&config.ConductorConfig{CacheDir: dir, Snapshots: 5})
+
+	_, err = c.DiffSnapshots("", "")
+	if err == nil || !strings.Contains(err.Error(), "no snapshots found") {
+		t.Errorf("diff without snapshots expected to fail, got %v", err)
+	}
+
+	started := time.Date(2026, 10, 1, 12, 0, 0, 0, time.Local)
+	contents := []string{diffBase, otherData, fullData}
+	names := make([]string, len(contents))
+	for i, raw := range contents {
+		createdAt := started.Add(time.Duration(i) * time.Hour)
+		c.saveSnapshot([]byte(raw), createdAt)
+		names[i] = createdAt.Format(snapshotTimeFormat)
+	}
+
+	_, err = c.DiffSnapshots("", names[0])
+	if err == nil || !strings.Contains(err.Error(), "no snapshots older than") {
+		t.Errorf("diff of the oldest snapshot expected to fail, got %v", err)
+	}
+	_, err = c.DiffSnapshots("20200101-000000", "")
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("diff of a missing snapshot expected to fail, got %v", err)
+	}
+
+	// the latest snapshot is compared with the preceding one by default
+	data := []struct {
+		from         string
+		to           string
+		expectedFrom string
+		expectedTo   string
+		addedHosts   []string
+	}{
+		{"", "", names[1], names[2], []string{"db1", "web1", "web2"}},
+		{"", names[1], names[0], names[1], []string{"db2", "web3"}},
+		{names[0], "", names[0], names[2], []string{}},
+		{names[2], names[0], names[2], names[0], []string{}},
+	}
+	for _, d := range data {
+		diff, err := c.DiffSnapshots(d.from, d.to)
+		if err != nil {
+			t.Errorf("error comparing %q and %q: %s", d.from, d.to, err)
+			continue
+		}
+		if diff.From != d.expectedFrom || diff.To != d.expectedTo {
+			t.Errorf("%q and %q expected to compare %s and %s, got %s and %s", d.from, d.to, d.expectedFrom, d.expectedTo, diff.From, diff.To)
+		}
+		if !reflect.DeepEqual(diff.AddedHosts, d.addedHosts) {
+			t.Errorf("%s to %s expected to add hosts %v, got %v", diff.From, diff.To, d.addedHosts, diff.AddedHosts)
+		}
+	}
+}
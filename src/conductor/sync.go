@@ -0,0 +1,416 @@
+package conductor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// executerPatch is a response to an incremental request. Data holds
+// the created and changed objects, Removed holds ids of the deleted ones.
+// A server not supporting incremental requests ignores the since
+// parameter and responds with the full data, Incremental is false then
+type executerPatch struct {
+	Data        ExecuterData `json:"data"`
+	Removed     removedIDs   `json:"removed"`
+	Incremental bool         `json:"incremental"`
+}
+
+type removedIDs struct {
+	Datacenters []string `json:"datacenters"`
+	Groups      []string `json:"groups"`
+	Hosts       []string `json:"hosts"`
+	WorkGroups  []string `json:"work_groups"`
+}
+
+// sync requests the changes made since the current data was loaded
+// and applies them to a copy of the current store
+func (c *Conductor) sync() error {
+	cache := c.store()
+	current := cache.data
+	if current.CreatedAt.IsZero() {
+		return fmt.Errorf("no data to sync")
+	}
+
+	header := make(http.Header)
+	if current.ETag != "" {
+		header.Set("If-None-Match", current.ETag)
+	}
+	if current.LastModified != "" {
+		header.Set("If-Modified-Since", current.LastModified)
+	}
+	since := url.QueryEscape(current.CreatedAt.UTC().Format(time.RFC3339))
+
+	c.warnf("Syncing data with inventoree\n")
+	startedAt := time.Now()
+	raw, respHeader, err := c.httpGet(c.dataURL()+"&since="+since, header)
+	if err == errNotModified {
+		data := &ExecuterRootData{
+			Data:         current.Data,
+			CreatedAt:    startedAt,
+			ETag:         current.ETag,
+			LastModified: current.LastModified,
+		}
+		// the data is the same so no snapshot is taken
+		c.writeCache(data)
+		// the indexes are kept, only the data is replaced
+		updated := *cache
+		updated.data = data
+		c.set(&updated)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	patch := new(executerPatch)
+	err = json.Unmarshal(raw, patch)
+	if err != nil {
+		return err
+	}
+
+	data := &ExecuterRootData{
+		CreatedAt:    startedAt,
+		ETag:         respHeader.Get("ETag"),
+		LastModified: respHeader.Get("Last-Modified"),
+	}
+	if patch.Incremental {
+		cache = cache.apply(patch)
+		data.Data = cache.executerData()
+		cache.data = data
+	} else {
+		data.Data = patch.Data
+		cache = build(data)
+	}
+	c.saveCache(data)
+	c.set(cache)
+	return nil
+}
+
+// clone creates a store with copies of all the indexes. The objects
+// are shared so they must be copied before changing
+func (s *store) clone() *store {
+	cs := newStore()
+	for k, v := range s.datacenters._id {
+		cs.datacenters._id[k] = v
+	}
+	for k, v := range s.datacenters.name {
+		cs.datacenters.name[k] = v
+	}
+	for k, v := range s.groups._id {
+		cs.groups._id[k] = v
+	}
+	for k, v := range s.groups.name {
+		cs.groups.name[k] = v
+	}
+	for k, v := range s.hosts._id {
+		cs.hosts._id[k] = v
+	}
+	for k, v := range s.hosts.fqdn {
+		cs.hosts.fqdn[k] = v
+	}
+	for k, v := range s.workgroups._id {
+		cs.workgroups._id[k] = v
+	}
+	for k, v := range s.workgroups.name {
+		cs.workgroups.name[k] = v
+	}
+	return cs
+}
+
+// apply creates a new store with the patch applied. The store itself
+// is not changed as it may be in use, only the objects affected by the
+// patch are replaced in the new one and the rest are shared
+func (s *store) apply(patch *executerPatch) *store {
+	cs := s.clone()
+
+	// datacenters
+	changedDCs := make(map[string]bool)
+	for _, id := range patch.Removed.Datacenters {
+		if dc, found := cs.datacenters._id[id]; found {
+			cs.removeDatacenter(dc)
+			changedDCs[id] = true
+		}
+	}
+	for _, dc := range patch.Data.Datacenters {
+		if old, found := cs.datacenters._id[dc.ID]; found {
+			cs.removeDatacenter(old)
+		}
+		cs.datacenters._id[dc.ID] = dc
+		cs.datacenters.name[dc.Name] = dc
+		changedDCs[dc.ID] = true
+	}
+	// children of the replaced datacenters refer to the old objects so
+	// they're replaced with copies, and so are their children
+	queue := sortedKeys(changedDCs)
+	for len(queue) > 0 {
+		parentID := queue[0]
+		queue = queue[1:]
+		for _, dc := range cs.datacenters._id {
+			if dc.ParentID == parentID && !changedDCs[dc.ID] {
+				cp := *dc
+				cs.datacenters._id[cp.ID] = &cp
+				cs.datacenters.name[cp.Name] = &cp
+				changedDCs[cp.ID] = true
+				queue = append(queue, cp.ID)
+			}
+		}
+	}
+	for id := range changedDCs {
+		if dc, found := cs.datacenters._id[id]; found {
+			dc.Parent = cs.datacenters._id[dc.ParentID]
+		}
+	}
+
+	// hosts
+	changedHosts := make(map[string]bool)
+	changedGroups := make(map[string]bool)
+	for _, id := range patch.Removed.Hosts {
+		if host, found := cs.hosts._id[id]; found {
+			cs.removeHost(host)
+			changedHosts[id] = true
+			changedGroups[host.GroupID] = true
+		}
+	}
+	for _, host := range patch.Data.Hosts {
+		if old, found := cs.hosts._id[host.ID]; found {
+			cs.removeHost(old)
+			changedGroups[old.GroupID] = true
+		}
+		cs.hosts._id[host.ID] = host
+		cs.hosts.fqdn[host.FQDN] = host
+		changedHosts[host.ID] = true
+		changedGroups[host.GroupID] = true
+	}
+	if len(changedDCs) > 0 {
+		for _, host := range cs.hosts._id {
+			if changedDCs[host.DatacenterID] && !changedHosts[host.ID] {
+				cp := *host
+				cs.hosts._id[cp.ID] = &cp
+				cs.hosts.fqdn[cp.FQDN] = &cp
+				changedHosts[cp.ID] = true
+				changedGroups[cp.GroupID] = true
+			}
+		}
+	}
+	for id := range changedHosts {
+		if host, found := cs.hosts._id[id]; found {
+			host.Datacenter = cs.datacenters._id[host.DatacenterID]
+		}
+	}
+
+	// groups
+	changedWorkGroups := make(map[string]bool)
+	for _, id := range patch.Removed.Groups {
+		if group, found := cs.groups._id[id]; found {
+			cs.removeGroup(group)
+			changedGroups[id] = true
+			changedWorkGroups[group.WorkGroupID] = true
+		}
+	}
+	for _, group := range patch.Data.Groups {
+		if old, found := cs.groups._id[group.ID]; found {
+			cs.removeGroup(old)
+			changedWorkGroups[old.WorkGroupID] = true
+		}
+		cs.groups._id[group.ID] = group
+		cs.groups.name[group.Name] = group
+		changedGroups[group.ID] = true
+	}
+	changedGroupIDs := sortedKeys(changedGroups)
+	changedHostIDs := sortedKeys(changedHosts)
+	for _, id := range changedGroupIDs {
+		group, found := cs.groups._id[id]
+		if !found {
+			continue
+		}
+		if group == s.groups._id[id] {
+			cp := *group
+			group = &cp
+			cs.groups._id[id] = group
+			cs.groups.name[group.Name] = group
+		}
+
+		var hosts []*Host
+		if old, found := s.groups._id[id]; found {
+			hosts = old.Hosts
+		}
+		group.Hosts = mergeHosts(hosts, changedHosts, changedHostIDs, id, cs)
+		changedWorkGroups[group.WorkGroupID] = true
+	}
+
+	// workgroups
+	for _, id := range patch.Removed.WorkGroups {
+		if wg, found := cs.workgroups._id[id]; found {
+			cs.removeWorkGroup(wg)
+			changedWorkGroups[id] = true
+		}
+	}
+	for _, wg := range patch.Data.WorkGroups {
+		if old, found := cs.workgroups._id[wg.ID]; found {
+			cs.removeWorkGroup(old)
+		}
+		cs.workgroups._id[wg.ID] = wg
+		cs.workgroups.name[wg.Name] = wg
+		changedWorkGroups[wg.ID] = true
+	}
+	for _, id := range sortedKeys(changedWorkGroups) {
+		wg, found := cs.workgroups._id[id]
+		if !found {
+			continue
+		}
+		if wg == s.workgroups._id[id] {
+			cp := *wg
+			wg = &cp
+			cs.workgroups._id[id] = wg
+			cs.workgroups.name[wg.Name] = wg
+		}
+
+		var groups []*Group
+		if old, found := s.workgroups._id[id]; found {
+			groups = old.Groups
+		}
+		wg.Groups = mergeGroups(groups, changedGroups, changedGroupIDs, id, cs)
+	}
+	cs.indexHosts()
+	return cs
+}
+
+// mergeHosts builds a new hosts list of a group. Hosts which haven't
+// changed keep their places, changed ones are replaced with the new
+// objects if they still belong to the group, new ones are appended
+func mergeHosts(hosts []*Host, changed map[string]bool, changedIDs []string, groupID string, cs *store) []*Host {
+	res := make([]*Host, 0, len(hosts))
+	placed := make(map[string]bool)
+	for _, host := range hosts {
+		if changed[host.ID] {
+			host = cs.hosts._id[host.ID]
+			if host == nil || host.GroupID != groupID {
+				continue
+			}
+			placed[host.ID] = true
+		}
+		res = append(res, host)
+	}
+	for _, id := range changedIDs {
+		if host, found := cs.hosts._id[id]; found && host.GroupID == groupID && !placed[id] {
+			res = append(res, host)
+		}
+	}
+	return res
+}
+
+// mergeGroups does the same as mergeHosts for groups of a workgroup
+func mergeGroups(groups []*Group, changed map[string]bool, changedIDs []string, wgID string, cs *store) []*Group {
+	res := make([]*Group, 0, len(groups))
+	placed := make(map[string]bool)
+	for _, group := range groups {
+		if changed[group.ID] {
+			group = cs.groups._id[group.ID]
+			if group == nil || group.WorkGroupID != wgID {
+				continue
+			}
+			placed[group.ID] = true
+		}
+		res = append(res, group)
+	}
+	for _, id := range changedIDs {
+		if group, found := cs.groups._id[id]; found && group.WorkGroupID == wgID && !placed[id] {
+			res = append(res, group)
+		}
+	}
+	return res
+}
+
+func (s *store) removeDatacenter(dc *Datacenter) {
+	delete(s.datacenters._id, dc.ID)
+	if s.datacenters.name[dc.Name] == dc {
+		delete(s.datacenters.name, dc.Name)
+	}
+}
+
+func (s *store) removeHost(host *Host) {
+	delete(s.hosts._id, host.ID)
+	if s.hosts.fqdn[host.FQDN] == host {
+		delete(s.hosts.fqdn, host.FQDN)
+	}
+}
+
+func (s *store) removeGroup(group *Group) {
+	delete(s.groups._id, group.ID)
+	if s.groups.name[group.Name] == group {
+		delete(s.groups.name, group.Name)
+	}
+}
+
+func (s *store) removeWorkGroup(wg *WorkGroup) {
+	delete(s.workgroups._id, wg.ID)
+	if s.workgroups.name[wg.Name] == wg {
+		delete(s.workgroups.name, wg.Name)
+	}
+}
+
+// executerData returns the store contents as executer data to be cached.
+// Groups keep hosts and workgroups keep groups in the order they are
+// listed in the data so the lists are ordered the same way
+func (s *store) executerData() ExecuterData {
+	data := ExecuterData{
+		Datacenters: make([]*Datacenter, 0, len(s.datacenters._id)),
+		Groups:      make([]*Group, 0, len(s.groups._id)),
+		Hosts:       make([]*Host, 0, len(s.hosts._id)),
+		WorkGroups:  make([]*WorkGroup, 0, len(s.workgroups._id)),
+	}
+	for _, dc := range s.datacenters._id {
+		data.Datacenters = append(data.Datacenters, dc)
+	}
+	sort.Slice(data.Datacenters, func(i, j int) bool { return data.Datacenters[i].ID < data.Datacenters[j].ID })
+	for _, wg := range s.workgroups._id {
+		data.WorkGroups = append(data.WorkGroups, wg)
+	}
+	sort.Slice(data.WorkGroups, func(i, j int) bool { return data.WorkGroups[i].ID < data.WorkGroups[j].ID })
+
+	listed := make(map[string]bool)
+	for _, wg := range data.WorkGroups {
+		for _, group := range wg.Groups {
+			data.Groups = append(data.Groups, group)
+			listed[group.ID] = true
+		}
+	}
+	unlisted := make([]*Group, 0)
+	for id, group := range s.groups._id {
+		if !listed[id] {
+			unlisted = append(unlisted, group)
+		}
+	}
+	sort.Slice(unlisted, func(i, j int) bool { return unlisted[i].ID < unlisted[j].ID })
+	data.Groups = append(data.Groups, unlisted...)
+
+	listed = make(map[string]bool)
+	for _, group := range data.Groups {
+		for _, host := range group.Hosts {
+			data.Hosts = append(data.Hosts, host)
+			listed[host.ID] = true
+		}
+	}
+	orphans := make([]*Host, 0)
+	for id, host := range s.hosts._id {
+		if !listed[id] {
+			orphans = append(orphans, host)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].ID < orphans[j].ID })
+	data.Hosts = append(data.Hosts, orphans...)
+	return data
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
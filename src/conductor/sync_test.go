@@ -0,0 +1,270 @@
+package conductor
+
+import (
+	"config"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fullData = `{"data": {
+	"datacenters": [
+		{"_id": "d1", "name": "eu"},
+		{"_id": "d2", "name": "eu-west", "parent_id": "d1"}
+	],
+	"work_groups": [{"_id": "w1", "name": "ops"}],
+	"groups": [
+		{"_id": "g1", "name": "web", "work_group_id": "w1", "child_ids": ["g2"]},
+		{"_id": "g2", "name": "web-eu", "work_group_id": "w1"},
+		{"_id": "g3", "name": "db", "work_group_id": "w1"}
+	],
+	"hosts": [
+		{"_id": "h1", "fqdn": "web1", "group_id": "g1", "datacenter_id": "d2"},
+		{"_id": "h2", "fqdn": "web2", "group_id": "g2", "datacenter_id": "d2"},
+		{"_id": "h3", "fqdn": "db1", "group_id": "g3", "datacenter_id": "d1"}
+	]
+}}`
+
+// testServer is an inventoree stand-in. It responds to requests
+// having the since parameter with the patch if there is one
+type testServer struct {
+	sync.Mutex
+	*httptest.Server
+	full      string
+	patch     string
+	etag      string
+	requests  []*http.Request
+	sinceCode int
+}
+
+func newTestServer() *testServer {
+	ts := &testServer{full: fullData}
+	ts.Server = httptest.NewServer(http.HandlerFunc(ts.handle))
+	return ts
+}
+
+func (ts *testServer) handle(w http.ResponseWriter, r *http.Request) {
+	ts.Lock()
+	defer ts.Unlock()
+	ts.requests = append(ts.requests, r)
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Query().Get("since") != "" {
+		if ts.sinceCode != 0 {
+			w.WriteHeader(ts.sinceCode)
+			fmt.Fprint(w, `{"error": "unsupported"}`)
+			return
+		}
+		if ts.etag != "" && r.Header.Get("If-None-Match") == ts.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if ts.patch != "" {
+			fmt.Fprint(w, ts.patch)
+			return
+		}
+	}
+	if ts.etag != "" {
+		w.Header().Set("ETag", ts.etag)
+	}
+	fmt.Fprint(w, ts.full)
+}
+
+func (ts *testServer) lastRequest() *http.Request {
+	ts.Lock()
+	defer ts.Unlock()
+	return ts.requests[len(ts.requests)-1]
+}
+
+func newTestConductor(t *testing.T, url string) (*Conductor, func()) {
+	dir, err := ioutil.TempDir("", "xc_conductor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewConductor(&config.ConductorConfig{
+		CacheTTL:    time.Hour,
+		CacheDir:    dir,
+		RemoteUrl:   url,
+		Incremental: true,
+	})
+	return c, func() { os.RemoveAll(dir) }
+}
+
+func checkHosts(t *testing.T, c *Conductor, expr string, expected ...string) {
+	hosts, err := c.HostList([]rune(expr))
+	if err != nil {
+		t.Errorf("error resolving %s: %s", expr, err)
+		return
+	}
+	sort.Strings(hosts)
+	sort.Strings(expected)
+	if len(hosts) == 0 && len(expected) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("%s expected to resolve to %v, got %v", expr, expected, hosts)
+	}
+}
+
+func TestSyncFallsBackToFullData(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+	c, cleanup := newTestConductor(t, ts.URL)
+	defer cleanup()
+
+	err := c.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHosts(t, c, "%web", "web1", "web2")
+
+	// the server doesn't support incremental requests
+	// and responds with the full data
+	ts.full = `{"data": {"groups": [{"_id": "g1", "name": "web"}], "hosts": [{"_id": "h5", "fqdn": "web5", "group_id": "g1"}]}}`
+	err = c.refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.lastRequest().URL.Query().Get("since") == "" {
+		t.Error("since parameter expected to be sent")
+	}
+	checkHosts(t, c, "%web", "web5")
+	checkHosts(t, c, "%db")
+
+	// the server rejects incremental requests, a full request follows
+	ts.sinceCode = http.StatusBadRequest
+	ts.full = fullData
+	err = c.refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.lastRequest().URL.Query().Get("since") != "" {
+		t.Error("full request expected after a failed incremental one")
+	}
+	checkHosts(t, c, "%web", "web1", "web2")
+}
+
+func TestSyncIncremental(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+	c, cleanup := newTestConductor(t, ts.URL)
+	defer cleanup()
+
+	err := c.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := c.store()
+
+	ts.patch = `{"incremental": true, "data": {
+		"datacenters": [{"_id": "d1", "name": "europe"}],
+		"groups": [{"_id": "g4", "name": "cache", "work_group_id": "w1"}],
+		"hosts": [
+			{"_id": "h1", "fqdn": "web1", "group_id": "g3", "datacenter_id": "d2"},
+			{"_id": "h4", "fqdn": "cache1", "group_id": "g4", "datacenter_id": "d1"}
+		]
+	}, "removed": {"hosts": ["h2"]}}`
+	err = c.refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkHosts(t, c, "%web")
+	checkHosts(t, c, "%db", "db1", "web1")
+	checkHosts(t, c, "%cache", "cache1")
+	checkHosts(t, c, "*ops", "db1", "web1", "cache1")
+	checkHosts(t, c, "*ops@europe", "db1", "web1", "cache1")
+	checkHosts(t, c, "*ops@eu")
+
+	path := c.DatacenterPath("web1")
+	if !reflect.DeepEqual(path, []string{"europe", "eu-west"}) {
+		t.Errorf("web1 datacenter path expected to be [europe eu-west], got %v", path)
+	}
+
+	// the store in use before the sync must stay intact
+	if len(before.groups.name["web"].AllHosts(before)) != 2 {
+		t.Error("previous store has been modified")
+	}
+	if before.datacenters.name["eu"] == nil || before.hosts.fqdn["web2"] == nil {
+		t.Error("previous store has been modified")
+	}
+
+	// the cache written must give the same result when loaded
+	c2 := NewConductor(c.config)
+	err = c2.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHosts(t, c2, "%db", "db1", "web1")
+	checkHosts(t, c2, "*ops@europe", "db1", "web1", "cache1")
+}
+
+func TestSyncColdStart(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+	c, cleanup := newTestConductor(t, ts.URL)
+	defer cleanup()
+
+	err := c.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts.requests) != 1 || ts.lastRequest().URL.Query().Get("since") != "" {
+		t.Errorf("a single full request expected without cached data, got %d requests", len(ts.requests))
+	}
+	checkHosts(t, c, "%web", "web1", "web2")
+}
+
+func TestSyncNotModified(t *testing.T) {
+	ts := newTestServer()
+	ts.etag = `"v1"`
+	defer ts.Close()
+	c, cleanup := newTestConductor(t, ts.URL)
+	defer cleanup()
+	c.config.Snapshots = 10
+
+	err := c.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the snapshot of the loaded data is dropped to see if another one is taken
+	snapshots, err := c.Snapshots()
+	if err != nil || len(snapshots) == 0 {
+		t.Fatalf("a snapshot of the loaded data expected, got %v, %v", snapshots, err)
+	}
+	for _, name := range snapshots {
+		os.Remove(c.snapshotPrefix() + name + ".json")
+	}
+	cache := c.store()
+	created := cache.data.CreatedAt
+
+	time.Sleep(10 * time.Millisecond)
+	err = c.refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.lastRequest().Header.Get("If-None-Match") != `"v1"` {
+		t.Error("If-None-Match header expected to be sent")
+	}
+	if c.store().hosts != cache.hosts {
+		t.Error("store indexes must be kept when data is not modified")
+	}
+	if !c.store().data.CreatedAt.After(created) {
+		t.Error("cache creation time must be updated")
+	}
+	snapshots, err = c.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 0 {
+		t.Errorf("no snapshot expected for unchanged data, got %v", snapshots)
+	}
+	checkHosts(t, c, "%web", "web1", "web2")
+}
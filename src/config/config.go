@@ -1,9 +1,10 @@
 package config
 
 import (
-	"conductor"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
@@ -14,9 +15,10 @@ import (
 // XcConfig represents XC configuration structure
 type XcConfig struct {
 	Readline  *readline.Config
-	Conductor *conductor.ConductorConfig
+	Conductor *ConductorConfig
 
 	User              string
+	UserSet           bool
 	SSHThreads        int
 	SSHConnectTimeout int
 	PingCount         int
@@ -33,10 +35,55 @@ type XcConfig struct {
 	ExecConfirm       bool
 	BackendType       string
 	LocalFile         string
+	Transport         string
+	SSHKeyFiles       []string
+	SSHMultiplex      bool
+	SSHMultiplexIdle  int
+	OutputFormat      string
+	Timeout           int
+	BatchSize         string
+	BatchPause        int
+	MaxErrors         string
+	JournalFile       string
+	JournalOutput     int
+
+	ExecBackendCommand string
+	ExecBackendResolve bool
+	ExecBackendTimeout int
+	AnsibleInventory   string
+	SSHConfigFile      string
 
 	SudoInterpreter string
 	SuInterpreter   string
 	Interpreter     string
+
+	// Backends are the named backends used simultaneously,
+	// empty unless main.backends is set
+	Backends []*NamedBackend
+
+	// Filename is the file the config is read from
+	Filename string
+
+	props propReader
+}
+
+// NamedBackend is a backend configured in a [backend.<name>] section
+type NamedBackend struct {
+	Name   string
+	Config *XcConfig
+}
+
+type propReader interface {
+	GetString(key string) (string, error)
+	GetInt(key string) (int, error)
+	GetBool(key string) (bool, error)
+}
+
+// backendProps looks settings up in a backend section first, so
+// [backend.lab] may override any setting, i.e. "ansible.inventory = ..."
+type backendProps struct {
+	propReader
+	section string
 }
 
 const (
@@ -52,6 +99,9 @@ exit_confirm = true
 exec_confirm = true
 backend_type = conductor
 local_file = ~/.xc_hosts
+output_format = text
+journal_file = 
+journal_output = 65536
 
 [executer]
 ssh_threads = 50
@@ -60,6 +110,14 @@ progress_bar = true
 prepend_hostnames = true
 remote_tmpdir = /tmp
 delay = 0
+timeout = 0
+batch_size = 10%
+batch_pause = 0
+max_errors = 0
+transport = openssh
+ssh_keys = ~/.ssh/id_rsa,~/.ssh/id_ecdsa,~/.ssh/id_ed25519
+ssh_multiplex = true
+ssh_multiplex_idle = 600
 
 interpreter = bash
 interpreter_sudo = sudo bash
@@ -68,14 +126,71 @@ interpreter_su = su -
 [inventoree]
 url = http://c.inventoree.ru
 work_groups = 
+incremental = false
+timeout = 30
+retries = 2
+token = 
+user = 
+password = 
+ca_file = 
+cert_file = 
+key_file = 
+proxy = 
+
+[exec]
+command = 
+resolve = false
+timeout = 30
+
+[ansible]
+inventory = /etc/ansible/hosts
+
+[sshconfig]
+file = ~/.ssh/config
 `
 )
 
+// ConductorConfig represents configuration for the conductor backend.
+// A cache older than CacheTTL is still used but gets refreshed in
+// background, a cache older than CacheHardTTL is not used unless
+// inventoree is unavailable
+type ConductorConfig struct {
+	CacheTTL      time.Duration
+	CacheHardTTL  time.Duration
+	CacheDir      string
+	Snapshots     int
+	WorkGroupList []string
+	RemoteUrl     string
+	Incremental   bool
+	HTTP          HTTPConfig
+}
+
+// HTTPConfig holds settings of the client requesting inventoree
+type HTTPConfig struct {
+	Timeout  time.Duration
+	Token    string
+	User     string
+	Password string
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	Proxy    string
+	Retries  int
+}
+
 var (
-	defaultConductorConfig = &conductor.ConductorConfig{
+	backendName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	defaultConductorConfig = &ConductorConfig{
 		CacheTTL:      time.Hour * 24,
+		CacheHardTTL:  time.Hour * 168,
+		Snapshots:     10,
 		WorkGroupList: []string{},
 		RemoteUrl:     "http://c.inventoree.ru",
+		HTTP: HTTPConfig{
+			Timeout: 30 * time.Second,
+			Retries: 2,
+		},
 	}
 
 	defaultReadlineConfig = &readline.Config{
@@ -87,6 +202,8 @@ var (
 	defaultCacheDir          = "~/.xc_cache"
 	defaultRCfile            = "~/.xcrc"
 	defaultCacheTTL          = 24
+	defaultCacheHardTTL      = 168
+	defaultCacheSnapshots    = 10
 	defaultUser              = os.Getenv("USER")
 	defaultThreads           = 50
 	defaultTmpDir            = "/tmp"
@@ -106,6 +223,20 @@ var (
 	defaultInterpreter       = "/bin/bash"
 	defaultSudoInterpreter   = "sudo /bin/bash"
 	defaultSuInterpreter     = "su -"
+	defaultTransport         = "openssh"
+	defaultSSHKeyFiles       = "~/.ssh/id_rsa,~/.ssh/id_ecdsa,~/.ssh/id_ed25519"
+	defaultSSHMultiplex      = true
+	defaultSSHMultiplexIdle  = 600
+	defaultOutputFormat      = "text"
+	defaultTimeout           = 0
+	defaultBatchSize         = "10%"
+	defaultBatchPause        = 0
+	defaultMaxErrors         = "0"
+	defaultExecTimeout       = 30
+	defaultAnsibleInventory  = "/etc/ansible/hosts"
+	defaultSSHConfigFile     = "~/.ssh/config"
+	defaultJournalFile       = ""
+	defaultJournalOutput     = 65536
 )
 
 func expandPath(path string) string {
@@ -115,6 +246,15 @@ func expandPath(path string) string {
 	return os.ExpandEnv(path)
 }
 
+// Option returns a raw config value by its "section.key" name. Backends
+// registered outside of xc read their own settings with it
+func (xc *XcConfig) Option(key string) (string, error) {
+	if xc.props == nil {
+		return "", fmt.Errorf("config is not loaded")
+	}
+	return xc.props.GetString(key)
+}
+
 // ReadConfig reads the config from a given file and returns a parsed result
 func ReadConfig(filename string) (*XcConfig, error) {
 	return readConfig(filename, false)
@@ -141,6 +281,8 @@ func readConfig(filename string, secondPass bool) (*XcConfig, error) {
 	}
 
 	xc := new(XcConfig)
+	xc.props = props
+	xc.Filename = filename
 	xc.Readline = defaultReadlineConfig
 	xc.Conductor = defaultConductorConfig
 
@@ -162,20 +304,23 @@ func readConfig(filename string, secondPass bool) (*XcConfig, error) {
 	}
 	xc.LogFile = expandPath(lf)
 
-	cttl, err := props.GetInt("main.cache_ttl")
+	jf, err := props.GetString("main.journal_file")
 	if err != nil {
-		cttl = defaultCacheTTL
+		jf = defaultJournalFile
 	}
-	xc.Conductor.CacheTTL = time.Hour * time.Duration(cttl)
+	xc.JournalFile = expandPath(jf)
 
-	cd, err := props.GetString("main.cache_dir")
+	jout, err := props.GetInt("main.journal_output")
 	if err != nil {
-		cd = defaultCacheDir
+		jout = defaultJournalOutput
 	}
-	xc.Conductor.CacheDir = expandPath(cd)
+	xc.JournalOutput = jout
+
+	readBackendConfig(xc, props)
 
 	user, err := props.GetString("main.user")
-	if err != nil || user == "" {
+	xc.UserSet = err == nil && user != ""
+	if !xc.UserSet {
 		user = defaultUser
 	}
 	xc.User = user
@@ -198,6 +343,30 @@ func readConfig(filename string, secondPass bool) (*XcConfig, error) {
 	}
 	xc.Delay = delay
 
+	timeout, err := props.GetInt("executer.timeout")
+	if err != nil {
+		timeout = defaultTimeout
+	}
+	xc.Timeout = timeout
+
+	bsize, err := props.GetString("executer.batch_size")
+	if err != nil {
+		bsize = defaultBatchSize
+	}
+	xc.BatchSize = bsize
+
+	bpause, err := props.GetInt("executer.batch_pause")
+	if err != nil {
+		bpause = defaultBatchPause
+	}
+	xc.BatchPause = bpause
+
+	maxerr, err := props.GetString("executer.max_errors")
+	if err != nil {
+		maxerr = defaultMaxErrors
+	}
+	xc.MaxErrors = maxerr
+
 	tmpdir, err := props.GetString("executer.remote_tmpdir")
 	if err != nil {
 		tmpdir = defaultTmpDir
@@ -228,16 +397,6 @@ func readConfig(filename string, secondPass bool) (*XcConfig, error) {
 	}
 	xc.Interpreter = intrpr
 
-	invURL, err := props.GetString("inventoree.url")
-	if err == nil {
-		xc.Conductor.RemoteUrl = invURL
-	}
-
-	wglist, err := props.GetString("inventoree.work_groups")
-	if err == nil {
-		xc.Conductor.WorkGroupList = strings.Split(wglist, ",")
-	}
-
 	rt, err := props.GetString("main.raise")
 	if err != nil {
 		rt = defaultRaiseType
@@ -268,17 +427,41 @@ func readConfig(filename string, secondPass bool) (*XcConfig, error) {
 	}
 	xc.ExecConfirm = execcnfrm
 
-	bknd, err := props.GetString("main.backend_type")
+	ofmt, err := props.GetString("main.output_format")
 	if err != nil {
-		bknd = defaultBackendType
+		ofmt = defaultOutputFormat
 	}
-	xc.BackendType = bknd
+	xc.OutputFormat = ofmt
 
-	lfile, err := props.GetString("main.local_file")
+	transport, err := props.GetString("executer.transport")
 	if err != nil {
-		lfile = defaultLocalFile
+		transport = defaultTransport
 	}
-	xc.LocalFile = expandPath(lfile)
+	xc.Transport = transport
+
+	keys, err := props.GetString("executer.ssh_keys")
+	if err != nil {
+		keys = defaultSSHKeyFiles
+	}
+	xc.SSHKeyFiles = make([]string, 0)
+	for _, kf := range strings.Split(keys, ",") {
+		kf = strings.TrimSpace(kf)
+		if kf != "" {
+			xc.SSHKeyFiles = append(xc.SSHKeyFiles, expandPath(kf))
+		}
+	}
+
+	mux, err := props.GetBool("executer.ssh_multiplex")
+	if err != nil {
+		mux = defaultSSHMultiplex
+	}
+	xc.SSHMultiplex = mux
+
+	muxIdle, err := props.GetInt("executer.ssh_multiplex_idle")
+	if err != nil {
+		muxIdle = defaultSSHMultiplexIdle
+	}
+	xc.SSHMultiplexIdle = muxIdle
 
 	pbar, err := props.GetBool("executer.progress_bar")
 	if err != nil {
@@ -292,5 +475,171 @@ func readConfig(filename string, secondPass bool) (*XcConfig, error) {
 	}
 	xc.PrependHostnames = phn
 
+	backends, err := props.GetString("main.backends")
+	if err == nil {
+		xc.Backends, err = readNamedBackends(xc, props, backends)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return xc, nil
 }
+
+func (bp *backendProps) GetString(key string) (string, error) {
+	if value, err := bp.propReader.GetString(bp.section + "." + key); err == nil {
+		return value, nil
+	}
+	return bp.propReader.GetString(key)
+}
+
+func (bp *backendProps) GetInt(key string) (int, error) {
+	if value, err := bp.propReader.GetInt(bp.section + "." + key); err == nil {
+		return value, nil
+	}
+	return bp.propReader.GetInt(key)
+}
+
+func (bp *backendProps) GetBool(key string) (bool, error) {
+	if value, err := bp.propReader.GetBool(bp.section + "." + key); err == nil {
+		return value, nil
+	}
+	return bp.propReader.GetBool(key)
+}
+
+// readNamedBackends reads configs of the comma-separated list of backends.
+// Each one is a copy of the main config with backend settings overridden
+// by its [backend.<name>] section, the backend type is set with "type"
+func readNamedBackends(xc *XcConfig, props propReader, names string) ([]*NamedBackend, error) {
+	backends := make([]*NamedBackend, 0)
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !backendName.MatchString(name) {
+			return nil, fmt.Errorf("invalid backend name \"%s\", only letters, digits, - and _ are allowed", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("backend %s is listed twice in main.backends", name)
+		}
+		seen[name] = true
+
+		bp := &backendProps{props, "backend." + name}
+		bc := *xc
+		cc := *xc.Conductor
+		bc.Conductor = &cc
+		bc.props = bp
+		readBackendConfig(&bc, bp)
+		if bt, err := props.GetString(bp.section + ".type"); err == nil && bt != "" {
+			bc.BackendType = bt
+		}
+		backends = append(backends, &NamedBackend{name, &bc})
+	}
+	return backends, nil
+}
+
+// readBackendConfig reads the settings backends are created with
+func readBackendConfig(xc *XcConfig, props propReader) {
+	cttl, err := props.GetInt("main.cache_ttl")
+	if err != nil {
+		cttl = defaultCacheTTL
+	}
+	xc.Conductor.CacheTTL = time.Hour * time.Duration(cttl)
+
+	chttl, err := props.GetInt("main.cache_hard_ttl")
+	if err != nil {
+		chttl = defaultCacheHardTTL
+	}
+	xc.Conductor.CacheHardTTL = time.Hour * time.Duration(chttl)
+
+	snapshots, err := props.GetInt("main.cache_snapshots")
+	if err != nil {
+		snapshots = defaultCacheSnapshots
+	}
+	xc.Conductor.Snapshots = snapshots
+
+	cd, err := props.GetString("main.cache_dir")
+	if err != nil {
+		cd = defaultCacheDir
+	}
+	xc.Conductor.CacheDir = expandPath(cd)
+
+	invURL, err := props.GetString("inventoree.url")
+	if err == nil {
+		xc.Conductor.RemoteUrl = invURL
+	}
+
+	wglist, err := props.GetString("inventoree.work_groups")
+	if err == nil {
+		xc.Conductor.WorkGroupList = strings.Split(wglist, ",")
+	}
+
+	incremental, err := props.GetBool("inventoree.incremental")
+	if err == nil {
+		xc.Conductor.Incremental = incremental
+	}
+
+	invTimeout, err := props.GetInt("inventoree.timeout")
+	if err == nil {
+		xc.Conductor.HTTP.Timeout = time.Second * time.Duration(invTimeout)
+	}
+
+	invRetries, err := props.GetInt("inventoree.retries")
+	if err == nil {
+		xc.Conductor.HTTP.Retries = invRetries
+	}
+
+	xc.Conductor.HTTP.Token, _ = props.GetString("inventoree.token")
+	xc.Conductor.HTTP.User, _ = props.GetString("inventoree.user")
+	xc.Conductor.HTTP.Password, _ = props.GetString("inventoree.password")
+	xc.Conductor.HTTP.Proxy, _ = props.GetString("inventoree.proxy")
+
+	caFile, _ := props.GetString("inventoree.ca_file")
+	xc.Conductor.HTTP.CAFile = expandPath(caFile)
+	certFile, _ := props.GetString("inventoree.cert_file")
+	xc.Conductor.HTTP.CertFile = expandPath(certFile)
+	keyFile, _ := props.GetString("inventoree.key_file")
+	xc.Conductor.HTTP.KeyFile = expandPath(keyFile)
+
+	execCmd, err := props.GetString("exec.command")
+	if err == nil {
+		xc.ExecBackendCommand = execCmd
+	}
+
+	execResolve, err := props.GetBool("exec.resolve")
+	if err == nil {
+		xc.ExecBackendResolve = execResolve
+	}
+
+	execTimeout, err := props.GetInt("exec.timeout")
+	if err != nil {
+		execTimeout = defaultExecTimeout
+	}
+	xc.ExecBackendTimeout = execTimeout
+
+	ansibleInv, err := props.GetString("ansible.inventory")
+	if err != nil || ansibleInv == "" {
+		ansibleInv = defaultAnsibleInventory
+	}
+	xc.AnsibleInventory = expandPath(ansibleInv)
+
+	sshcfg, err := props.GetString("sshconfig.file")
+	if err != nil || sshcfg == "" {
+		sshcfg = defaultSSHConfigFile
+	}
+	xc.SSHConfigFile = expandPath(sshcfg)
+
+	bknd, err := props.GetString("main.backend_type")
+	if err != nil {
+		bknd = defaultBackendType
+	}
+	xc.BackendType = bknd
+
+	lfile, err := props.GetString("main.local_file")
+	if err != nil {
+		lfile = defaultLocalFile
+	}
+	xc.LocalFile = expandPath(lfile)
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Option is a config file setting, Key is its full "section.key" name
+type Option struct {
+	Key   string
+	Value string
+}
+
+var (
+	sectionLine = regexp.MustCompile(`^\s*\[([^\]]+)\]\s*$`)
+	optionLine  = regexp.MustCompile(`^(\s*)([^#;=\s][^=]*?)\s*=\s*(.*?)\s*$`)
+)
+
+func fullKey(section, key string) string {
+	if section == "" {
+		return key
+	}
+	return section + "." + key
+}
+
+// ListOptions returns the settings of a config file in the order they're found
+func ListOptions(filename string) ([]Option, error) {
+	lines, err := readFileLines(filename)
+	if err != nil {
+		return nil, err
+	}
+	options := make([]Option, 0)
+	section := ""
+	for _, line := range lines {
+		if m := sectionLine.FindStringSubmatch(line); m != nil {
+			section = strings.TrimSpace(m[1])
+		} else if m := optionLine.FindStringSubmatch(line); m != nil {
+			options = append(options, Option{fullKey(section, m[2]), m[3]})
+		}
+	}
+	return options, nil
+}
+
+// SetOptions changes the settings of a config file keeping the rest of it,
+// comments included, as is. Settings missing are added to their sections
+func SetOptions(filename string, options ...Option) error {
+	lines, err := readFileLines(filename)
+	if err != nil {
+		return err
+	}
+	for _, opt := range options {
+		lines = setOption(lines, opt)
+	}
+	return ioutil.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+func readFileLines(filename string) ([]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return []string{}, nil
+	}
+	return strings.Split(text, "\n"), nil
+}
+
+func setOption(lines []string, opt Option) []string {
+	res := make([]string, len(lines))
+	copy(res, lines)
+
+	found := false
+	section := ""
+	sections := make([]string, 0)
+	for i, line := range res {
+		if m := sectionLine.FindStringSubmatch(line); m != nil {
+			section = strings.TrimSpace(m[1])
+			sections = append(sections, section)
+		} else if m := optionLine.FindStringSubmatch(line); m != nil && fullKey(section, m[2]) == opt.Key {
+			res[i] = m[1] + m[2] + " = " + opt.Value
+			found = true
+		}
+	}
+	if found {
+		return res
+	}
+
+	section, key := splitKey(opt.Key, sections)
+	line := key + " = " + opt.Value
+
+	// the option goes after the last one of its section
+	pos := -1
+	current := ""
+	for i, l := range res {
+		if m := sectionLine.FindStringSubmatch(l); m != nil {
+			current = strings.TrimSpace(m[1])
+			if current == section && pos < 0 {
+				pos = i + 1
+			}
+		} else if current == section && optionLine.MatchString(l) {
+			pos = i + 1
+		}
+	}
+	if pos < 0 && section == "" {
+		pos = 0
+	}
+	if pos < 0 {
+		if len(res) > 0 && strings.TrimSpace(res[len(res)-1]) != "" {
+			res = append(res, "")
+		}
+		return append(res, "["+section+"]", line)
+	}
+	res = append(res, "")
+	copy(res[pos+1:], res[pos:])
+	res[pos] = line
+	return res
+}
+
+// splitKey splits a full option name into its section and key. Keys of
+// backend sections may contain dots so the longest existing section
+// wins, names of new sections are guessed
+func splitKey(name string, sections []string) (string, string) {
+	sort.Slice(sections, func(i, j int) bool { return len(sections[i]) > len(sections[j]) })
+	for _, section := range sections {
+		if strings.HasPrefix(name, section+".") {
+			return section, name[len(section)+1:]
+		}
+	}
+	tokens := strings.SplitN(name, ".", 3)
+	if tokens[0] == "backend" && len(tokens) == 3 {
+		return tokens[0] + "." + tokens[1], tokens[2]
+	}
+	tokens = strings.SplitN(name, ".", 2)
+	if len(tokens) < 2 {
+		return "", name
+	}
+	return tokens[0], tokens[1]
+}
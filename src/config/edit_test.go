@@ -0,0 +1,87 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+const testConfig = `# xc config
+[main]
+user =
+# mode comment
+mode = parallel
+
+[executer]
+delay = 0
+
+[backend.lab]
+type = localini
+inventoree.work_groups = lab
+`
+
+func TestSetOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xc-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := path.Join(dir, "xc.conf")
+	if err := ioutil.WriteFile(filename, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = SetOptions(filename,
+		Option{"main.mode", "serial"},
+		Option{"main.debug", "true"},
+		Option{"executer.ssh_threads", "20"},
+		Option{"backend.lab.inventoree.work_groups", "lab2"},
+		Option{"backend.prod.type", "conductor"},
+		Option{"ansible.inventory", "~/hosts"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# xc config
+[main]
+user =
+# mode comment
+mode = serial
+debug = true
+
+[executer]
+delay = 0
+ssh_threads = 20
+
+[backend.lab]
+type = localini
+inventoree.work_groups = lab2
+
+[backend.prod]
+type = conductor
+
+[ansible]
+inventory = ~/hosts
+`
+	if string(data) != expected {
+		t.Errorf("unexpected config:\n%s\nexpected:\n%s", data, expected)
+	}
+
+	options, err := ListOptions(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(options[:3], []Option{{"main.user", ""}, {"main.mode", "serial"}, {"main.debug", "true"}}) {
+		t.Errorf("unexpected options %v", options[:3])
+	}
+	if len(options) != 9 {
+		t.Errorf("9 options expected, got %d", len(options))
+	}
+}
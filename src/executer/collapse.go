@@ -46,11 +46,13 @@ func Collapse(hosts []string, cmd string) *ExecResult {
 			// while other tasks on the same server try to remove it afterwards and fail
 			remoteFile := fmt.Sprintf("%s.%s.sh", remoteFilePrefix, host)
 			// create tasks for copying temporary self-destroying script and running it
-			pool.CopyAndExec(host, currentUser, localFile, remoteFile, currentRaise, currentPasswd, remoteFile)
+			pool.CopyAndExec(host, currentUser, localFile, remoteFile, currentRaise, currentPasswd, remoteFile, currentTimeout)
 		}
 	}()
 
-	if currentProgressBar {
+	// progressbar would break the structured output
+	showProgressBar := currentProgressBar && !structuredOutput()
+	if showProgressBar {
 		bar = pb.StartNew(running)
 	}
 
@@ -60,18 +62,24 @@ runLoop:
 		case d := <-pool.Data:
 			switch d.OType {
 			case remote.OutputTypeStdout:
+				if structuredOutput() {
+					result.addOutput(d)
+				}
 				outputs[d.Host] += string(d.Data)
 				logData := make([]byte, len(d.Data))
 				copy(logData, d.Data)
 				if !bytes.HasSuffix(logData, []byte{'\n'}) {
 					logData = append(logData, '\n')
 				}
-				writeHostOutput(d.Host, logData)
+				result.writeHostOutput(d.Host, logData)
 			case remote.OutputTypeStderr:
+				if structuredOutput() {
+					result.addOutput(d)
+				}
 				if !bytes.HasSuffix(d.Data, []byte{'\n'}) {
 					d.Data = append(d.Data, '\n')
 				}
-				writeHostOutput(d.Host, d.Data)
+				result.writeHostOutput(d.Host, d.Data)
 			case remote.OutputTypeDebug:
 				if currentDebug {
 					log.Debugf("DATASTREAM @ %s\n%v\n[%v]", d.Host, d.Data, string(d.Data))
@@ -81,15 +89,10 @@ runLoop:
 					copied++
 				}
 			case remote.OutputTypeExecFinished:
-				if currentProgressBar {
+				if showProgressBar {
 					bar.Increment()
 				}
-				result.Codes[d.Host] = d.StatusCode
-				if d.StatusCode == 0 {
-					result.Success = append(result.Success, d.Host)
-				} else {
-					result.Error = append(result.Error, d.Host)
-				}
+				result.addResult(d.Host, d.StatusCode)
 				running--
 				if running == 0 {
 					break runLoop
@@ -101,7 +104,7 @@ runLoop:
 		}
 	}
 
-	if currentProgressBar {
+	if showProgressBar {
 		bar.Finish()
 	}
 
@@ -31,13 +31,21 @@ func Distribute(hosts []string, localFilename string, remoteFilename string) *Ex
 				running--
 				result.Codes[d.Host] = d.StatusCode
 				if d.StatusCode == 0 {
-					fmt.Printf("%s: copied OK\n", term.Blue(d.Host))
+					if !structuredOutput() {
+						fmt.Printf("%s: copied OK\n", term.Blue(d.Host))
+					}
 					result.Success = append(result.Success, d.Host)
 				} else {
-					fmt.Printf("%s: Copy error\n", term.Red(d.Host))
+					if !structuredOutput() {
+						fmt.Printf("%s: Copy error\n", term.Red(d.Host))
+					}
 					result.Error = append(result.Error, d.Host)
 				}
 			case remote.OutputTypeStderr:
+				if structuredOutput() {
+					result.addOutput(d)
+					continue
+				}
 				if !bytes.HasSuffix(d.Data, []byte{'\n'}) {
 					d.Data = append(d.Data, '\n')
 				}
@@ -50,3 +58,13 @@ func Distribute(hosts []string, localFilename string, remoteFilename string) *Ex
 
 	return result
 }
+
+// AddCopyErrors adds hosts Distribute has failed to copy a file to along
+// with their output to the result of the command run after copying
+func (r *ExecResult) AddCopyErrors(copyResult *ExecResult) {
+	for _, host := range copyResult.Error {
+		r.Codes[host] = copyResult.Codes[host]
+	}
+	r.Error = append(r.Error, copyResult.Error...)
+	r.records = append(copyResult.records, r.records...)
+}
@@ -23,7 +23,9 @@ var (
 	currentRemoteTmpdir     string
 	currentProgressBar      bool
 	currentPrependHostnames bool
+	currentTimeout          time.Duration
 	outputFile              *os.File
+	currentCaptureLimit     int
 	log                     = logging.MustGetLogger("xc")
 )
 
@@ -35,10 +37,18 @@ type ExecResult struct {
 	Success []string
 	// Error holds unsuccessful hosts
 	Error []string
+	// TimedOut holds hosts which were killed by timeout, they are in Error as well
+	TimedOut []string
+	// Skipped holds hosts which weren't processed because the execution was aborted
+	Skipped []string
 	// Stopped holds hosts which weren't able to complete task
 	Stopped int
 	// OutputMap structures hosts by different outputs
 	OutputMap map[string][]string
+	// Output holds the output of every host when capturing is on, see SetCaptureLimit
+	Output map[string][]byte
+
+	records []*outputRecord
 }
 
 // Initialize initializes executer pool and configuration
@@ -90,6 +100,18 @@ func SetRemoteTmpdir(tmpDir string) {
 	currentRemoteTmpdir = tmpDir
 }
 
+// SetTimeout sets the number of seconds a command may run on a host,
+// 0 switches the timeout off
+func SetTimeout(timeout int) {
+	currentTimeout = time.Duration(timeout) * time.Second
+}
+
+// SetCaptureLimit sets the number of bytes of every host's output kept
+// in ExecResult.Output, 0 switches capturing off
+func SetCaptureLimit(limit int) {
+	currentCaptureLimit = limit
+}
+
 // SetPrependHostnames sets current prepend_hostnames value for parallel mode
 func SetPrependHostnames(prependHostnames bool) {
 	currentPrependHostnames = prependHostnames
@@ -100,10 +122,26 @@ func newExecResults() *ExecResult {
 	er.Codes = make(map[string]int)
 	er.Success = make([]string, 0)
 	er.Error = make([]string, 0)
+	er.TimedOut = make([]string, 0)
+	er.Skipped = make([]string, 0)
 	er.OutputMap = make(map[string][]string)
+	er.Output = make(map[string][]byte)
 	return er
 }
 
+// addResult puts the host into the corresponding lists according to the status code
+func (r *ExecResult) addResult(host string, code int) {
+	r.Codes[host] = code
+	if code == 0 {
+		r.Success = append(r.Success, host)
+		return
+	}
+	r.Error = append(r.Error, host)
+	if code == remote.ErrTimeout {
+		r.TimedOut = append(r.TimedOut, host)
+	}
+}
+
 func prepareTempFiles(cmd string) (string, string, error) {
 	f, err := ioutil.TempFile("", "xc.")
 	if err != nil {
@@ -122,16 +160,34 @@ func prepareTempFiles(cmd string) (string, string, error) {
 
 // Print prints ExecResults in a nice way
 func (r *ExecResult) Print() {
+	if structuredOutput() {
+		r.printStructured()
+		return
+	}
 	msg := fmt.Sprintf(" Hosts processed: %d, success: %d, error: %d    ",
 		len(r.Success)+len(r.Error), len(r.Success), len(r.Error))
+	if len(r.TimedOut) > 0 {
+		msg = fmt.Sprintf(" Hosts processed: %d, success: %d, error: %d, timed out: %d    ",
+			len(r.Success)+len(r.Error), len(r.Success), len(r.Error), len(r.TimedOut))
+	}
 	h := term.HR(len(msg))
 	fmt.Println(term.Green(h))
 	fmt.Println(term.Green(msg))
 	fmt.Println(term.Green(h))
+	if len(r.TimedOut) > 0 {
+		fmt.Printf("%s %s\n", term.Red("Timed out:"), strings.Join(r.TimedOut, ","))
+	}
+	if len(r.Skipped) > 0 {
+		fmt.Printf("%s %s\n", term.Yellow("Skipped:"), strings.Join(r.Skipped, ","))
+	}
 }
 
 // PrintOutputMap prints collapsed-style output
 func (r *ExecResult) PrintOutputMap() {
+	if structuredOutput() {
+		// the output has been already collected as records
+		return
+	}
 	for output, hosts := range r.OutputMap {
 		msg := fmt.Sprintf(" %d host(s): %s   ", len(hosts), strings.Join(hosts, ","))
 		tableWidth := len(msg) + 2
@@ -157,7 +213,14 @@ func WriteOutput(message string) {
 	outputFile.Write([]byte(message))
 }
 
-func writeHostOutput(host string, data []byte) {
+// writeHostOutput writes output of a host to the logfile and captures it
+func (r *ExecResult) writeHostOutput(host string, data []byte) {
+	if room := currentCaptureLimit - len(r.Output[host]); room > 0 {
+		if len(data) < room {
+			room = len(data)
+		}
+		r.Output[host] = append(r.Output[host], data[:room]...)
+	}
 	message := fmt.Sprintf("%s: %s", host, string(data))
 	WriteOutput(message)
 }
@@ -0,0 +1,105 @@
+package executer
+
+import (
+	"encoding/json"
+	"os"
+	"remote"
+	"time"
+)
+
+// OutputFormat is a enum of exec output formats
+type OutputFormat int
+
+// Enum of output formats
+const (
+	OutputFormatText OutputFormat = iota
+	OutputFormatJSON
+	OutputFormatNDJSON
+)
+
+type outputRecord struct {
+	Type      string    `json:"type"`
+	Host      string    `json:"host"`
+	Stream    string    `json:"stream"`
+	Timestamp time.Time `json:"timestamp"`
+	Data      string    `json:"data"`
+}
+
+type summaryRecord struct {
+	Type     string         `json:"type"`
+	Codes    map[string]int `json:"codes"`
+	Success  []string       `json:"success"`
+	Error    []string       `json:"error"`
+	TimedOut []string       `json:"timed_out"`
+	Skipped  []string       `json:"skipped"`
+	Stopped  int            `json:"stopped"`
+}
+
+type jsonDocument struct {
+	Output  []*outputRecord `json:"output"`
+	Summary *summaryRecord  `json:"summary"`
+}
+
+var (
+	currentOutputFormat = OutputFormatText
+	streamNames         = map[remote.OutputType]string{
+		remote.OutputTypeStdout: "stdout",
+		remote.OutputTypeStderr: "stderr",
+	}
+)
+
+// SetOutputFormat sets the format exec results are printed in
+func SetOutputFormat(format OutputFormat) {
+	currentOutputFormat = format
+}
+
+func structuredOutput() bool {
+	return currentOutputFormat != OutputFormatText
+}
+
+func writeJSON(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("Error encoding output record: %s", err)
+		return
+	}
+	os.Stdout.Write(append(data, '\n'))
+}
+
+// addOutput either streams a chunk of output as a ndjson record
+// or keeps it to be printed with the summary in json format
+func (r *ExecResult) addOutput(d *remote.Output) {
+	rec := &outputRecord{
+		Type:      "output",
+		Host:      d.Host,
+		Stream:    streamNames[d.OType],
+		Timestamp: time.Now(),
+		Data:      string(d.Data),
+	}
+	if currentOutputFormat == OutputFormatNDJSON {
+		writeJSON(rec)
+	} else {
+		r.records = append(r.records, rec)
+	}
+}
+
+func (r *ExecResult) printStructured() {
+	summary := &summaryRecord{
+		Type:     "summary",
+		Codes:    r.Codes,
+		Success:  r.Success,
+		Error:    r.Error,
+		TimedOut: r.TimedOut,
+		Skipped:  r.Skipped,
+		Stopped:  r.Stopped,
+	}
+	if currentOutputFormat == OutputFormatNDJSON {
+		writeJSON(summary)
+		return
+	}
+	records := r.records
+	if records == nil {
+		records = make([]*outputRecord, 0)
+	}
+	writeJSON(&jsonDocument{records, summary})
+}
@@ -46,7 +46,7 @@ func Parallel(hosts []string, cmd string) *ExecResult {
 			// while other tasks on the same server try to remove it afterwards and fail
 			remoteFile := fmt.Sprintf("%s.%s.sh", remoteFilePrefix, host)
 			// create tasks for copying temporary self-destroying script and running it
-			pool.CopyAndExec(host, currentUser, localFile, remoteFile, currentRaise, currentPasswd, remoteFile)
+			pool.CopyAndExec(host, currentUser, localFile, remoteFile, currentRaise, currentPasswd, remoteFile, currentTimeout)
 		}
 	}()
 
@@ -56,6 +56,11 @@ runLoop:
 		case d := <-pool.Data:
 			switch d.OType {
 			case remote.OutputTypeStdout:
+				if structuredOutput() {
+					result.addOutput(d)
+					result.writeHostOutput(d.Host, d.Data)
+					continue
+				}
 				if !bytes.HasSuffix(d.Data, []byte{'\n'}) {
 					d.Data = append(d.Data, '\n')
 				}
@@ -63,8 +68,13 @@ runLoop:
 					fmt.Printf("%s: ", term.Blue(d.Host))
 				}
 				fmt.Print(string(d.Data))
-				writeHostOutput(d.Host, d.Data)
+				result.writeHostOutput(d.Host, d.Data)
 			case remote.OutputTypeStderr:
+				if structuredOutput() {
+					result.addOutput(d)
+					result.writeHostOutput(d.Host, d.Data)
+					continue
+				}
 				if !bytes.HasSuffix(d.Data, []byte{'\n'}) {
 					d.Data = append(d.Data, '\n')
 				}
@@ -72,7 +82,7 @@ runLoop:
 					fmt.Printf("%s: ", term.Red(d.Host))
 				}
 				fmt.Print(string(d.Data))
-				writeHostOutput(d.Host, d.Data)
+				result.writeHostOutput(d.Host, d.Data)
 			case remote.OutputTypeDebug:
 				if currentDebug {
 					log.Debugf("DATASTREAM @ %s\n%v\n[%v]", d.Host, d.Data, string(d.Data))
@@ -82,12 +92,7 @@ runLoop:
 					copied++
 				}
 			case remote.OutputTypeExecFinished:
-				result.Codes[d.Host] = d.StatusCode
-				if d.StatusCode == 0 {
-					result.Success = append(result.Success, d.Host)
-				} else {
-					result.Error = append(result.Error, d.Host)
-				}
+				result.addResult(d.Host, d.StatusCode)
 				running--
 				if running == 0 {
 					break runLoop
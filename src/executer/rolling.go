@@ -0,0 +1,147 @@
+package executer
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"term"
+	"time"
+)
+
+// Threshold is a number of hosts given either as an absolute
+// value or as a percentage of a list of hosts
+type Threshold struct {
+	Value   int
+	Percent bool
+}
+
+// ParseThreshold parses threshold from strings like "5" or "10%"
+func ParseThreshold(s string) (Threshold, error) {
+	t := Threshold{}
+	if strings.HasSuffix(s, "%") {
+		t.Percent = true
+		s = s[:len(s)-1]
+	}
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return t, err
+	}
+	if value < 0 {
+		return t, fmt.Errorf("value can't be negative")
+	}
+	if t.Percent && value > 100 {
+		return t, fmt.Errorf("percentage can't be higher than 100")
+	}
+	t.Value = int(value)
+	return t, nil
+}
+
+// Of returns the absolute number of hosts the threshold
+// means for a list of a given length
+func (t Threshold) Of(total int) int {
+	if t.Percent {
+		return total * t.Value / 100
+	}
+	return t.Value
+}
+
+func (t Threshold) String() string {
+	if t.Percent {
+		return fmt.Sprintf("%d%%", t.Value)
+	}
+	return fmt.Sprintf("%d", t.Value)
+}
+
+// merge appends another result to this one
+func (r *ExecResult) merge(other *ExecResult) {
+	for host, code := range other.Codes {
+		r.Codes[host] = code
+	}
+	r.Success = append(r.Success, other.Success...)
+	r.Error = append(r.Error, other.Error...)
+	r.TimedOut = append(r.TimedOut, other.TimedOut...)
+	r.Stopped += other.Stopped
+	r.records = append(r.records, other.records...)
+	for host, output := range other.Output {
+		r.Output[host] = output
+	}
+}
+
+// Rolling runs tasks in parallel batches, one batch at a time, holding for
+// a pause between batches. Execution is aborted when the number of errors
+// in a batch exceeds maxErrors, the rest of the hosts are put into Skipped
+func Rolling(hosts []string, cmd string, batchSize Threshold, pause int, maxErrors Threshold) *ExecResult {
+	result := newExecResults()
+	if len(hosts) == 0 {
+		return result
+	}
+
+	size := batchSize.Of(len(hosts))
+	if size < 1 {
+		size = 1
+	}
+	numBatches := (len(hosts) + size - 1) / size
+
+	for i := 0; i < numBatches; i++ {
+		start := i * size
+		end := start + size
+		if end > len(hosts) {
+			end = len(hosts)
+		}
+		batch := hosts[start:end]
+
+		if !structuredOutput() {
+			msg := fmt.Sprintf(" Batch %d/%d: %d host(s)    ", i+1, numBatches, len(batch))
+			fmt.Println(term.Cyan(term.HR(len(msg))))
+			fmt.Println(term.Cyan(msg))
+			fmt.Println(term.Cyan(term.HR(len(msg))))
+		}
+
+		br := Parallel(batch, cmd)
+		result.merge(br)
+
+		if br.Stopped > 0 {
+			result.Skipped = append(result.Skipped, hosts[end:]...)
+			term.Errorf("Rolling execution stopped by user\n")
+			break
+		}
+
+		threshold := maxErrors.Of(len(batch))
+		if len(br.Error) > threshold {
+			result.Skipped = append(result.Skipped, hosts[end:]...)
+			term.Errorf("Rolling execution aborted: %d error(s) in batch %d exceed the threshold of %d\n",
+				len(br.Error), i+1, threshold)
+			break
+		}
+
+		if i < numBatches-1 && pause > 0 {
+			if !rollingPause(pause) {
+				result.Skipped = append(result.Skipped, hosts[end:]...)
+				term.Errorf("Rolling execution stopped by user\n")
+				break
+			}
+		}
+	}
+	return result
+}
+
+// rollingPause holds for a given number of seconds and
+// returns false if it was interrupted by user
+func rollingPause(pause int) bool {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT)
+	defer signal.Reset()
+
+	select {
+	case <-sigs:
+		if !structuredOutput() {
+			fmt.Println()
+		}
+		return false
+	case <-time.After(time.Duration(pause) * time.Second):
+		return true
+	}
+}
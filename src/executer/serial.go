@@ -57,8 +57,10 @@ func Serial(hosts []string, argv string, delay int) *ExecResult {
 			delay = 0
 		}
 
-		msg := term.HR(7) + " " + host + " " + term.HR(36-len(host))
-		fmt.Println(term.Blue(msg))
+		if !structuredOutput() {
+			msg := term.HR(7) + " " + host + " " + term.HR(36-len(host))
+			fmt.Println(term.Blue(msg))
+		}
 
 		remoteCommand := argv
 		if argv != "" {
@@ -114,8 +116,18 @@ func Serial(hosts []string, argv string, delay int) *ExecResult {
 			continue
 		}
 
+		// interactive sessions (no argv) are never limited by timeout
+		var timer *time.Timer
+		if argv != "" && currentTimeout > 0 {
+			timer = time.AfterFunc(currentTimeout, func() {
+				cmd.Process.Kill()
+			})
+		}
+
 		exitCode = 0
 		err = cmd.Wait()
+		// timer which can't be stopped has fired already
+		timedOut := timer != nil && !timer.Stop()
 		smart.Close()
 		log.Debug("SmartTTY closed")
 		signal.Notify(sigs, os.Interrupt)
@@ -130,14 +142,13 @@ func Serial(hosts []string, argv string, delay int) *ExecResult {
 				exitCode = remote.ErrMacOsExit
 			}
 		}
+		if timedOut {
+			term.Errorf("%s: command timed out\n", host)
+			exitCode = remote.ErrTimeout
+		}
 		log.Debugf("Exit code is %d", exitCode)
 
-		result.Codes[host] = exitCode
-		if exitCode == 0 {
-			result.Success = append(result.Success, host)
-		} else {
-			result.Error = append(result.Error, host)
-		}
+		result.addResult(host, exitCode)
 
 		tick := time.After(time.Duration(delay) * time.Second)
 
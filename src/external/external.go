@@ -0,0 +1,135 @@
+package external
+
+import (
+	"backend"
+	"bytes"
+	"config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"inventory"
+	"os/exec"
+	"parser"
+	"strings"
+	"time"
+)
+
+// External is a backend which takes the inventory from a user-configured
+// local program. The program is called as
+//
+//	<command> dump               - must print the whole inventory as JSON
+//	<command> resolve <expr>     - must print a JSON array of hostnames
+//
+// The dump format is the one of inventory.Data. Expressions are resolved
+// against the dumped inventory unless exec.resolve is set, in which case
+// they are passed to the program as is
+type External struct {
+	*inventory.Inventory
+	command []string
+	resolve bool
+	timeout time.Duration
+}
+
+func init() {
+	backend.Register("exec", func(xc *config.XcConfig) (backend.Backend, error) {
+		return New(xc)
+	})
+}
+
+// New creates an exec backend from the xc configuration
+func New(xc *config.XcConfig) (*External, error) {
+	command := strings.Fields(xc.ExecBackendCommand)
+	if len(command) == 0 {
+		return nil, fmt.Errorf("exec backend requires exec.command to be set")
+	}
+	return &External{
+		Inventory: inventory.New(&inventory.Data{}),
+		command:   command,
+		resolve:   xc.ExecBackendResolve,
+		timeout:   time.Duration(xc.ExecBackendTimeout) * time.Second,
+	}, nil
+}
+
+func (e *External) run(args ...string) ([]byte, error) {
+	ctx := context.Background()
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	argv := append(append([]string{}, e.command[1:]...), args...)
+	cmd := exec.CommandContext(ctx, e.command[0], argv...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s %s timed out after %s", e.command[0], args[0], e.timeout)
+	}
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return nil, fmt.Errorf("%s %s failed: %s", e.command[0], args[0], err)
+		}
+		return nil, fmt.Errorf("%s %s failed: %s: %s", e.command[0], args[0], err, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// Load runs the program's dump and indexes its result
+func (e *External) Load() error {
+	out, err := e.run("dump")
+	if err != nil {
+		return err
+	}
+	data := new(inventory.Data)
+	err = json.Unmarshal(out, data)
+	if err != nil {
+		return fmt.Errorf("error parsing %s dump output: %s", e.command[0], err)
+	}
+	e.Inventory = inventory.New(data)
+	return nil
+}
+
+// Reload does the same as Load as there's no cache
+func (e *External) Reload() error {
+	return e.Load()
+}
+
+// HostList resolves a host expression
+func (e *External) HostList(expr []rune) ([]string, error) {
+	ast, err := parser.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	// pseudo groups only exist within xc so the program can't resolve them
+	if !e.resolve || hasPseudoGroups(ast) {
+		return ast.Evaluate(e.ResolveToken)
+	}
+
+	out, err := e.run("resolve", string(expr))
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]string, 0)
+	err = json.Unmarshal(out, &hosts)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s resolve output: %s", e.command[0], err)
+	}
+	return hosts, nil
+}
+
+func hasPseudoGroups(node *parser.Node) bool {
+	if node.Token != nil && node.Token.Type == parser.TTypePseudoGroup {
+		return true
+	}
+	for _, child := range node.Children {
+		if hasPseudoGroups(child) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package external
+
+import (
+	"config"
+	"io/ioutil"
+	"os"
+	"parser"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const dumpOutput = `{
+	"datacenters": [{"name": "eu"}, {"name": "eu-west", "parent": "eu"}],
+	"groups": [
+		{"name": "web", "hosts": ["web1"]},
+		{"name": "front", "parents": ["web"]}
+	],
+	"hosts": [
+		{"fqdn": "web1", "datacenter": "eu-west"},
+		{"fqdn": "web2", "groups": ["front"], "attributes": {"role": "nginx"}},
+		{"fqdn": "db1", "datacenter": "eu"}
+	]
+}`
+
+// newProgram writes a shell script answering dump and resolve
+// commands and returns an exec backend running it
+func newProgram(t *testing.T, script string, resolve bool, timeout int) (*External, func()) {
+	dir, err := ioutil.TempDir("", "xc-external")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "inventory.sh")
+	err = ioutil.WriteFile(filename, []byte("#!/bin/sh\n"+script), 0755)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	e, err := New(&config.XcConfig{
+		ExecBackendCommand: filename + " --env test",
+		ExecBackendResolve: resolve,
+		ExecBackendTimeout: timeout,
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return e, func() { os.RemoveAll(dir) }
+}
+
+func checkHosts(t *testing.T, e *External, expr string, expected ...string) {
+	hosts, err := e.HostList([]rune(expr))
+	if err != nil {
+		t.Errorf("error resolving %s: %s", expr, err)
+		return
+	}
+	sort.Strings(hosts)
+	sort.Strings(expected)
+	if len(hosts) == 0 && len(expected) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("%s expected to resolve to %v, got %v", expr, expected, hosts)
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(&config.XcConfig{ExecBackendCommand: "  "})
+	if err == nil {
+		t.Error("exec backend without a command must fail")
+	}
+}
+
+func TestDump(t *testing.T) {
+	script := `[ "$1 $2 $3" = "--env test dump" ] || exit 1
+cat <<'EOF'
+` + dumpOutput + `
+EOF
+`
+	e, cleanup := newProgram(t, script, false, 5)
+	defer cleanup()
+
+	err := e.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHosts(t, e, "%web", "web1", "web2")
+	checkHosts(t, e, "%front", "web2")
+	checkHosts(t, e, "%web@eu", "web1")
+	checkHosts(t, e, "%web[role=nginx]", "web2")
+	checkHosts(t, e, "db1,web1,-web1", "db1")
+	if path := e.DatacenterPath("web1"); !reflect.DeepEqual(path, []string{"eu", "eu-west"}) {
+		t.Errorf("web1 datacenter path expected to be [eu eu-west], got %v", path)
+	}
+}
+
+func TestDumpErrors(t *testing.T) {
+	data := []struct {
+		script string
+		err    string
+	}{
+		{"echo '{\"hosts\": ['", "error parsing"},
+		{"echo 'not json'", "error parsing"},
+		{"echo 'no inventory' >&2; exit 3", "dump failed: exit status 3: no inventory"},
+		{"exit 2", "dump failed: exit status 2"},
+	}
+	for _, d := range data {
+		e, cleanup := newProgram(t, d.script, false, 5)
+		err := e.Load()
+		cleanup()
+		if err == nil || !strings.Contains(err.Error(), d.err) {
+			t.Errorf("script %q expected to fail with %q, got %v", d.script, d.err, err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	script := `case "$3" in
+dump) echo '{"hosts": [{"fqdn": "local1", "groups": ["web"]}]}' ;;
+resolve) printf '["%s.remote"]' "$4" ;;
+*) exit 1 ;;
+esac
+`
+	e, cleanup := newProgram(t, script, true, 5)
+	defer cleanup()
+	err := e.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// expressions are passed to the program as is
+	checkHosts(t, e, "%web", "%web.remote")
+	checkHosts(t, e, "%web@eu#tag", "%web@eu#tag.remote")
+
+	// pseudo groups are only known to xc so they're resolved locally
+	parser.SetPseudoGroup("_external_test", []string{"pseudo1"})
+	checkHosts(t, e, "%_external_test", "pseudo1")
+	checkHosts(t, e, "%_external_test,%web", "local1", "pseudo1")
+}
+
+func TestResolveErrors(t *testing.T) {
+	data := []struct {
+		script string
+		err    string
+	}{
+		{`echo '{"hosts": "web1"}'`, "error parsing"},
+		{`echo "can't resolve $4" >&2; exit 1`, "resolve failed: exit status 1: can't resolve %web"},
+	}
+	for _, d := range data {
+		e, cleanup := newProgram(t, d.script, true, 5)
+		_, err := e.HostList([]rune("%web"))
+		cleanup()
+		if err == nil || !strings.Contains(err.Error(), d.err) {
+			t.Errorf("script %q expected to fail with %q, got %v", d.script, d.err, err)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	e, cleanup := newProgram(t, "exec sleep 10", false, 1)
+	defer cleanup()
+
+	started := time.Now()
+	err := e.Load()
+	if err == nil || !strings.Contains(err.Error(), "dump timed out after 1s") {
+		t.Errorf("dump expected to time out, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("dump expected to be killed after 1s, took %s", elapsed)
+	}
+
+	e.resolve = true
+	_, err = e.HostList([]rune("%web"))
+	if err == nil || !strings.Contains(err.Error(), "resolve timed out after 1s") {
+		t.Errorf("resolve expected to time out, got %v", err)
+	}
+}
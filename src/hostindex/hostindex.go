@@ -0,0 +1,224 @@
+package hostindex
+
+import (
+	"sort"
+	"strings"
+)
+
+// Kind is the way a name matches a query, better kinds are lower
+type Kind int
+
+// Match kinds
+const (
+	KindPrefix Kind = iota
+	KindSubstring
+	KindFuzzy
+)
+
+// Match is a name found by Search
+type Match struct {
+	Name string
+	Kind Kind
+	// Score ranks matches of the same kind, lower is better
+	Score int
+}
+
+// Index is an immutable index of host names. Sorted names serve prefix
+// lookups, a trigram index narrows down substring lookups
+type Index struct {
+	names    []string
+	trigrams map[string][]int32
+}
+
+// New indexes the names given, duplicates are dropped
+func New(names []string) *Index {
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
+	idx := &Index{
+		names:    make([]string, 0, len(sorted)),
+		trigrams: make(map[string][]int32),
+	}
+	for _, name := range sorted {
+		if n := len(idx.names); n > 0 && idx.names[n-1] == name {
+			continue
+		}
+		idx.names = append(idx.names, name)
+	}
+
+	for i, name := range idx.names {
+		seen := make(map[string]bool)
+		for j := 0; j+3 <= len(name); j++ {
+			tg := name[j : j+3]
+			if seen[tg] {
+				continue
+			}
+			seen[tg] = true
+			// positions are added in ascending order
+			idx.trigrams[tg] = append(idx.trigrams[tg], int32(i))
+		}
+	}
+	return idx
+}
+
+// Len returns the number of names indexed
+func (idx *Index) Len() int {
+	return len(idx.names)
+}
+
+// Prefix returns the names starting with prefix in alphabetical order.
+// The slice returned must not be modified
+func (idx *Index) Prefix(prefix string) []string {
+	start := sort.SearchStrings(idx.names, prefix)
+	end := start
+	for end < len(idx.names) && strings.HasPrefix(idx.names[end], prefix) {
+		end++
+	}
+	return idx.names[start:end:end]
+}
+
+// Substring returns the names containing s somewhere after the
+// beginning in alphabetical order
+func (idx *Index) Substring(s string) []string {
+	res := make([]string, 0)
+	if len(s) < 3 {
+		for _, name := range idx.names {
+			if containsInside(name, s) {
+				res = append(res, name)
+			}
+		}
+		return res
+	}
+
+	for _, i := range idx.candidates(s) {
+		if name := idx.names[i]; containsInside(name, s) {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+func containsInside(name, s string) bool {
+	return len(name) > 0 && strings.Contains(name[1:], s)
+}
+
+// candidates returns positions of the names having all the trigrams of s
+func (idx *Index) candidates(s string) []int32 {
+	lists := make([][]int32, 0, len(s)-2)
+	for j := 0; j+3 <= len(s); j++ {
+		list, found := idx.trigrams[s[j:j+3]]
+		if !found {
+			return nil
+		}
+		lists = append(lists, list)
+	}
+	sort.Slice(lists, func(a, b int) bool { return len(lists[a]) < len(lists[b]) })
+
+	res := lists[0]
+	for _, list := range lists[1:] {
+		res = intersect(res, list)
+		if len(res) == 0 {
+			break
+		}
+	}
+	return res
+}
+
+func intersect(a, b []int32) []int32 {
+	res := make([]int32, 0)
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
+
+// fuzzyScore checks if name contains all the characters of s in the same
+// order and returns the number of characters skipped between them
+func fuzzyScore(name, s string) (int, bool) {
+	score := 0
+	pos := 0
+	for _, r := range s {
+		i := strings.IndexRune(name[pos:], r)
+		if i < 0 {
+			return 0, false
+		}
+		if pos > 0 {
+			score += i
+		}
+		pos += i + len(string(r))
+	}
+	return score, true
+}
+
+// Fuzzy returns the names containing all the characters of s in the
+// same order, the ones with closer characters first
+func (idx *Index) Fuzzy(s string) []Match {
+	res := make([]Match, 0)
+	for _, name := range idx.names {
+		if score, ok := fuzzyScore(name, s); ok {
+			res = append(res, Match{name, KindFuzzy, score})
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Score < res[j].Score })
+	return res
+}
+
+// Search returns the matches of the best kind found: prefix matches
+// if there are any, substring ones otherwise and fuzzy ones at last
+func (idx *Index) Search(query string) []Match {
+	res := make([]Match, 0)
+	for _, name := range idx.Prefix(query) {
+		res = append(res, Match{name, KindPrefix, len(name) - len(query)})
+	}
+	if len(res) > 0 || query == "" {
+		return res
+	}
+
+	for _, name := range idx.Substring(query) {
+		res = append(res, Match{name, KindSubstring, strings.Index(name, query)})
+	}
+	if len(res) > 0 {
+		sort.SliceStable(res, func(i, j int) bool { return res[i].Score < res[j].Score })
+		return res
+	}
+	return idx.Fuzzy(query)
+}
+
+// Merge combines matches of several indexes keeping the best kind
+// found only, the result is ordered by score and name
+func Merge(lists ...[]Match) []Match {
+	best := KindFuzzy
+	for _, list := range lists {
+		for _, m := range list {
+			if m.Kind < best {
+				best = m.Kind
+			}
+		}
+	}
+	res := make([]Match, 0)
+	seen := make(map[string]bool)
+	for _, list := range lists {
+		for _, m := range list {
+			if m.Kind == best && !seen[m.Name] {
+				seen[m.Name] = true
+				res = append(res, m)
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score < res[j].Score
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
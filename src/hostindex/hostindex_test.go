@@ -0,0 +1,83 @@
+package hostindex
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+var testNames = []string{
+	"web1.example.com",
+	"web2.example.com",
+	"db-web1.example.com",
+	"db1.example.com",
+	"cache1.example.com",
+	"web1.example.com",
+}
+
+func names(matches []Match) []string {
+	res := make([]string, len(matches))
+	for i, m := range matches {
+		res[i] = m.Name
+	}
+	return res
+}
+
+func TestSearch(t *testing.T) {
+	idx := New(testNames)
+	if idx.Len() != 5 {
+		t.Errorf("duplicates must be dropped, %d names indexed", idx.Len())
+	}
+
+	cases := []struct {
+		query    string
+		kind     Kind
+		expected []string
+	}{
+		{"web", KindPrefix, []string{"web1.example.com", "web2.example.com"}},
+		{"web1", KindPrefix, []string{"web1.example.com"}},
+		{"b1", KindSubstring, []string{"db1.example.com", "web1.example.com", "db-web1.example.com"}},
+		{"-web", KindSubstring, []string{"db-web1.example.com"}},
+		{"dbw1", KindFuzzy, []string{"db-web1.example.com"}},
+		{"cx", KindFuzzy, []string{"cache1.example.com"}},
+		{"zzz", KindFuzzy, []string{}},
+	}
+	for _, c := range cases {
+		matches := idx.Search(c.query)
+		if !reflect.DeepEqual(names(matches), c.expected) {
+			t.Errorf("%s expected to find %v, got %v", c.query, c.expected, names(matches))
+			continue
+		}
+		for _, m := range matches {
+			if m.Kind != c.kind {
+				t.Errorf("%s expected to give matches of kind %d, got %d", c.query, c.kind, m.Kind)
+			}
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := New([]string{"web1", "db-web2"})
+	b := New([]string{"app-web3", "db-web2"})
+
+	merged := Merge(a.Search("web"), b.Search("web"))
+	if !reflect.DeepEqual(names(merged), []string{"web1"}) {
+		t.Errorf("only prefix matches expected, got %v", names(merged))
+	}
+	merged = Merge(a.Search("-web"), b.Search("-web"))
+	if !reflect.DeepEqual(names(merged), []string{"db-web2", "app-web3"}) {
+		t.Errorf("substring matches ordered by position expected, got %v", names(merged))
+	}
+}
+
+func BenchmarkSubstring(b *testing.B) {
+	hosts := make([]string, 50000)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("host%05d.dc%d.example.com", i, i%7)
+	}
+	idx := New(hosts)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.Search("123.dc4")
+	}
+}
@@ -0,0 +1,454 @@
+package inventory
+
+import (
+	"hostindex"
+	"parser"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/viert/sekwence"
+)
+
+// Inventory is an indexed in-memory inventory backends which load the whole
+// host database at once (i.e. from a file or an external program) are built on.
+// It resolves expressions and completes names the same way conductor does
+type Inventory struct {
+	data        *Data
+	datacenters map[string]*Datacenter
+	workgroups  map[string]*WorkGroup
+	groups      map[string]*Group
+	hosts       map[string]*Host
+	hostnames   []string
+	index       *hostindex.Index
+
+	// hosts directly included in groups, in the order of data
+	groupHosts map[string][]*Host
+	// direct child groups
+	groupChildren map[string][]string
+	// groups belonging to workgroups
+	workgroupGroups map[string][]string
+	// group workgroups including ones inherited from parents
+	groupWorkGroup map[string]string
+	// host tags including ones inherited from groups
+	hostTags map[string][]string
+}
+
+// New indexes the inventory data. Groups, workgroups and datacenters which are
+// referenced but not described explicitly are created implicitly, so a minimal
+// data may consist of hosts only
+func New(data *Data) *Inventory {
+	inv := &Inventory{
+		data:            data,
+		datacenters:     make(map[string]*Datacenter),
+		workgroups:      make(map[string]*WorkGroup),
+		groups:          make(map[string]*Group),
+		hosts:           make(map[string]*Host),
+		hostnames:       make([]string, 0, len(data.Hosts)),
+		groupHosts:      make(map[string][]*Host),
+		groupChildren:   make(map[string][]string),
+		workgroupGroups: make(map[string][]string),
+		groupWorkGroup:  make(map[string]string),
+		hostTags:        make(map[string][]string),
+	}
+
+	for _, dc := range data.Datacenters {
+		inv.datacenters[dc.Name] = dc
+	}
+	for _, wg := range data.WorkGroups {
+		inv.workgroups[wg.Name] = wg
+	}
+	for _, group := range data.Groups {
+		inv.groups[group.Name] = group
+	}
+	listed := inv.mergeGroupHosts()
+
+	for _, host := range data.Hosts {
+		if _, found := inv.hosts[host.FQDN]; found {
+			continue
+		}
+		inv.hosts[host.FQDN] = host
+		inv.hostnames = append(inv.hostnames, host.FQDN)
+		for _, name := range host.Groups {
+			inv.ensureGroup(name)
+			if !listed[name+"/"+host.FQDN] {
+				inv.groupHosts[name] = append(inv.groupHosts[name], host)
+			}
+		}
+		if host.Datacenter != "" {
+			inv.ensureDatacenter(host.Datacenter)
+		}
+	}
+	sort.Strings(inv.hostnames)
+	inv.index = hostindex.New(inv.hostnames)
+
+	// data.Groups may grow while walking it because of implicit parents
+	for i := 0; i < len(data.Groups); i++ {
+		group := data.Groups[i]
+		for _, parent := range group.Parents {
+			inv.ensureGroup(parent)
+			inv.groupChildren[parent] = append(inv.groupChildren[parent], group.Name)
+		}
+	}
+
+	for _, group := range data.Groups {
+		// a group without a workgroup belongs to the one of its closest parent
+		wgName := ""
+		for _, name := range inv.groupAncestors([]string{group.Name}) {
+			if wgName = inv.groups[name].WorkGroup; wgName != "" {
+				break
+			}
+		}
+		if wgName == "" {
+			continue
+		}
+		if _, found := inv.workgroups[wgName]; !found {
+			wg := &WorkGroup{Name: wgName}
+			inv.workgroups[wgName] = wg
+			data.WorkGroups = append(data.WorkGroups, wg)
+		}
+		inv.groupWorkGroup[group.Name] = wgName
+		inv.workgroupGroups[wgName] = append(inv.workgroupGroups[wgName], group.Name)
+	}
+
+	for i := 0; i < len(data.Datacenters); i++ {
+		if parent := data.Datacenters[i].Parent; parent != "" {
+			inv.ensureDatacenter(parent)
+		}
+	}
+
+	for _, host := range data.Hosts {
+		tags := make([]string, 0)
+		tags = appendUnique(tags, host.Tags...)
+		for _, name := range inv.groupAncestors(host.Groups) {
+			tags = appendUnique(tags, inv.groups[name].Tags...)
+		}
+		inv.hostTags[host.FQDN] = tags
+	}
+	return inv
+}
+
+// mergeGroupHosts moves hosts listed in groups to hosts' groups lists,
+// hosts which are not described are created. Listed hosts keep the order
+// of the group's list, the returned set marks them as group/host pairs
+func (inv *Inventory) mergeGroupHosts() map[string]bool {
+	listed := make(map[string]bool)
+	hosts := make(map[string]*Host)
+	for _, host := range inv.data.Hosts {
+		if _, found := hosts[host.FQDN]; !found {
+			hosts[host.FQDN] = host
+		}
+	}
+	for _, group := range inv.data.Groups {
+		for _, fqdn := range group.Hosts {
+			host, found := hosts[fqdn]
+			if !found {
+				host = &Host{FQDN: fqdn}
+				hosts[fqdn] = host
+				inv.data.Hosts = append(inv.data.Hosts, host)
+			}
+			if listed[group.Name+"/"+fqdn] {
+				continue
+			}
+			listed[group.Name+"/"+fqdn] = true
+			host.Groups = appendUnique(host.Groups, group.Name)
+			inv.groupHosts[group.Name] = append(inv.groupHosts[group.Name], host)
+		}
+		group.Hosts = nil
+	}
+	return listed
+}
+
+func (inv *Inventory) ensureGroup(name string) {
+	if _, found := inv.groups[name]; !found {
+		group := &Group{Name: name}
+		inv.groups[name] = group
+		inv.data.Groups = append(inv.data.Groups, group)
+	}
+}
+
+func (inv *Inventory) ensureDatacenter(name string) {
+	if _, found := inv.datacenters[name]; !found {
+		dc := &Datacenter{Name: name}
+		inv.datacenters[name] = dc
+		inv.data.Datacenters = append(inv.data.Datacenters, dc)
+	}
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		if parser.SliceIndex(list, item) < 0 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// walkGroups returns the given groups along with all the groups reachable
+// with a given edges function, every group is returned once
+func walkGroups(names []string, edges func(string) []string) []string {
+	res := make([]string, 0)
+	seen := make(map[string]bool)
+	queue := append([]string{}, names...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		res = append(res, name)
+		queue = append(queue, edges(name)...)
+	}
+	return res
+}
+
+func (inv *Inventory) groupAncestors(names []string) []string {
+	return walkGroups(names, func(name string) []string {
+		if group, found := inv.groups[name]; found {
+			return group.Parents
+		}
+		return nil
+	})
+}
+
+func (inv *Inventory) groupDescendants(name string) []string {
+	return walkGroups([]string{name}, func(name string) []string {
+		return inv.groupChildren[name]
+	})
+}
+
+// Data returns the inventory data
+func (inv *Inventory) Data() *Data {
+	return inv.data
+}
+
+// Host returns a host by its name
+func (inv *Inventory) Host(fqdn string) (*Host, bool) {
+	host, found := inv.hosts[fqdn]
+	return host, found
+}
+
+// Group returns a group by its name
+func (inv *Inventory) Group(name string) (*Group, bool) {
+	group, found := inv.groups[name]
+	return group, found
+}
+
+// HostList resolves a host expression
+func (inv *Inventory) HostList(expr []rune) ([]string, error) {
+	ast, err := parser.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	return ast.Evaluate(inv.ResolveToken)
+}
+
+// ResolveToken returns the list of hosts a single expression token represents
+func (inv *Inventory) ResolveToken(token *parser.Token) ([]string, error) {
+	hostlist := make([]string, 0)
+
+	switch token.Type {
+	case parser.TTypeHostRegexp:
+		for _, host := range inv.hostnames {
+			if token.RegexpFilter.MatchString(host) {
+				hostlist = append(hostlist, host)
+			}
+		}
+
+	case parser.TTypeHost:
+		hosts, err := sekwence.ExpandPattern(token.Value)
+		if err != nil {
+			hosts = []string{token.Value}
+		}
+		for _, host := range hosts {
+			if !inv.matchHost(host, token) {
+				continue
+			}
+			hostlist = append(hostlist, host)
+		}
+
+	case parser.TTypeGroup:
+		if _, found := inv.groups[token.Value]; found {
+			for _, name := range inv.groupDescendants(token.Value) {
+				for _, host := range inv.groupHosts[name] {
+					if inv.matchHost(host.FQDN, token) && matchRegexp(host.FQDN, token.RegexpFilter) {
+						hostlist = append(hostlist, host.FQDN)
+					}
+				}
+			}
+		}
+
+	case parser.TTypePseudoGroup:
+		for _, host := range parser.PseudoGroup(token.Value) {
+			if !inv.matchHost(host, token) {
+				continue
+			}
+			if matchRegexp(host, token.RegexpFilter) {
+				hostlist = append(hostlist, host)
+			}
+		}
+
+	case parser.TTypeWorkGroup:
+		workgroups := make([]string, 0)
+		if token.Value == "" {
+			for name := range inv.workgroups {
+				workgroups = append(workgroups, name)
+			}
+			sort.Strings(workgroups)
+		} else if _, found := inv.workgroups[token.Value]; found {
+			workgroups = append(workgroups, token.Value)
+		}
+
+		for _, wg := range workgroups {
+			for _, name := range inv.workgroupGroups[wg] {
+				for _, host := range inv.groupHosts[name] {
+					if inv.matchHost(host.FQDN, token) && matchRegexp(host.FQDN, token.RegexpFilter) {
+						hostlist = append(hostlist, host.FQDN)
+					}
+				}
+			}
+		}
+	}
+
+	if len(token.AttrFilters) > 0 {
+		filtered := make([]string, 0)
+		for _, host := range hostlist {
+			if token.MatchAttrs(inv.HostAttributes(host)) {
+				filtered = append(filtered, host)
+			}
+		}
+		hostlist = filtered
+	}
+	return hostlist, nil
+}
+
+func matchRegexp(host string, re *regexp.Regexp) bool {
+	return re == nil || re.MatchString(host)
+}
+
+// matchHost checks the token's datacenter and tags filters
+func (inv *Inventory) matchHost(fqdn string, token *parser.Token) bool {
+	host, found := inv.hosts[fqdn]
+	if !found {
+		return token.DatacenterFilter == "" && len(token.TagsFilter) == 0
+	}
+
+	if token.DatacenterFilter != "" {
+		if token.DatacenterExact {
+			if host.Datacenter != token.DatacenterFilter {
+				return false
+			}
+		} else if parser.SliceIndex(inv.DatacenterPath(fqdn), token.DatacenterFilter) < 0 {
+			return false
+		}
+	}
+
+	for _, tag := range token.TagsFilter {
+		if parser.SliceIndex(inv.hostTags[fqdn], tag) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// DatacenterPath returns the names of the host's datacenter
+// and all its ancestors, starting from the root one
+func (inv *Inventory) DatacenterPath(fqdn string) []string {
+	host, found := inv.hosts[fqdn]
+	if !found || host.Datacenter == "" {
+		return nil
+	}
+	path := make([]string, 0)
+	name := host.Datacenter
+	for name != "" && parser.SliceIndex(path, name) < 0 {
+		path = append([]string{name}, path...)
+		dc, found := inv.datacenters[name]
+		if !found {
+			break
+		}
+		name = dc.Parent
+	}
+	return path
+}
+
+// HostAttributes returns the attributes of a host attribute
+// filters are matched against
+func (inv *Inventory) HostAttributes(fqdn string) map[string][]string {
+	attrs := map[string][]string{
+		"fqdn": {fqdn},
+	}
+	host, found := inv.hosts[fqdn]
+	if !found {
+		return attrs
+	}
+
+	for key, value := range host.Attributes {
+		attrs[key] = []string{value}
+	}
+	attrs["alias"] = host.Aliases
+	attrs["tag"] = inv.hostTags[fqdn]
+	attrs["group"] = host.Groups
+	workgroups := make([]string, 0)
+	for _, name := range host.Groups {
+		if wg, found := inv.groupWorkGroup[name]; found {
+			workgroups = appendUnique(workgroups, wg)
+		}
+	}
+	attrs["workgroup"] = workgroups
+	attrs["dc"] = inv.DatacenterPath(fqdn)
+	return attrs
+}
+
+func completeNames(names []string, prefix string) []string {
+	res := make([]string, 0)
+	for _, name := range names {
+		if prefix == "" || strings.HasPrefix(name, prefix) {
+			res = append(res, name[len(prefix):])
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// CompleteHost returns the completion suffixes of hostnames
+func (inv *Inventory) CompleteHost(line string) []string {
+	hostnames := inv.index.Prefix(line)
+	res := make([]string, len(hostnames))
+	for i, hostname := range hostnames {
+		res[i] = hostname[len(line):]
+	}
+	return res
+}
+
+// SearchHosts looks up hostnames by prefix, substring or fuzzy match
+func (inv *Inventory) SearchHosts(query string) []hostindex.Match {
+	return inv.index.Search(query)
+}
+
+// CompleteGroup returns the completion suffixes of group names
+func (inv *Inventory) CompleteGroup(line string) []string {
+	names := make([]string, 0, len(inv.groups))
+	for name := range inv.groups {
+		names = append(names, name)
+	}
+	return completeNames(names, strings.TrimPrefix(line, "%"))
+}
+
+// CompleteWorkGroup returns the completion suffixes of workgroup names
+func (inv *Inventory) CompleteWorkGroup(line string) []string {
+	names := make([]string, 0, len(inv.workgroups))
+	for name := range inv.workgroups {
+		names = append(names, name)
+	}
+	return completeNames(names, strings.TrimPrefix(line, "*"))
+}
+
+// CompleteDatacenter returns the completion suffixes of datacenter names
+func (inv *Inventory) CompleteDatacenter(line string) []string {
+	names := make([]string, 0, len(inv.datacenters))
+	for name := range inv.datacenters {
+		names = append(names, name)
+	}
+	return completeNames(names, strings.TrimPrefix(strings.TrimPrefix(line, "@"), "="))
+}
@@ -0,0 +1,46 @@
+package inventory
+
+// Datacenter is a location hosts are placed in. Datacenters
+// form a tree via parent references
+type Datacenter struct {
+	Name        string `json:"name"`
+	Parent      string `json:"parent,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// WorkGroup is a top-level unit groups belong to
+type WorkGroup struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// Group is a named set of hosts. A group may have parent groups,
+// hosts of a group are also hosts of all its parents. Hosts may be
+// listed right in the group as well as in hosts' own groups lists
+type Group struct {
+	Name        string   `json:"name"`
+	Parents     []string `json:"parents,omitempty"`
+	Hosts       []string `json:"hosts,omitempty"`
+	WorkGroup   string   `json:"workgroup,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// Host is a single inventory host. Attributes are arbitrary
+// key/value pairs exposed to attribute filters of expressions
+type Host struct {
+	FQDN       string            `json:"fqdn"`
+	Aliases    []string          `json:"aliases,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	Groups     []string          `json:"groups,omitempty"`
+	Datacenter string            `json:"datacenter,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Data is a plain serializable inventory
+type Data struct {
+	Datacenters []*Datacenter `json:"datacenters"`
+	WorkGroups  []*WorkGroup  `json:"workgroups"`
+	Groups      []*Group      `json:"groups"`
+	Hosts       []*Host       `json:"hosts"`
+}
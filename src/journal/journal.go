@@ -0,0 +1,285 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Entry is a command recorded in the journal
+type Entry struct {
+	ID   int       `json:"id"`
+	Time time.Time `json:"time"`
+	// Command is the kind of the command: exec, runscript or distribute,
+	// Args is everything following its host expression
+	Command string   `json:"command"`
+	Args    string   `json:"args"`
+	Expr    string   `json:"expr"`
+	Hosts   []string `json:"hosts"`
+	User    string   `json:"user"`
+	Raise   string   `json:"raise"`
+	Mode    string   `json:"mode"`
+	// Codes is a map host -> exit code, hosts skipped have no codes
+	Codes    map[string]int `json:"codes"`
+	Duration float64        `json:"duration"`
+	// Output is the output captured per host, it's kept in
+	// a separate file so listing entries doesn't read outputs
+	Output map[string]string `json:"-"`
+}
+
+// outputRecord is a line of the outputs file. The time tells apart
+// records of entries which failed to be written and had their ids reused
+type outputRecord struct {
+	ID     int               `json:"id"`
+	Time   time.Time         `json:"time"`
+	Output map[string]string `json:"output"`
+}
+
+// Journal is an append-only file of JSON entries, one per line. Several
+// xc instances may write the same journal, entries are appended under
+// a file lock and get their ids in the order they're written. Outputs
+// of entries are appended the same way to the file named <journal>.output
+type Journal struct {
+	filename string
+	outputs  string
+	// lastID is the id of the last entry found up to offset
+	lastID int
+	offset int64
+}
+
+// Open opens the journal creating the file if it doesn't exist
+func Open(filename string) (*Journal, error) {
+	j := &Journal{filename: filename, outputs: filename + ".output"}
+	f, err := j.open(syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	defer j.close(f)
+	return j, j.sync(f)
+}
+
+// open opens the journal file locked, the outputs file is
+// guarded by the lock of the journal file as well
+func (j *Journal) open(lock int) (*os.File, error) {
+	f, err := openFile(j.filename)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), lock)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func openFile(filename string) (*os.File, error) {
+	return os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+}
+
+func (j *Journal) close(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	f.Close()
+}
+
+// sync reads the ids of entries written since the last sync
+func (j *Journal) sync(f *os.File) error {
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if st.Size() < j.offset {
+		// the journal has been truncated
+		j.lastID, j.offset = 0, 0
+	}
+	_, err = f.Seek(j.offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF {
+			// an incomplete line is left to the next sync
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		j.offset += int64(len(line))
+		var header struct {
+			ID int `json:"id"`
+		}
+		if json.Unmarshal(line, &header) == nil && header.ID > j.lastID {
+			j.lastID = header.ID
+		}
+	}
+}
+
+// Add appends the entry to the journal giving it the next id
+func (j *Journal) Add(e *Entry) error {
+	f, err := j.open(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer j.close(f)
+
+	err = j.sync(f)
+	if err != nil {
+		return err
+	}
+	e.ID = j.lastID + 1
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	// the output goes first so an entry listed always has it
+	if len(e.Output) > 0 {
+		out, err := json.Marshal(&outputRecord{e.ID, e.Time, e.Output})
+		if err != nil {
+			return err
+		}
+		of, err := openFile(j.outputs)
+		if err != nil {
+			return err
+		}
+		_, err = appendLine(of, out)
+		of.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	end, err := appendLine(f, data)
+	if err != nil {
+		return err
+	}
+	j.lastID = e.ID
+	j.offset = end
+	return nil
+}
+
+// appendLine appends a line to the file and returns the new size of it.
+// A line left incomplete by a torn write is terminated first so that
+// the line appended stays intact
+func appendLine(f *os.File, line []byte) (int64, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	data := make([]byte, 0, len(line)+2)
+	if st.Size() > 0 {
+		last := make([]byte, 1)
+		_, err = f.ReadAt(last, st.Size()-1)
+		if err != nil {
+			return 0, err
+		}
+		if last[0] != '\n' {
+			data = append(data, '\n')
+		}
+	}
+	data = append(append(data, line...), '\n')
+	_, err = f.Write(data)
+	if err != nil {
+		return 0, err
+	}
+	return st.Size() + int64(len(data)), nil
+}
+
+// read calls fn for every entry of the journal until it returns false
+func (j *Journal) read(fn func(line []byte) (bool, error)) error {
+	f, err := j.open(syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+	defer j.close(f)
+	return readLines(f, fn)
+}
+
+// readLines calls fn for every line of the file until it returns false
+func readLines(f *os.File, fn func(line []byte) (bool, error)) error {
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		next, err := fn(line)
+		if err != nil || !next {
+			return err
+		}
+	}
+}
+
+// List returns the entries of the journal in the order they were
+// added. Outputs are not loaded, use Get to get them
+func (j *Journal) List() ([]*Entry, error) {
+	entries := make([]*Entry, 0)
+	err := j.read(func(line []byte) (bool, error) {
+		e := new(Entry)
+		if err := json.Unmarshal(line, e); err != nil {
+			// damaged entries are skipped
+			return true, nil
+		}
+		entries = append(entries, e)
+		return true, nil
+	})
+	return entries, err
+}
+
+// Get returns the entry with the id given along with its output
+func (j *Journal) Get(id int) (*Entry, error) {
+	f, err := j.open(syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	defer j.close(f)
+
+	var found *Entry
+	err = readLines(f, func(line []byte) (bool, error) {
+		var header struct {
+			ID int `json:"id"`
+		}
+		if json.Unmarshal(line, &header) != nil || header.ID != id {
+			return true, nil
+		}
+		found = new(Entry)
+		return false, json.Unmarshal(line, found)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("entry %d not found", id)
+	}
+
+	of, err := os.Open(j.outputs)
+	if os.IsNotExist(err) {
+		return found, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer of.Close()
+	err = readLines(of, func(line []byte) (bool, error) {
+		rec := new(outputRecord)
+		if json.Unmarshal(line, rec) != nil || rec.ID != id || !rec.Time.Equal(found.Time) {
+			return true, nil
+		}
+		found.Output = rec.Output
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
@@ -0,0 +1,137 @@
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xc-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := path.Join(dir, "journal")
+
+	j1, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another xc instance writing the same journal
+	j2, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []*Entry{
+		{Command: "exec", Args: "uptime", Expr: "%web", Hosts: []string{"web1", "web2"},
+			Codes: map[string]int{"web1": 0, "web2": 1}, Output: map[string]string{"web1": "up 1 day\n"}},
+		{Command: "runscript", Args: "deploy.sh", Expr: "web1", Hosts: []string{"web1"}},
+		{Command: "distribute", Args: "app.tar", Expr: "web2", Hosts: []string{"web2"}},
+	}
+	for i, j := range []*Journal{j1, j2, j1} {
+		if err := j.Add(entries[i]); err != nil {
+			t.Fatal(err)
+		}
+		if entries[i].ID != i+1 {
+			t.Errorf("entry %d got id %d", i+1, entries[i].ID)
+		}
+	}
+
+	list, err := j2.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("3 entries expected, got %d", len(list))
+	}
+	if list[0].Output != nil {
+		t.Errorf("List must not load outputs")
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "up 1 day") {
+		t.Errorf("outputs must be kept out of the journal file")
+	}
+
+	e, err := j2.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(e.Output, entries[0].Output) || !reflect.DeepEqual(e.Codes, entries[0].Codes) {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if _, err := j2.Get(4); err == nil {
+		t.Errorf("getting a missing entry must fail")
+	}
+
+	j3, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = &Entry{Command: "exec"}
+	if err := j3.Add(e); err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != 4 {
+		t.Errorf("ids must continue after reopening, got %d", e.ID)
+	}
+}
+
+func TestJournalTornWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xc-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := path.Join(dir, "journal")
+
+	j, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Add(&Entry{Command: "exec", Output: map[string]string{"web1": "first\n"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// an instance killed in the middle of writing
+	for _, name := range []string{filename, filename + ".output"} {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(`{"id": 2, "command": "ex`)
+		f.Close()
+	}
+
+	e := &Entry{Command: "runscript", Output: map[string]string{"web1": "second\n"}}
+	if err := j.Add(e); err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != 2 {
+		t.Errorf("the torn entry must not take an id, got %d", e.ID)
+	}
+
+	list, err := j.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[1].Command != "runscript" {
+		t.Fatalf("the entry written after the torn one expected to be intact, got %+v", list)
+	}
+	for id, output := range map[int]string{1: "first\n", 2: "second\n"} {
+		e, err := j.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Output["web1"] != output {
+			t.Errorf("entry %d expected to have output %q, got %q", id, output, e.Output["web1"])
+		}
+	}
+}
@@ -1,27 +1,40 @@
 package localfile
 
 import (
+	"backend"
 	"config"
 	"encoding/json"
+	"inventory"
 	"io/ioutil"
 	"os"
 	"parser"
 	"sort"
-	"strings"
-	"regexp"
 
 	"github.com/go-ini/ini"
 )
 
 type Hosts []string
 type LocalFileData map[string]Hosts
+
+// LocalFile is a backend reading the inventory from a local file. The
+// localini format and the plain localjson format are maps of groups to
+// lists of hosts. The extended localjson format is the one of inventory.Data,
+// it describes workgroups, datacenters, tags and nested groups:
+//
+//	{
+//	  "datacenters": [{"name": "fra1", "parent": "europe"}],
+//	  "workgroups": [{"name": "site"}],
+//	  "groups": [{"name": "web", "workgroup": "site", "tags": ["nginx"], "hosts": ["web1"]}],
+//	  "hosts": [{"fqdn": "web2", "groups": ["web"], "datacenter": "fra1", "tags": ["canary"]}]
+//	}
 type LocalFile struct {
+	*inventory.Inventory
 	backend string
 	path    string
-	data    *LocalFileData
 }
 
 func (f *LocalFile) Load() error {
+	var fData LocalFileData
 	switch f.backend {
 	case "localini":
 		cfg, err := ini.LoadSources(ini.LoadOptions{
@@ -31,7 +44,7 @@ func (f *LocalFile) Load() error {
 			return err
 		}
 
-		fData := LocalFileData{}
+		fData = LocalFileData{}
 		for i, group := range cfg.Sections() {
 			// no need DEFAULT section from github.com/go-ini/ini
 			if i == 0 {
@@ -39,7 +52,6 @@ func (f *LocalFile) Load() error {
 			}
 			fData[group.Name()] = group.KeyStrings()
 		}
-		f.data = &fData
 	case "localjson":
 		jsonFile, err := os.Open(f.path)
 		defer jsonFile.Close()
@@ -52,107 +64,69 @@ func (f *LocalFile) Load() error {
 			return err
 		}
 
-		err = json.Unmarshal(bData, &f.data)
+		// plain map of groups goes first for backward compatibility
+		err = json.Unmarshal(bData, &fData)
 		if err != nil {
-			return err
+			data := new(inventory.Data)
+			err = json.Unmarshal(bData, data)
+			if err != nil {
+				return err
+			}
+			f.Inventory = inventory.New(data)
+			return nil
 		}
 	}
+	f.Inventory = inventory.New(fData.inventoryData())
 	return nil
 }
 
-func (f *LocalFile) HostList(x []rune) ([]string, error) {
-	tokens, err := parser.ParseExpression(x)
-	if err != nil {
-		return nil, err
-	}
-	hostlist := make([]string, 0)
-	for _, token := range tokens {
-		group := *f.data
-		hosts := group[token.Value]
-		switch token.Type {
-		case parser.TTypeHostRegexp:
-			for _, host := range f.MatchHost(token.RegexpFilter) {
-				parser.MaybeAddHost(&hostlist, host, token.Exclude)
-			}
-		case parser.TTypeHost:
-			if len(hosts) == 0 {
-				hosts = []string{token.Value}
-			}
-			for _, host := range hosts {
-				parser.MaybeAddHost(&hostlist, host, token.Exclude)
-			}
-		case parser.TTypeGroup:
-			for _, host := range hosts {
-				parser.MaybeAddHost(&hostlist, host, token.Exclude)
-			}
-		default:
-			continue
-		}
+// inventoryData converts a plain map of groups to the inventory model
+func (d LocalFileData) inventoryData() *inventory.Data {
+	data := new(inventory.Data)
+	names := make([]string, 0, len(d))
+	for name := range d {
+		names = append(names, name)
 	}
-	return hostlist, nil
-}
-
-func (f *LocalFile) Reload() error {
-	return f.Load()
-}
-
-func (f *LocalFile) MatchHost(pattern *regexp.Regexp) []string {
-	res := make([]string, 0)
-	for _, group := range *f.data {
-		for _, hostname := range group {
-			if pattern.MatchString(hostname) {
-				res = append(res, hostname)
-			}
-		}
+	sort.Strings(names)
+	for _, name := range names {
+		data.Groups = append(data.Groups, &inventory.Group{Name: name, Hosts: d[name]})
 	}
-	sort.Strings(res)
-	return res
-
+	return data
 }
 
-func (f *LocalFile) CompleteHost(line string) []string {
-	res := make([]string, 0)
-	keys := make(map[string]bool)
-	for _, group := range *f.data {
-		for _, hostname := range group {
-			if line == "" || strings.HasPrefix(hostname, line) {
-				host := hostname[len(line):]
-				if _, ok := keys[host]; !ok {
-					keys[host] = true
-					res = append(res, host)
-				}
-			}
-		}
+func (f *LocalFile) HostList(x []rune) ([]string, error) {
+	ast, err := parser.Parse(x)
+	if err != nil {
+		return nil, err
 	}
-	sort.Strings(res)
-	return res
+	return ast.Evaluate(f.ResolveToken)
 }
 
-func (f *LocalFile) CompleteGroup(line string) []string {
-	expr := line
-	if strings.HasPrefix(expr, "%") {
-		expr = expr[1:]
-	}
-	res := make([]string, 0)
-	for group := range *f.data {
-		if expr == "" || strings.HasPrefix(group, expr) {
-			res = append(res, group[len(expr):])
+// ResolveToken returns the list of hosts a single expression token represents
+func (f *LocalFile) ResolveToken(token *parser.Token) ([]string, error) {
+	if token.Type == parser.TTypeHost {
+		// a host token named after a group has always meant the group here
+		if _, found := f.Group(token.Value); found {
+			groupToken := *token
+			groupToken.Type = parser.TTypeGroup
+			return f.Inventory.ResolveToken(&groupToken)
 		}
 	}
-	sort.Strings(res)
-	return res
+	return f.Inventory.ResolveToken(token)
 }
 
-func (f *LocalFile) CompleteWorkGroup(line string) []string {
-	// not realized in json struct now
-	return nil
+func (f *LocalFile) Reload() error {
+	return f.Load()
 }
 
-func (f *LocalFile) CompleteDatacenter(line string) []string {
-	// not realized in json struct now
-	return nil
+func init() {
+	factory := func(xc *config.XcConfig) (backend.Backend, error) {
+		return NewFromFile(xc), nil
+	}
+	backend.Register("localjson", factory)
+	backend.Register("localini", factory)
 }
 
 func NewFromFile(config *config.XcConfig) *LocalFile {
-	return &LocalFile{config.BackendType, config.LocalFile, &LocalFileData{}}
+	return &LocalFile{inventory.New(&inventory.Data{}), config.BackendType, config.LocalFile}
 }
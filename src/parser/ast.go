@@ -0,0 +1,342 @@
+package parser
+
+import (
+	"fmt"
+)
+
+// NodeType is a enum of expression tree node types
+type NodeType int
+
+// Enum of node types
+const (
+	NodeToken NodeType = iota
+	NodeUnion
+	NodeIntersection
+)
+
+// Node is a node of a parsed host expression tree.
+//
+// Union children are resolved left to right, every child either adds its hosts
+// to the list or, if the child is excluded, removes them. This is exactly how
+// a plain comma-separated expression has always been resolved.
+//
+// Intersection keeps only hosts of the first child which are present in the
+// rest of children. Excluded children are subtracted instead.
+type Node struct {
+	Type     NodeType
+	Token    *Token
+	Children []*Node
+	Exclude  bool
+}
+
+// TokenResolver returns the list of hosts a single token represents.
+// Resolvers should ignore token's Exclude flag, it's applied by the tree itself
+type TokenResolver func(*Token) ([]string, error)
+
+type itemType int
+
+const (
+	itemAtom itemType = iota
+	itemComma
+	itemAmp
+	itemLParen
+	itemRParen
+	itemMinus
+)
+
+type item struct {
+	typ   itemType
+	value []rune
+	pos   int
+}
+
+type treeParser struct {
+	items []*item
+	pos   int
+}
+
+var (
+	operators = map[rune]itemType{
+		',': itemComma,
+		'&': itemAmp,
+		'(': itemLParen,
+		')': itemRParen,
+	}
+)
+
+// lex splits the expression into atoms (which are single tokens parsed by
+// ParseExpression) and operators. Operator symbols inside regexps, host
+// brace patterns and attribute filters are treated as a part of atom
+func lex(expr []rune) []*item {
+	items := make([]*item, 0)
+	atom := make([]rune, 0)
+	atomPos := 0
+	inRegexp := false
+	inBraces := false
+	bracketDepth := 0
+
+	flush := func() {
+		if len(atom) > 0 {
+			items = append(items, &item{itemAtom, atom, atomPos})
+			atom = make([]rune, 0)
+		}
+	}
+
+	for i := 0; i < len(expr); i++ {
+		sym := expr[i]
+		if len(atom) == 0 {
+			atomPos = i
+		}
+
+		if inRegexp {
+			atom = append(atom, sym)
+			if sym == '\\' && i < len(expr)-1 && expr[i+1] == '/' {
+				// screened slash
+				atom = append(atom, '/')
+				i++
+				continue
+			}
+			if sym == '/' {
+				inRegexp = false
+			}
+			continue
+		}
+
+		if bracketDepth > 0 {
+			atom = append(atom, sym)
+			switch sym {
+			case '\\':
+				if i < len(expr)-1 {
+					atom = append(atom, expr[i+1])
+					i++
+				}
+			case '[':
+				bracketDepth++
+			case ']':
+				bracketDepth--
+			}
+			continue
+		}
+
+		if inBraces {
+			atom = append(atom, sym)
+			if sym == '}' {
+				inBraces = false
+			}
+			continue
+		}
+
+		if it, found := operators[sym]; found {
+			if it == itemLParen && string(atom) == "-" {
+				// exclusion of a parenthesised sub-expression
+				items = append(items, &item{itemMinus, nil, atomPos})
+				atom = make([]rune, 0)
+			}
+			flush()
+			items = append(items, &item{it, nil, i})
+			continue
+		}
+
+		switch sym {
+		case '/':
+			inRegexp = true
+		case '{':
+			inBraces = true
+		case '[':
+			bracketDepth++
+		}
+		atom = append(atom, sym)
+	}
+	flush()
+	return items
+}
+
+// Parse parses a host expression into a tree. Tokens are separated by
+// commas (union, with "-" prefix meaning exclusion) and "&" (intersection)
+// which binds tighter than comma. Parentheses group sub-expressions
+func Parse(expr []rune) (*Node, error) {
+	tp := &treeParser{lex(expr), 0}
+	node, err := tp.parseUnion()
+	if err != nil {
+		return nil, err
+	}
+	if it := tp.peek(); it != nil {
+		return nil, fmt.Errorf("unexpected %s at position %d", tp.describe(it), it.pos)
+	}
+	return node, nil
+}
+
+func (tp *treeParser) peek() *item {
+	if tp.pos >= len(tp.items) {
+		return nil
+	}
+	return tp.items[tp.pos]
+}
+
+func (tp *treeParser) next() *item {
+	it := tp.peek()
+	if it != nil {
+		tp.pos++
+	}
+	return it
+}
+
+func (tp *treeParser) describe(it *item) string {
+	switch it.typ {
+	case itemComma:
+		return "','"
+	case itemAmp:
+		return "'&'"
+	case itemLParen:
+		return "'('"
+	case itemRParen:
+		return "')'"
+	case itemMinus:
+		return "'-'"
+	default:
+		return fmt.Sprintf("\"%s\"", string(it.value))
+	}
+}
+
+func (tp *treeParser) parseUnion() (*Node, error) {
+	node := &Node{Type: NodeUnion, Children: make([]*Node, 0)}
+	for {
+		it := tp.peek()
+		if it == nil || it.typ == itemRParen {
+			// a trailing comma has always been allowed
+			return node, nil
+		}
+
+		term, err := tp.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, term)
+
+		it = tp.peek()
+		if it == nil || it.typ == itemRParen {
+			return node, nil
+		}
+		if it.typ != itemComma {
+			return nil, fmt.Errorf("unexpected %s at position %d, expected ','", tp.describe(it), it.pos)
+		}
+		tp.next()
+	}
+}
+
+func (tp *treeParser) parseTerm() (*Node, error) {
+	first, err := tp.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if it := tp.peek(); it == nil || it.typ != itemAmp {
+		return first, nil
+	}
+
+	// exclusion of the first operand means exclusion of the whole intersection
+	node := &Node{Type: NodeIntersection, Children: []*Node{first}, Exclude: first.Exclude}
+	first.Exclude = false
+
+	for {
+		it := tp.peek()
+		if it == nil || it.typ != itemAmp {
+			return node, nil
+		}
+		tp.next()
+		operand, err := tp.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, operand)
+	}
+}
+
+func (tp *treeParser) parseOperand() (*Node, error) {
+	it := tp.next()
+	if it == nil {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	switch it.typ {
+	case itemMinus:
+		operand, err := tp.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		operand.Exclude = true
+		return operand, nil
+	case itemLParen:
+		node, err := tp.parseUnion()
+		if err != nil {
+			return nil, err
+		}
+		closing := tp.next()
+		if closing == nil || closing.typ != itemRParen {
+			return nil, fmt.Errorf("unclosed parenthesis at position %d", it.pos)
+		}
+		if len(node.Children) == 0 {
+			return nil, fmt.Errorf("empty parentheses at position %d", it.pos)
+		}
+		return node, nil
+	case itemAtom:
+		tokens, err := ParseExpression(it.value)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing \"%s\" at position %d: %s", string(it.value), it.pos, err)
+		}
+		if len(tokens) != 1 {
+			return nil, fmt.Errorf("invalid token \"%s\" at position %d", string(it.value), it.pos)
+		}
+		return &Node{Type: NodeToken, Token: tokens[0], Exclude: tokens[0].Exclude}, nil
+	default:
+		return nil, fmt.Errorf("unexpected %s at position %d", tp.describe(it), it.pos)
+	}
+}
+
+// Evaluate resolves the tree into a list of hosts using
+// a given resolver for every single token
+func (n *Node) Evaluate(resolve TokenResolver) ([]string, error) {
+	switch n.Type {
+	case NodeToken:
+		return resolve(n.Token)
+
+	case NodeUnion:
+		hostlist := make([]string, 0)
+		for _, child := range n.Children {
+			hosts, err := child.Evaluate(resolve)
+			if err != nil {
+				return nil, err
+			}
+			for _, host := range hosts {
+				MaybeAddHost(&hostlist, host, child.Exclude)
+			}
+		}
+		return hostlist, nil
+
+	case NodeIntersection:
+		hostlist, err := n.Children[0].Evaluate(resolve)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range n.Children[1:] {
+			hosts, err := child.Evaluate(resolve)
+			if err != nil {
+				return nil, err
+			}
+			set := make(map[string]bool)
+			for _, host := range hosts {
+				set[host] = true
+			}
+			filtered := make([]string, 0)
+			for _, host := range hostlist {
+				// excluded operand is subtracted, others are intersected
+				if set[host] != child.Exclude {
+					filtered = append(filtered, host)
+				}
+			}
+			hostlist = filtered
+		}
+		return hostlist, nil
+	}
+	return nil, fmt.Errorf("unknown node type %d", n.Type)
+}
@@ -0,0 +1,197 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// AttrOp is a enum of attribute filter operators
+type AttrOp int
+
+// Enum of attribute filter operators
+const (
+	AttrOpEqual AttrOp = iota
+	AttrOpNotEqual
+	AttrOpMatch
+	AttrOpNotMatch
+)
+
+// AttrFilter is a single key/value predicate of a token attribute filter
+// like %group[dc=~msk.*,alias=foo]
+type AttrFilter struct {
+	Key    string
+	Op     AttrOp
+	Value  string
+	Regexp *regexp.Regexp
+}
+
+var (
+	attrKeySymbols = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_.-"
+	attrOperators  = []struct {
+		op   AttrOp
+		text string
+	}{
+		// longer operators go first so "=~" isn't taken for "="
+		{AttrOpNotEqual, "!="},
+		{AttrOpNotMatch, "!~"},
+		{AttrOpMatch, "=~"},
+		{AttrOpEqual, "="},
+	}
+)
+
+func (op AttrOp) String() string {
+	for _, ao := range attrOperators {
+		if ao.op == op {
+			return ao.text
+		}
+	}
+	return "?"
+}
+
+func (f *AttrFilter) String() string {
+	value := f.Value
+	if f.Op == AttrOpEqual || f.Op == AttrOpNotEqual {
+		value = escapeAttrValue(value)
+	}
+	return f.Key + f.Op.String() + value
+}
+
+// unescapeAttrValue drops backslashes screening symbols of a plain value
+func unescapeAttrValue(s string) string {
+	if !strings.ContainsRune(s, '\\') {
+		return s
+	}
+	res := make([]rune, 0, len(s))
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == '\\' && i < len(runes)-1 {
+			i++
+		}
+		res = append(res, runes[i])
+	}
+	return string(res)
+}
+
+// escapeAttrValue screens the symbols which have a meaning in a filter block
+func escapeAttrValue(s string) string {
+	res := ""
+	for _, sym := range s {
+		if strings.ContainsRune("\\,[]", sym) {
+			res += "\\"
+		}
+		res += string(sym)
+	}
+	return res
+}
+
+// Match checks the filter against all the values an attribute has.
+// Positive operators require at least one of the values to match, negative
+// ones require none of them to match, so a missing attribute always
+// satisfies a negative filter
+func (f *AttrFilter) Match(values []string) bool {
+	for _, value := range values {
+		var matched bool
+		switch f.Op {
+		case AttrOpEqual, AttrOpNotEqual:
+			matched = value == f.Value
+		case AttrOpMatch, AttrOpNotMatch:
+			matched = f.Regexp.MatchString(value)
+		}
+		if matched {
+			return f.Op == AttrOpEqual || f.Op == AttrOpMatch
+		}
+	}
+	return f.Op == AttrOpNotEqual || f.Op == AttrOpNotMatch
+}
+
+// MatchAttrs checks if a host with given attributes satisfies
+// all the attribute filters of the token
+func (t *Token) MatchAttrs(attrs map[string][]string) bool {
+	for _, f := range t.AttrFilters {
+		if !f.Match(attrs[f.Key]) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseAttrFilter(s string, pos int) (*AttrFilter, error) {
+	i := 0
+	for i < len(s) && strings.ContainsRune(attrKeySymbols, rune(s[i])) {
+		i++
+	}
+	if i == 0 {
+		return nil, fmt.Errorf("empty attribute name at position %d", pos)
+	}
+
+	f := &AttrFilter{Key: s[:i]}
+	rest := s[i:]
+	for _, ao := range attrOperators {
+		if strings.HasPrefix(rest, ao.text) {
+			f.Op = ao.op
+			f.Value = rest[len(ao.text):]
+			if f.Op == AttrOpMatch || f.Op == AttrOpNotMatch {
+				compiled, err := regexp.Compile(f.Value)
+				if err != nil {
+					return nil, fmt.Errorf("error compiling regexp at %d: %s", pos, err)
+				}
+				f.Regexp = compiled
+			} else {
+				// regexps keep their escapes as they're meaningful there
+				f.Value = unescapeAttrValue(f.Value)
+			}
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("expected one of =, !=, =~, !~ after attribute %s at position %d", f.Key, pos+i)
+}
+
+// readAttrFilters reads an attribute filter block starting at the opening
+// bracket and returns the filters along with the position of the closing one.
+// Filters are comma-separated, brackets may be nested (i.e. in regexps)
+// and any symbol may be screened with a backslash
+func readAttrFilters(expr []rune, start int) ([]*AttrFilter, int, error) {
+	filters := make([]*AttrFilter, 0)
+	depth := 0
+	cur := ""
+	curPos := start + 1
+
+	addFilter := func() error {
+		f, err := parseAttrFilter(cur, curPos)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, f)
+		return nil
+	}
+
+	for i := start + 1; i < len(expr); i++ {
+		sym := expr[i]
+		switch {
+		case sym == '\\' && i < len(expr)-1:
+			cur += string(expr[i : i+2])
+			i++
+			continue
+		case sym == '[':
+			depth++
+		case sym == ']':
+			if depth == 0 {
+				if err := addFilter(); err != nil {
+					return nil, i, err
+				}
+				return filters, i, nil
+			}
+			depth--
+		case sym == ',' && depth == 0:
+			if err := addFilter(); err != nil {
+				return nil, i, err
+			}
+			cur = ""
+			curPos = i + 1
+			continue
+		}
+		cur += string(sym)
+	}
+	return nil, len(expr), fmt.Errorf("unclosed attribute filter at position %d", start)
+}
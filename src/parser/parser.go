@@ -3,7 +3,9 @@ package parser
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 )
 
 type TokenType int
@@ -14,6 +16,7 @@ const (
 	TTypeGroup
 	TTypeWorkGroup
 	TTypeHostRegexp
+	TTypePseudoGroup
 )
 
 const (
@@ -25,25 +28,125 @@ const (
 	StateReadTag
 	StateReadHostBracePattern
 	StateReadRegexp
+	StateAfterAttrs
 )
 
 type Token struct {
 	Type             TokenType
 	Value            string
 	DatacenterFilter string
+	DatacenterExact  bool
 	TagsFilter       []string
 	RegexpFilter     *regexp.Regexp
+	AttrFilters      []*AttrFilter
 	Exclude          bool
+	// Backend is the name of the backend the token is
+	// resolved by, set with a prefix like lab:%group
+	Backend string
 }
 
 var (
-	hostSymbols = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789.-{}"
+	hostSymbols      = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789.-{}"
+	namespaceSymbols = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
+
+	pseudoGroups     = make(map[string][]string)
+	pseudoGroupsLock sync.RWMutex
+
+	namespaces     = make(map[string]bool)
+	namespacesLock sync.RWMutex
 )
 
+// SetPseudoGroup registers a group of hosts which doesn't come from a backend,
+// i.e. hosts failed during the last execution. Pseudo group tokens are
+// parsed as TTypePseudoGroup and backends resolve them with PseudoGroup
+func SetPseudoGroup(name string, hosts []string) {
+	pseudoGroupsLock.Lock()
+	defer pseudoGroupsLock.Unlock()
+	pseudoGroups[name] = hosts
+}
+
+// PseudoGroup returns the list of hosts of a pseudo group
+func PseudoGroup(name string) []string {
+	pseudoGroupsLock.RLock()
+	defer pseudoGroupsLock.RUnlock()
+	return pseudoGroups[name]
+}
+
+// IsPseudoGroup checks if a pseudo group with a given name is registered
+func IsPseudoGroup(name string) bool {
+	pseudoGroupsLock.RLock()
+	defer pseudoGroupsLock.RUnlock()
+	_, found := pseudoGroups[name]
+	return found
+}
+
+// PseudoGroupNames returns a sorted list of pseudo group names
+func PseudoGroupNames() []string {
+	pseudoGroupsLock.RLock()
+	defer pseudoGroupsLock.RUnlock()
+	names := make([]string, 0, len(pseudoGroups))
+	for name := range pseudoGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetNamespaces registers names of backends tokens may be prefixed with.
+// A prefix is recognized only if it's a registered name so a single
+// backend setup parses expressions the same way as before
+func SetNamespaces(names []string) {
+	namespacesLock.Lock()
+	defer namespacesLock.Unlock()
+	namespaces = make(map[string]bool)
+	for _, name := range names {
+		namespaces[name] = true
+	}
+}
+
+// IsNamespace checks if a backend name is registered
+func IsNamespace(name string) bool {
+	namespacesLock.RLock()
+	defer namespacesLock.RUnlock()
+	return namespaces[name]
+}
+
+// Namespaces returns a sorted list of registered backend names
+func Namespaces() []string {
+	namespacesLock.RLock()
+	defer namespacesLock.RUnlock()
+	names := make([]string, 0, len(namespaces))
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NamespacePrefix returns the length of a "backend:" prefix
+// the expression starts with including the colon, 0 if none
+func NamespacePrefix(expr []rune) int {
+	ci := -1
+	for i, sym := range expr {
+		if sym == ':' {
+			ci = i
+			break
+		}
+		if !strings.ContainsRune(namespaceSymbols, sym) {
+			return 0
+		}
+	}
+	if ci <= 0 || !IsNamespace(string(expr[:ci])) {
+		return 0
+	}
+	return ci + 1
+}
+
 func newToken() *Token {
 	ct := new(Token)
 	ct.TagsFilter = make([]string, 0)
 	ct.RegexpFilter = nil
+	ct.AttrFilters = make([]*AttrFilter, 0)
 	return ct
 }
 
@@ -71,13 +174,46 @@ func ParseExpression(expr []rune) ([]*Token, error) {
 	for i := 0; i < len(expr); i++ {
 		sym := expr[i]
 		last = i == len(expr)-1
+
+		if sym == '[' && (state == StateReadGroup || state == StateReadWorkGroup ||
+			state == StateReadHost || state == StateReadDatacenter || state == StateReadTag) {
+			if ct.Type == TTypeHostRegexp {
+				return nil, fmt.Errorf("attribute filter is not allowed in a regexp token at position %d", i)
+			}
+			if state == StateReadGroup && ct.Value == "" {
+				return nil, fmt.Errorf("Empty group name at position %d", i)
+			}
+			if state == StateReadTag {
+				if tag == "" {
+					return nil, fmt.Errorf("Empty tag at position %d", i)
+				}
+				ct.TagsFilter = append(ct.TagsFilter, tag)
+			}
+			filters, end, err := readAttrFilters(expr, i)
+			if err != nil {
+				return nil, err
+			}
+			ct.AttrFilters = append(ct.AttrFilters, filters...)
+			state = StateAfterAttrs
+			i = end
+			continue
+		}
+
 		switch state {
 		case StateWait:
-			if sym == '-' {
+			if sym == '-' && ct.Backend == "" {
 				ct.Exclude = true
 				continue
 			}
 
+			if ct.Backend == "" {
+				if pl := NamespacePrefix(expr[i:]); pl > 0 {
+					ct.Backend = string(expr[i : i+pl-1])
+					i += pl - 1
+					continue
+				}
+			}
+
 			if sym == '*' {
 				state = StateReadWorkGroup
 				ct.Type = TTypeWorkGroup
@@ -237,6 +373,12 @@ func ParseExpression(expr []rune) ([]*Token, error) {
 			ct.Value += string(sym)
 
 		case StateReadDatacenter:
+			if sym == '=' && ct.DatacenterFilter == "" && !ct.DatacenterExact {
+				// @=dc matches the datacenter itself but not its children
+				ct.DatacenterExact = true
+				continue
+			}
+
 			if sym == ',' || last {
 				if last && sym != ',' {
 					ct.DatacenterFilter += string(sym)
@@ -288,15 +430,31 @@ func ParseExpression(expr []rune) ([]*Token, error) {
 			}
 
 			tag += string(sym)
+
+		case StateAfterAttrs:
+			if sym == ',' {
+				res = append(res, ct)
+				ct = newToken()
+				state = StateWait
+				continue
+			}
+
+			if sym == '/' {
+				re = ""
+				state = StateReadRegexp
+				continue
+			}
+
+			return nil, fmt.Errorf("Invalid symbol %s after attribute filter at position %d, expected , or /", string(sym), i)
 		}
 
 	}
 
-	if ct.Value != "" || state == StateReadWorkGroup {
+	if ct.Value != "" || state == StateReadWorkGroup || state == StateAfterAttrs {
 		// workgroup token can be empty
 		res = append(res, ct)
 	} else {
-		if state != StateWait {
+		if state != StateWait || ct.Backend != "" {
 			return nil, fmt.Errorf("unexpected end of expression")
 		}
 	}
@@ -305,6 +463,15 @@ func ParseExpression(expr []rune) ([]*Token, error) {
 		return nil, fmt.Errorf("unexpected end of expression")
 	}
 
+	for _, token := range res {
+		if token.Type == TTypeGroup && IsPseudoGroup(token.Value) {
+			token.Type = TTypePseudoGroup
+		}
+		if token.Type == TTypeHostRegexp && token.RegexpFilter == nil {
+			return nil, fmt.Errorf("regexp token must look like ~/regexp/")
+		}
+	}
+
 	return res, nil
 }
 
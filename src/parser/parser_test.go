@@ -85,3 +85,307 @@ func TestParseAll(t *testing.T) {
 	}
 
 }
+
+func TestParsePseudoGroup(t *testing.T) {
+	SetPseudoGroup("_failed", []string{"host1", "host2"})
+	expr := "%_failed,%_other"
+	tokens, err := ParseExpression([]rune(expr))
+
+	if err != nil {
+		t.Error(err)
+	}
+	if len(tokens) != 2 {
+		t.Error("Number of tokens must be exactly 2")
+	}
+
+	if tokens[0].Type != TTypePseudoGroup {
+		t.Error("TokenType must be TTypePseudoGroup")
+	}
+
+	if tokens[0].Value != "_failed" {
+		t.Errorf("token value expected to be \"_failed\", got %s", tokens[0].Value)
+	}
+
+	if tokens[1].Type != TTypeGroup {
+		t.Error("unregistered pseudo group must be parsed as TTypeGroup")
+	}
+
+	if len(PseudoGroup("_failed")) != 2 {
+		t.Error("pseudo group _failed must contain exactly 2 hosts")
+	}
+}
+
+func testResolver(groups map[string][]string) TokenResolver {
+	return func(token *Token) ([]string, error) {
+		if token.Type == TTypeGroup {
+			return groups[token.Value], nil
+		}
+		return []string{token.Value}, nil
+	}
+}
+
+func checkHostlist(t *testing.T, expr string, resolve TokenResolver, expected []string) {
+	ast, err := Parse([]rune(expr))
+	if err != nil {
+		t.Errorf("error parsing %s: %s", expr, err)
+		return
+	}
+	hosts, err := ast.Evaluate(resolve)
+	if err != nil {
+		t.Errorf("error evaluating %s: %s", expr, err)
+		return
+	}
+	if len(hosts) != len(expected) {
+		t.Errorf("%s: expected %v, got %v", expr, expected, hosts)
+		return
+	}
+	for i := range hosts {
+		if hosts[i] != expected[i] {
+			t.Errorf("%s: expected %v, got %v", expr, expected, hosts)
+			return
+		}
+	}
+}
+
+func TestEvaluateUnion(t *testing.T) {
+	resolve := testResolver(map[string][]string{
+		"g1": {"host1", "host2", "host3"},
+	})
+	checkHostlist(t, "%g1,-host2,host4", resolve, []string{"host1", "host3", "host4"})
+	checkHostlist(t, "-host1,host1", resolve, []string{"host1"})
+	checkHostlist(t, "host1,host2,", resolve, []string{"host1", "host2"})
+	checkHostlist(t, "", resolve, []string{})
+}
+
+func TestEvaluateIntersection(t *testing.T) {
+	resolve := testResolver(map[string][]string{
+		"g1": {"host1", "host2", "host3"},
+		"g2": {"host2", "host3", "host4"},
+		"g3": {"host3", "host5"},
+	})
+	checkHostlist(t, "%g1&%g2", resolve, []string{"host2", "host3"})
+	checkHostlist(t, "%g1&-%g2", resolve, []string{"host1"})
+	checkHostlist(t, "%g1&%g2,host9", resolve, []string{"host2", "host3", "host9"})
+	checkHostlist(t, "%g2&(%g1,%g3)", resolve, []string{"host2", "host3"})
+	checkHostlist(t, "%g1,host4,-(%g1&%g2)", resolve, []string{"host1", "host4"})
+	checkHostlist(t, "%g1,host4,-%g1&%g3", resolve, []string{"host1", "host2", "host4"})
+}
+
+func TestParseTreeErrors(t *testing.T) {
+	for _, expr := range []string{"(%g1", "%g1)", "%g1&", "&%g1", "host1,,host2", "()"} {
+		_, err := Parse([]rune(expr))
+		if err == nil {
+			t.Errorf("expression %s was expected to fail", expr)
+		}
+	}
+}
+
+func TestParseTreeRegexp(t *testing.T) {
+	ast, err := Parse([]rune("%g1/^web(1|2)&/,host{1,2}"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(ast.Children) != 2 {
+		t.Errorf("expected 2 tokens, got %d", len(ast.Children))
+		return
+	}
+	if ast.Children[0].Token.RegexpFilter == nil {
+		t.Error("regexp filter should not be nil")
+	}
+	if ast.Children[1].Token.Value != "host{1,2}" {
+		t.Errorf("expected host pattern host{1,2}, got %s", ast.Children[1].Token.Value)
+	}
+}
+
+func TestParseHostRegexp(t *testing.T) {
+	tokens, err := ParseExpression([]rune("~/web\\d+/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Type != TTypeHostRegexp || tokens[0].RegexpFilter == nil {
+		t.Errorf("expected a single host regexp token, got %v", tokens)
+	}
+
+	for _, expr := range []string{"~host", "-~host", "web1,~web"} {
+		_, err := ParseExpression([]rune(expr))
+		if err == nil {
+			t.Errorf("expression %s without a regexp was expected to fail", expr)
+		}
+	}
+}
+
+func TestParseAttrFilters(t *testing.T) {
+	tokens, err := ParseExpression([]rune("%group1@dc1#tag1[dc=~msk.*,alias!=foo,fqdn!~^db[0-9]]/web/,host1"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(tokens) != 2 {
+		t.Errorf("expected 2 tokens, got %d", len(tokens))
+		return
+	}
+	token := tokens[0]
+	if token.Value != "group1" || token.DatacenterFilter != "dc1" || len(token.TagsFilter) != 1 || token.TagsFilter[0] != "tag1" {
+		t.Errorf("unexpected token %+v", token)
+	}
+	if token.RegexpFilter == nil {
+		t.Error("regexp filter should not be nil")
+	}
+	if len(token.AttrFilters) != 3 {
+		t.Errorf("expected 3 attribute filters, got %d", len(token.AttrFilters))
+		return
+	}
+	expected := []string{"dc=~msk.*", "alias!=foo", "fqdn!~^db[0-9]"}
+	for i, f := range token.AttrFilters {
+		if f.String() != expected[i] {
+			t.Errorf("expected filter %s, got %s", expected[i], f.String())
+		}
+	}
+
+	for _, expr := range []string{"%g1[dc", "%g1[=foo]", "%g1[dc>1]", "%g1[dc=~(]", "%[dc=1]", "%g1[dc=1]x"} {
+		_, err := ParseExpression([]rune(expr))
+		if err == nil {
+			t.Errorf("expression %s was expected to fail", expr)
+		}
+	}
+}
+
+func TestAttrFilterEscapes(t *testing.T) {
+	tokens, err := ParseExpression([]rune(`%web[tag=a\,b,alias!=x\]y,fqdn=~^db\.1]`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	filters := tokens[0].AttrFilters
+	if len(filters) != 3 {
+		t.Errorf("expected 3 attribute filters, got %d", len(filters))
+		return
+	}
+	expected := []struct {
+		value  string
+		String string
+	}{
+		{"a,b", `tag=a\,b`},
+		{"x]y", `alias!=x\]y`},
+		{`^db\.1`, `fqdn=~^db\.1`},
+	}
+	for i, f := range filters {
+		if f.Value != expected[i].value || f.String() != expected[i].String {
+			t.Errorf("expected filter value %q (%s), got %q (%s)", expected[i].value, expected[i].String, f.Value, f.String())
+		}
+	}
+	if !tokens[0].MatchAttrs(map[string][]string{"tag": {"a,b"}, "fqdn": {"db.1"}}) {
+		t.Error("escaped values were expected to match")
+	}
+}
+
+func TestMatchAttrs(t *testing.T) {
+	tokens, err := ParseExpression([]rune("*[dc=~^msk,alias=foo,tag!=old]"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	token := tokens[0]
+	if token.Type != TTypeWorkGroup || token.Value != "" {
+		t.Errorf("unexpected token %+v", token)
+	}
+
+	attrs := map[string][]string{
+		"dc":    {"msk1", "ru"},
+		"alias": {"bar", "foo"},
+	}
+	if !token.MatchAttrs(attrs) {
+		t.Error("attributes were expected to match")
+	}
+	attrs["tag"] = []string{"new", "old"}
+	if token.MatchAttrs(attrs) {
+		t.Error("attributes were expected not to match because of the tag")
+	}
+	if token.MatchAttrs(map[string][]string{}) {
+		t.Error("missing attributes were expected not to match")
+	}
+}
+
+func TestParseTreeAttrFilters(t *testing.T) {
+	ast, err := Parse([]rune("%g1[alias=a,dc=~(x|y)],-host1&host2"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(ast.Children) != 2 {
+		t.Errorf("expected 2 terms, got %d", len(ast.Children))
+		return
+	}
+	if len(ast.Children[0].Token.AttrFilters) != 2 {
+		t.Errorf("expected 2 attribute filters, got %d", len(ast.Children[0].Token.AttrFilters))
+	}
+}
+
+func TestParseDatacenterExact(t *testing.T) {
+	tokens, err := ParseExpression([]rune("%group1@=dc1#tag1,%group2@dc2"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(tokens) != 2 {
+		t.Errorf("expected 2 tokens, got %d", len(tokens))
+		return
+	}
+	if !tokens[0].DatacenterExact || tokens[0].DatacenterFilter != "dc1" {
+		t.Errorf("expected exact datacenter filter dc1, got %+v", tokens[0])
+	}
+	if len(tokens[0].TagsFilter) != 1 || tokens[0].TagsFilter[0] != "tag1" {
+		t.Errorf("expected tag filter tag1, got %v", tokens[0].TagsFilter)
+	}
+	if tokens[1].DatacenterExact || tokens[1].DatacenterFilter != "dc2" {
+		t.Errorf("expected datacenter filter dc2, got %+v", tokens[1])
+	}
+}
+
+func TestParseNamespaces(t *testing.T) {
+	SetNamespaces([]string{"lab", "prod-1"})
+	defer SetNamespaces(nil)
+
+	cases := []struct {
+		expr    string
+		backend string
+		typ     TokenType
+		value   string
+		exclude bool
+	}{
+		{"lab:%web", "lab", TTypeGroup, "web", false},
+		{"-prod-1:*wg", "prod-1", TTypeWorkGroup, "wg", true},
+		{"lab:host1", "lab", TTypeHost, "host1", false},
+		{"other:%web", "", TTypeHost, "other:%web", false},
+		{"%web", "", TTypeGroup, "web", false},
+	}
+	for _, c := range cases {
+		tokens, err := ParseExpression([]rune(c.expr))
+		if err != nil {
+			t.Errorf("error parsing %s: %s", c.expr, err)
+			continue
+		}
+		if len(tokens) != 1 {
+			t.Errorf("%s expected to give 1 token, got %d", c.expr, len(tokens))
+			continue
+		}
+		token := tokens[0]
+		if token.Backend != c.backend || token.Type != c.typ || token.Value != c.value || token.Exclude != c.exclude {
+			t.Errorf("%s parsed to backend=%q type=%d value=%q exclude=%v", c.expr, token.Backend, token.Type, token.Value, token.Exclude)
+		}
+	}
+
+	_, err := ParseExpression([]rune("lab:"))
+	if err == nil {
+		t.Error("a prefix without a token must be an error")
+	}
+
+	ast, err := Parse([]rune("lab:%web&-prod-1:%db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ast.Children[0].Children[0].Token.Backend != "lab" || ast.Children[0].Children[1].Token.Backend != "prod-1" {
+		t.Error("backend prefixes must be kept in the tree")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"syscall"
+	"time"
 )
 
 func (w *Worker) cmd(task *Task) int {
@@ -22,6 +23,7 @@ func (w *Worker) cmd(task *Task) int {
 	// TODO consider chaging nb-reader to poller
 	stdout, stderr, stdin, err := makeCmdPipes(cmd)
 	taskForceStopped := false
+	taskTimedOut := false
 	stdoutFinished := false
 	stderrFinished := false
 	shouldSkipEcho := false
@@ -29,6 +31,7 @@ func (w *Worker) cmd(task *Task) int {
 
 	cmd.Start()
 	log.Debugf("WRK[%d]: Command started", w.id)
+	deadline := time.Now().Add(task.Timeout)
 
 execLoop:
 	for {
@@ -37,6 +40,11 @@ execLoop:
 			break
 		}
 
+		if task.Timeout > 0 && time.Now().After(deadline) {
+			taskTimedOut = true
+			break
+		}
+
 		if !stdoutFinished {
 			// reading stdout
 			buf = make([]byte, bufferSize)
@@ -122,10 +130,15 @@ execLoop:
 		stdin.Close()
 		exitCode = ErrForceStop
 		log.Debugf("WRK[%d]: Task on %s was force stopped", w.id, task.HostName)
+	} else if taskTimedOut {
+		cmd.Process.Kill()
+		stdin.Close()
+		exitCode = ErrTimeout
+		log.Debugf("WRK[%d]: Task on %s has timed out", w.id, task.HostName)
 	}
 
 	err = cmd.Wait()
-	if !taskForceStopped {
+	if !taskForceStopped && !taskTimedOut {
 		if err != nil {
 			if exitErr, ok := err.(*exec.ExitError); ok {
 				ws := exitErr.Sys().(syscall.WaitStatus)
@@ -4,11 +4,15 @@ import (
 	"fmt"
 	"os/exec"
 	"strings"
+	"sync"
 )
 
 var (
-	// SSHOptions defines generic SSH options to use in creating exec.Cmd
-	SSHOptions = map[string]string{
+	// sshOptions defines generic SSH options to use in creating exec.Cmd.
+	// They're changed by commands while workers read them, so they're
+	// accessed with sshOptionsLock held
+	sshOptionsLock sync.RWMutex
+	sshOptions     = map[string]string{
 		"PasswordAuthentication": "no",
 		"PubkeyAuthentication":   "yes",
 		"StrictHostKeyChecking":  "no",
@@ -21,19 +25,45 @@ var (
 	suInterpreter   = []string{}
 )
 
-func sshOpts() (params []string) {
-	params = make([]string, 0)
-	for opt, value := range SSHOptions {
+// SetSSHOption sets an option passed to ssh and scp with -o.
+// An empty value removes the option
+func SetSSHOption(name string, value string) {
+	sshOptionsLock.Lock()
+	defer sshOptionsLock.Unlock()
+	if value == "" {
+		delete(sshOptions, name)
+		return
+	}
+	sshOptions[name] = value
+}
+
+func sshOption(name string) string {
+	sshOptionsLock.RLock()
+	defer sshOptionsLock.RUnlock()
+	return sshOptions[name]
+}
+
+func sshOpts(host string, user string) (params []string) {
+	params = hostConfigFile(host)
+	sshOptionsLock.RLock()
+	for opt, value := range sshOptions {
 		option := fmt.Sprintf("%s=%s", opt, value)
 		params = append(params, "-o", option)
 	}
+	sshOptionsLock.RUnlock()
+	if sock := controlPath(host, user); sock != "" {
+		params = append(params, "-o", "ControlPath="+sock)
+	}
 	return
 }
 
 // CreateSCPCmd creates a generic scp command
 func CreateSCPCmd(host string, user string, localFilename string, remoteFilename string) *exec.Cmd {
-	params := sshOpts()
-	remoteExpr := fmt.Sprintf("%s@%s:%s", user, host, remoteFilename)
+	params := sshOpts(host, hostUser(host, user))
+	if port := hostPort(host); port != "" {
+		params = append(params, "-P", port)
+	}
+	remoteExpr := fmt.Sprintf("%s@%s:%s", hostUser(host, user), host, remoteFilename)
 	params = append(params, localFilename, remoteExpr)
 	log.Debugf("Created command scp %v", params)
 	return exec.Command("scp", params...)
@@ -44,9 +74,12 @@ func CreateSSHCmd(host string, user string, raise RaiseType, argv string) *exec.
 	params := []string{
 		"-tt",
 		"-l",
-		user,
+		hostUser(host, user),
+	}
+	if port := hostPort(host); port != "" {
+		params = append(params, "-p", port)
 	}
-	params = append(params, sshOpts()...)
+	params = append(params, sshOpts(host, hostUser(host, user))...)
 	params = append(params, host)
 
 	switch raise {
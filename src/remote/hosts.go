@@ -0,0 +1,103 @@
+package remote
+
+import (
+	"strconv"
+	"sync"
+)
+
+// HostSettings are per-host connection settings taking precedence
+// over the global ones. Empty values mean the global settings apply
+type HostSettings struct {
+	User    string
+	Port    int
+	Address string
+	// ConfigFile is the ssh client config the host comes from,
+	// it's passed to openssh so the config applies as a whole
+	ConfigFile string
+}
+
+var (
+	// hostSettings maps sources of settings, i.e. backends, to the
+	// settings of their hosts. settingsSources keeps the order the
+	// sources have been set in for the first time
+	hostSettings     = make(map[string]map[string]*HostSettings)
+	settingsSources  = make([]string, 0)
+	hostSettingsLock sync.RWMutex
+
+	// explicitUser is set when the user is chosen explicitly,
+	// then it beats the users of host settings
+	explicitUser bool
+)
+
+// SetHostSettings replaces per-host connection settings coming from
+// a source. Backends which know them call it on every (re)load with a
+// source identifying the backend, so the settings of other backends are
+// kept. If several sources know a host, the one set first wins
+func SetHostSettings(source string, settings map[string]*HostSettings) {
+	hostSettingsLock.Lock()
+	defer hostSettingsLock.Unlock()
+	if _, found := hostSettings[source]; !found {
+		settingsSources = append(settingsSources, source)
+	}
+	hostSettings[source] = settings
+}
+
+func settingsOf(host string) *HostSettings {
+	hostSettingsLock.RLock()
+	defer hostSettingsLock.RUnlock()
+	for _, source := range settingsSources {
+		if hs, found := hostSettings[source][host]; found {
+			return hs
+		}
+	}
+	return &HostSettings{}
+}
+
+// SetExplicitUser tells if the user passed to commands is chosen explicitly
+// (by main.user, -u option or user command) rather than being the default one
+func SetExplicitUser(explicit bool) {
+	hostSettingsLock.Lock()
+	defer hostSettingsLock.Unlock()
+	explicitUser = explicit
+}
+
+// hostUser returns the user to log in to the host with, the user of
+// host settings is used unless another one is chosen explicitly
+func hostUser(host string, user string) string {
+	hostSettingsLock.RLock()
+	explicit := explicitUser
+	hostSettingsLock.RUnlock()
+	if explicit {
+		return user
+	}
+	if hs := settingsOf(host); hs.User != "" {
+		return hs.User
+	}
+	return user
+}
+
+// hostPort returns the port of the host's ssh server, empty string if default
+func hostPort(host string) string {
+	if hs := settingsOf(host); hs.Port > 0 {
+		return strconv.Itoa(hs.Port)
+	}
+	return ""
+}
+
+// hostAddress returns the address to connect to. The openssh transport
+// doesn't need it as ssh resolves it itself reading hostConfigFile
+func hostAddress(host string) string {
+	if hs := settingsOf(host); hs.Address != "" {
+		return hs.Address
+	}
+	return host
+}
+
+// hostConfigFile returns ssh options making openssh read the config file
+// the host comes from, nil if ssh should read the default one
+func hostConfigFile(host string) []string {
+	if hs := settingsOf(host); hs.ConfigFile != "" {
+		return []string{"-F", hs.ConfigFile}
+	}
+	return nil
+}
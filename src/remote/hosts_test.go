@@ -0,0 +1,48 @@
+package remote
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHostSettingsSources(t *testing.T) {
+	SetHostSettings("first", map[string]*HostSettings{
+		"web1": {User: "deploy", Port: 2222, ConfigFile: "/first"},
+		"both": {User: "first"},
+	})
+	SetHostSettings("second", map[string]*HostSettings{
+		"db1":  {Address: "10.0.0.1", ConfigFile: "/second"},
+		"both": {User: "second"},
+	})
+
+	// reloading a source replaces its settings only
+	SetHostSettings("first", map[string]*HostSettings{
+		"web1": {User: "deploy", Port: 2200, ConfigFile: "/first"},
+		"both": {User: "first"},
+	})
+
+	if port := hostPort("web1"); port != "2200" {
+		t.Errorf("web1 port expected to be 2200, got %q", port)
+	}
+	if address := hostAddress("db1"); address != "10.0.0.1" {
+		t.Errorf("db1 address expected to be 10.0.0.1, got %q", address)
+	}
+	if cf := hostConfigFile("db1"); !reflect.DeepEqual(cf, []string{"-F", "/second"}) {
+		t.Errorf("db1 config file options expected to be [-F /second], got %v", cf)
+	}
+	if user := hostUser("both", "root"); user != "first" {
+		t.Errorf("the source set first expected to win, got user %q", user)
+	}
+	if user := hostUser("unknown", "root"); user != "root" {
+		t.Errorf("unknown host user expected to be root, got %q", user)
+	}
+	if port, cf := hostPort("unknown"), hostConfigFile("unknown"); port != "" || cf != nil {
+		t.Errorf("unknown host expected to have no settings, got port %q and config %v", port, cf)
+	}
+
+	SetExplicitUser(true)
+	defer SetExplicitUser(false)
+	if user := hostUser("web1", "root"); user != "root" {
+		t.Errorf("explicit user expected to beat the settings, got %q", user)
+	}
+}
@@ -0,0 +1,366 @@
+package remote
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Connection describes a persistent master connection to a remote host
+type Connection struct {
+	Host      string
+	User      string
+	Transport Transport
+	Created   time.Time
+	LastUsed  time.Time
+}
+
+type nativeConn struct {
+	client   *ssh.Client
+	host     string
+	user     string
+	created  time.Time
+	lastUsed time.Time
+	refs     int
+}
+
+const (
+	// maxStopMasters limits the number of ssh -O exit run at once
+	maxStopMasters = 32
+)
+
+var (
+	// muxLock guards multiplexing, muxIdle and controlDir which are
+	// read by the workers while commands run
+	muxLock         sync.RWMutex
+	multiplexing    = false
+	muxIdle         = 10 * time.Minute
+	controlDir      = ""
+	nativeConns     = make(map[string]*nativeConn)
+	nativeConnsLock sync.Mutex
+	expireLoopOnce  sync.Once
+
+	// controlConns maps ControlMaster sockets to the connections they
+	// serve, socket names are hashes so they can't be parsed back
+	controlConns     = make(map[string]*Connection)
+	controlConnsLock sync.Mutex
+)
+
+// SetMultiplexing switches persistent connections on or off. When on,
+// the native transport keeps its authenticated connections open and the
+// openssh transport uses ControlMaster sockets. Connections not used for
+// the idle duration are closed
+func SetMultiplexing(enabled bool, idle time.Duration) error {
+	if !enabled {
+		CloseConnections()
+		return nil
+	}
+
+	muxLock.Lock()
+	defer muxLock.Unlock()
+	if controlDir == "" {
+		// unix socket paths are limited to ~104 bytes and $TMPDIR may be
+		// long already (i.e. on macOS), so sockets are put into /tmp
+		dir, err := ioutil.TempDir("/tmp", fmt.Sprintf("xc.%d.", os.Getuid()))
+		if err != nil {
+			return err
+		}
+		controlDir = dir
+	}
+
+	muxIdle = idle
+	SetSSHOption("ControlMaster", "auto")
+	SetSSHOption("ControlPersist", fmt.Sprintf("%d", int(idle.Seconds())))
+	multiplexing = true
+	expireLoopOnce.Do(func() {
+		go expireLoop()
+	})
+	return nil
+}
+
+// Multiplexing returns true if persistent connections are switched on
+func Multiplexing() bool {
+	muxLock.RLock()
+	defer muxLock.RUnlock()
+	return multiplexing
+}
+
+func connKey(host string, user string) string {
+	return user + "@" + host
+}
+
+// acquireClient returns a cached connection to the host or dials a new one
+func acquireClient(host string, user string) (*ssh.Client, error) {
+	if !Multiplexing() {
+		return dialNative(host, user)
+	}
+
+	key := connKey(host, user)
+	nativeConnsLock.Lock()
+	if nc, found := nativeConns[key]; found {
+		nc.refs++
+		nc.lastUsed = time.Now()
+		nativeConnsLock.Unlock()
+		return nc.client, nil
+	}
+	nativeConnsLock.Unlock()
+
+	// dialing may take a while so it's done without holding the lock
+	client, err := dialNative(host, user)
+	if err != nil {
+		return nil, err
+	}
+
+	nativeConnsLock.Lock()
+	defer nativeConnsLock.Unlock()
+	if nc, found := nativeConns[key]; found {
+		// another worker has connected in the meantime
+		client.Close()
+		nc.refs++
+		nc.lastUsed = time.Now()
+		return nc.client, nil
+	}
+	now := time.Now()
+	nativeConns[key] = &nativeConn{client, host, user, now, now, 1}
+	log.Debugf("Persistent connection to %s established", key)
+	return client, nil
+}
+
+// releaseClient returns the connection to the cache or closes it
+// if it isn't cached
+func releaseClient(host string, user string, client *ssh.Client) {
+	nativeConnsLock.Lock()
+	defer nativeConnsLock.Unlock()
+	nc, found := nativeConns[connKey(host, user)]
+	if !found || nc.client != client {
+		client.Close()
+		return
+	}
+	nc.refs--
+	nc.lastUsed = time.Now()
+}
+
+// forgetClient removes a (presumably broken) connection from the cache
+func forgetClient(host string, user string, client *ssh.Client) {
+	key := connKey(host, user)
+	nativeConnsLock.Lock()
+	if nc, found := nativeConns[key]; found && nc.client == client {
+		delete(nativeConns, key)
+	}
+	nativeConnsLock.Unlock()
+	client.Close()
+}
+
+// openSession creates a new session reusing a persistent connection if possible
+func openSession(host string, user string) (*ssh.Client, *ssh.Session, error) {
+	client, err := acquireClient(host, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := client.NewSession()
+	if err == nil {
+		return client, session, nil
+	}
+
+	if !Multiplexing() {
+		client.Close()
+		return nil, nil, err
+	}
+
+	// the cached connection might have been closed by the remote side
+	log.Debugf("Persistent connection to %s is broken, reconnecting: %s", connKey(host, user), err)
+	forgetClient(host, user, client)
+	client, err = acquireClient(host, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err = client.NewSession()
+	if err != nil {
+		forgetClient(host, user, client)
+		return nil, nil, err
+	}
+	return client, session, nil
+}
+
+func expireLoop() {
+	for {
+		time.Sleep(10 * time.Second)
+		muxLock.RLock()
+		idle := muxIdle
+		muxLock.RUnlock()
+		expired := make([]*ssh.Client, 0)
+		nativeConnsLock.Lock()
+		for key, nc := range nativeConns {
+			if nc.refs <= 0 && time.Since(nc.lastUsed) > idle {
+				expired = append(expired, nc.client)
+				delete(nativeConns, key)
+				log.Debugf("Persistent connection to %s expired", key)
+			}
+		}
+		nativeConnsLock.Unlock()
+		for _, client := range expired {
+			client.Close()
+		}
+	}
+}
+
+// controlPath returns the ControlMaster socket of the openssh transport
+// for the host and the user, empty string if multiplexing is off. The
+// name is a short hash of the destination so long host names don't
+// exceed the socket path limit
+func controlPath(host string, user string) string {
+	muxLock.RLock()
+	dir := controlDir
+	if !multiplexing {
+		dir = ""
+	}
+	muxLock.RUnlock()
+	if dir == "" {
+		return ""
+	}
+	sum := sha1.Sum([]byte(user + "@" + host + ":" + hostPort(host)))
+	sock := filepath.Join(dir, hex.EncodeToString(sum[:8]))
+
+	controlConnsLock.Lock()
+	defer controlConnsLock.Unlock()
+	if _, found := controlConns[sock]; !found {
+		controlConns[sock] = &Connection{Host: host, User: user, Transport: TransportOpenSSH}
+	}
+	return sock
+}
+
+// controlSockets returns openssh ControlMaster sockets created by xc
+// as a map socket path -> connection
+func controlSockets() map[string]*Connection {
+	res := make(map[string]*Connection)
+	controlConnsLock.Lock()
+	defer controlConnsLock.Unlock()
+	for sock, conn := range controlConns {
+		st, err := os.Stat(sock)
+		if err != nil {
+			// the master has exited or has never been started
+			continue
+		}
+		c := *conn
+		c.Created = st.ModTime()
+		res[sock] = &c
+	}
+	return res
+}
+
+func forgetControlSocket(sock string) {
+	controlConnsLock.Lock()
+	delete(controlConns, sock)
+	controlConnsLock.Unlock()
+}
+
+// Connections returns the list of persistent connections of both transports
+func Connections() []*Connection {
+	res := make([]*Connection, 0)
+	nativeConnsLock.Lock()
+	for _, nc := range nativeConns {
+		res = append(res, &Connection{nc.host, nc.user, TransportNative, nc.created, nc.lastUsed})
+	}
+	nativeConnsLock.Unlock()
+	for _, conn := range controlSockets() {
+		res = append(res, conn)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Host == res[j].Host {
+			return res[i].User < res[j].User
+		}
+		return res[i].Host < res[j].Host
+	})
+	return res
+}
+
+// DropConnections closes persistent connections to the given hosts.
+// If hosts is nil, all the connections are closed.
+// Returns the number of connections closed
+func DropConnections(hosts []string) int {
+	shouldDrop := func(host string) bool {
+		if hosts == nil {
+			return true
+		}
+		for _, h := range hosts {
+			if h == host {
+				return true
+			}
+		}
+		return false
+	}
+
+	dropped := 0
+	nativeConnsLock.Lock()
+	for key, nc := range nativeConns {
+		if shouldDrop(nc.host) {
+			// the tasks in progress are aborted by closing the connection
+			nc.client.Close()
+			delete(nativeConns, key)
+			dropped++
+		}
+	}
+	nativeConnsLock.Unlock()
+
+	// every master is stopped by a process of its own, so they're
+	// stopped in parallel not to make exit take seconds on many hosts
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxStopMasters)
+	for sock, conn := range controlSockets() {
+		if !shouldDrop(conn.Host) {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(sock string, conn *Connection) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			stopMaster(sock, conn)
+		}(sock, conn)
+		dropped++
+	}
+	wg.Wait()
+	return dropped
+}
+
+// stopMaster asks an openssh ControlMaster to exit
+func stopMaster(sock string, conn *Connection) {
+	params := append(hostConfigFile(conn.Host), "-O", "exit", "-o", "ControlPath="+sock, "-l", conn.User, conn.Host)
+	cmd := exec.Command("ssh", params...)
+	err := cmd.Run()
+	if err != nil {
+		log.Debugf("Error stopping ControlMaster %s: %s", sock, err)
+		os.Remove(sock)
+	}
+	forgetControlSocket(sock)
+}
+
+// CloseConnections closes all the persistent connections, switches
+// multiplexing off and removes the temporary ControlMaster directory.
+// Must be called at xc's exit
+func CloseConnections() {
+	muxLock.Lock()
+	multiplexing = false
+	muxLock.Unlock()
+	DropConnections(nil)
+
+	muxLock.Lock()
+	if controlDir != "" {
+		os.RemoveAll(controlDir)
+		controlDir = ""
+	}
+	muxLock.Unlock()
+	SetSSHOption("ControlMaster", "")
+	SetSSHOption("ControlPersist", "")
+}
@@ -0,0 +1,415 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Transport is a enum of ways to reach remote hosts
+type Transport int
+
+// Enum of transports
+const (
+	TransportOpenSSH Transport = iota
+	TransportNative
+)
+
+type streamChunk struct {
+	data  []byte
+	otype OutputType
+}
+
+var (
+	currentTransport = TransportOpenSSH
+	keyFiles         = []string{}
+	authMethods      []ssh.AuthMethod
+	authLock         sync.Mutex
+)
+
+// SetTransport sets the transport used by workers for the tasks created afterwards
+func SetTransport(t Transport) {
+	currentTransport = t
+}
+
+// SetKeyFiles sets the list of private key files the native transport
+// tries to authenticate with (after ssh-agent keys if an agent is available)
+func SetKeyFiles(files []string) {
+	authLock.Lock()
+	defer authLock.Unlock()
+	keyFiles = files
+	// force re-reading keys on the next connection
+	authMethods = nil
+}
+
+func nativeAuth() []ssh.AuthMethod {
+	authLock.Lock()
+	defer authLock.Unlock()
+	if authMethods != nil {
+		return authMethods
+	}
+
+	authMethods = make([]ssh.AuthMethod, 0)
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			log.Debugf("Can't connect to ssh-agent at %s: %s", sock, err)
+		} else {
+			authMethods = append(authMethods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	signers := make([]ssh.Signer, 0)
+	for _, kf := range keyFiles {
+		data, err := ioutil.ReadFile(kf)
+		if err != nil {
+			log.Debugf("Skipping key file %s: %s", kf, err)
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			// passphrase protected keys are expected to be served by ssh-agent
+			log.Debugf("Skipping key file %s: %s", kf, err)
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		authMethods = append(authMethods, ssh.PublicKeys(signers...))
+	}
+	return authMethods
+}
+
+// sshOptionInt reads an integer value from the ssh options so the native
+// transport follows the same settings as the openssh one
+func sshOptionInt(name string) int {
+	value, err := strconv.Atoi(sshOption(name))
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+func dialNative(host string, user string) (*ssh.Client, error) {
+	cfg := &ssh.ClientConfig{
+		User: hostUser(host, user),
+		Auth: nativeAuth(),
+		// the same as StrictHostKeyChecking=no in the ssh options
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Duration(sshOptionInt("ConnectTimeout")) * time.Second,
+	}
+	port := hostPort(host)
+	if port == "" {
+		port = "22"
+	}
+	client, err := ssh.Dial("tcp", net.JoinHostPort(hostAddress(host), port), cfg)
+	if err != nil {
+		return nil, err
+	}
+	go keepAlive(client)
+	return client, nil
+}
+
+// keepAlive mimics ServerAliveInterval/ServerAliveCountMax behaviour of openssh
+func keepAlive(client *ssh.Client) {
+	interval := sshOptionInt("ServerAliveInterval")
+	if interval <= 0 {
+		return
+	}
+	maxCount := sshOptionInt("ServerAliveCountMax")
+	failed := 0
+	for {
+		time.Sleep(time.Duration(interval) * time.Second)
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		if err == nil {
+			failed = 0
+			continue
+		}
+		if err == io.EOF {
+			// connection is closed already
+			return
+		}
+		failed++
+		if failed > maxCount {
+			client.Close()
+			return
+		}
+	}
+}
+
+// remoteCommandLine builds the command line the same way CreateSSHCmd passes it to ssh
+func remoteCommandLine(raise RaiseType, argv string) string {
+	params := make([]string, 0)
+	switch raise {
+	case RaiseTypeNone:
+		params = append(params, interpreter...)
+	case RaiseTypeSudo:
+		params = append(params, sudoInterpreter...)
+	case RaiseTypeSu:
+		params = append(params, suInterpreter...)
+	}
+	if argv != "" {
+		params = append(params, "-c", argv)
+	}
+	return strings.Join(params, " ")
+}
+
+func readStream(r io.Reader, otype OutputType, out chan<- *streamChunk, done <-chan bool, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		buf := make([]byte, bufferSize)
+		n, err := r.Read(buf)
+		if n > 0 {
+			select {
+			case out <- &streamChunk{buf[:n], otype}:
+			case <-done:
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (w *Worker) nativeCmd(task *Task) int {
+	var passwordSent bool
+
+	client, session, err := openSession(task.HostName, task.User)
+	if err != nil {
+		w.data <- &Output{[]byte(err.Error() + "\n"), OutputTypeStderr, task.HostName, -1}
+		return ErrConnectFailed
+	}
+	defer releaseClient(task.HostName, task.User, client)
+	defer session.Close()
+
+	// the same as "ssh -tt": su and sudo need a terminal to ask for a password
+	err = session.RequestPty("xterm", 40, 80, ssh.TerminalModes{})
+	if err != nil {
+		w.data <- &Output{[]byte(err.Error() + "\n"), OutputTypeStderr, task.HostName, -1}
+		return ErrTerminalError
+	}
+
+	stdin, _ := session.StdinPipe()
+	stdout, _ := session.StdoutPipe()
+	stderr, _ := session.StderrPipe()
+
+	// in case of RaiseNone no password is to be sent
+	passwordSent = task.Raise == RaiseTypeNone
+	shouldSkipEcho := false
+	taskForceStopped := false
+	taskTimedOut := false
+	chunkCount := 0
+
+	err = session.Start(remoteCommandLine(task.Raise, task.Cmd))
+	if err != nil {
+		w.data <- &Output{[]byte(err.Error() + "\n"), OutputTypeStderr, task.HostName, -1}
+		return ErrConnectFailed
+	}
+	log.Debugf("WRK[%d]: Native command started", w.id)
+
+	stream := make(chan *streamChunk, 16)
+	done := make(chan bool)
+	defer close(done)
+	wg := new(sync.WaitGroup)
+	wg.Add(2)
+	go readStream(stdout, OutputTypeStdout, stream, done, wg)
+	go readStream(stderr, OutputTypeStderr, stream, done, wg)
+	go func() {
+		wg.Wait()
+		close(stream)
+	}()
+
+	// nil channel blocks forever so there's no timeout by default
+	var timeout <-chan time.Time
+	if task.Timeout > 0 {
+		timer := time.NewTimer(task.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+execLoop:
+	for {
+		select {
+		case <-w.stop:
+			taskForceStopped = true
+			break execLoop
+		case <-timeout:
+			taskTimedOut = true
+			break execLoop
+		case sc, ok := <-stream:
+			if !ok {
+				log.Debugf("WRK[%d]: Both stdout and stderr on %s have finished, exiting", w.id, task.HostName)
+				break execLoop
+			}
+			w.data <- &Output{sc.data, OutputTypeDebug, task.HostName, -1}
+
+			if sc.otype == OutputTypeStdout {
+				chunkCount++
+			}
+			chunks := bytes.SplitAfter(sc.data, []byte{'\n'})
+			for _, chunk := range chunks {
+				if len(chunk) == 0 {
+					continue
+				}
+				if sc.otype == OutputTypeStdout && chunkCount < 5 {
+					if !passwordSent && ExprPasswdPrompt.Match(chunk) {
+						stdin.Write([]byte(task.Password + "\n"))
+						passwordSent = true
+						shouldSkipEcho = true
+						continue
+					}
+					if shouldSkipEcho && ExprEcho.Match(chunk) {
+						shouldSkipEcho = false
+						continue
+					}
+					if passwordSent && ExprWrongPassword.Match(chunk) {
+						w.data <- &Output{[]byte("sudo: Authentication failure\n"), OutputTypeStdout, task.HostName, -1}
+						taskForceStopped = true
+						break execLoop
+					}
+				}
+				rb := make([]byte, len(chunk))
+				copy(rb, chunk)
+				w.data <- &Output{rb, sc.otype, task.HostName, -1}
+			}
+		}
+	}
+
+	if taskForceStopped {
+		session.Signal(ssh.SIGKILL)
+		log.Debugf("WRK[%d]: Task on %s was force stopped", w.id, task.HostName)
+		return ErrForceStop
+	}
+
+	if taskTimedOut {
+		session.Signal(ssh.SIGKILL)
+		log.Debugf("WRK[%d]: Task on %s has timed out", w.id, task.HostName)
+		return ErrTimeout
+	}
+
+	exitCode := 0
+	err = session.Wait()
+	if err != nil {
+		switch e := err.(type) {
+		case *ssh.ExitError:
+			exitCode = e.ExitStatus()
+		default:
+			// the connection was dropped before the exit status has arrived
+			exitCode = ErrConnectionLost
+		}
+	}
+	log.Debugf("WRK[%d]: Task on %s exit code is %d", w.id, task.HostName, exitCode)
+	return exitCode
+}
+
+func (w *Worker) nativeCopy(task *Task) int {
+	client, session, err := openSession(task.HostName, task.User)
+	if err != nil {
+		w.data <- &Output{[]byte(err.Error() + "\n"), OutputTypeStderr, task.HostName, 0}
+		return ErrConnectFailed
+	}
+	defer releaseClient(task.HostName, task.User, client)
+	defer session.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- scpSend(session, task.LocalFilename, task.RemoteFilename)
+	}()
+
+	select {
+	case <-w.stop:
+		session.Close()
+		log.Debugf("WRK[%d]: Task on %s was force stopped", w.id, task.HostName)
+		return ErrForceStop
+	case err = <-result:
+	}
+
+	exitCode := 0
+	if err != nil {
+		w.data <- &Output{[]byte(err.Error() + "\n"), OutputTypeStderr, task.HostName, 0}
+		exitCode = 1
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			exitCode = exitErr.ExitStatus()
+		}
+	}
+	log.Debugf("WRK[%d]: Task on %s exit code is %d", w.id, task.HostName, exitCode)
+	return exitCode
+}
+
+// scpSend uploads a single file using the sink side of the scp protocol
+func scpSend(session *ssh.Session, localFilename string, remoteFilename string) error {
+	f, err := os.Open(localFilename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	acks := bufio.NewReader(stdout)
+
+	err = session.Start(fmt.Sprintf("scp -t '%s'", strings.Replace(remoteFilename, "'", `'\''`, -1)))
+	if err != nil {
+		return err
+	}
+
+	err = scpAck(acks)
+	if err == nil {
+		_, err = fmt.Fprintf(stdin, "C%04o %d %s\n", st.Mode().Perm(), st.Size(), path.Base(remoteFilename))
+	}
+	if err == nil {
+		err = scpAck(acks)
+	}
+	if err == nil {
+		_, err = io.Copy(stdin, f)
+	}
+	if err == nil {
+		_, err = stdin.Write([]byte{0})
+	}
+	if err == nil {
+		err = scpAck(acks)
+	}
+	stdin.Close()
+
+	waitErr := session.Wait()
+	if err != nil {
+		return err
+	}
+	return waitErr
+}
+
+func scpAck(r *bufio.Reader) error {
+	code, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if code == 0 {
+		return nil
+	}
+	msg, _ := r.ReadString('\n')
+	return fmt.Errorf("scp: %s", strings.TrimSpace(msg))
+}
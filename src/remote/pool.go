@@ -1,6 +1,8 @@
 package remote
 
 import (
+	"time"
+
 	"github.com/op/go-logging"
 )
 
@@ -70,18 +72,19 @@ func (p *Pool) Close() {
 
 // Copy runs copy task
 func (p *Pool) Copy(host string, user string, local string, remote string) {
-	p.CopyAndExec(host, user, local, remote, RaiseTypeNone, "", "")
+	p.CopyAndExec(host, user, local, remote, RaiseTypeNone, "", "", 0)
 }
 
 // Exec runs a simple command on a remote host
 // no quoting allowed, may unexpectedly resolve $-expressions even when quoted
-func (p *Pool) Exec(host string, user string, raise RaiseType, pwd string, cmd string) {
-	p.CopyAndExec(host, user, "", "", raise, pwd, cmd)
+func (p *Pool) Exec(host string, user string, raise RaiseType, pwd string, cmd string, timeout time.Duration) {
+	p.CopyAndExec(host, user, "", "", raise, pwd, cmd, timeout)
 }
 
 // CopyAndExec copies the file and then executes a command
-// Handy for execution just copied script
-func (p *Pool) CopyAndExec(host string, user string, local string, remote string, raise RaiseType, pwd string, cmd string) {
+// Handy for execution just copied script. Non-zero timeout limits the time
+// the command may run, the copying part is not limited
+func (p *Pool) CopyAndExec(host string, user string, local string, remote string, raise RaiseType, pwd string, cmd string, timeout time.Duration) {
 	task := &Task{
 		HostName:       host,
 		User:           user,
@@ -90,6 +93,7 @@ func (p *Pool) CopyAndExec(host string, user string, local string, remote string
 		Cmd:            cmd,
 		Raise:          raise,
 		Password:       pwd,
+		Timeout:        timeout,
 	}
 	p.queue <- task
 	log.Debugf("Created task for host %s. Local filename: %s, remote filename: %s. Cmd is %v. RaiseType is %v", host, local, remote, cmd, raise)
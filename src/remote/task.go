@@ -1,5 +1,9 @@
 package remote
 
+import (
+	"time"
+)
+
 // RaiseType is a enum of privilege raising types
 type RaiseType int
 
@@ -18,4 +22,6 @@ type Task struct {
 	Cmd            string
 	Raise          RaiseType
 	Password       string
+	// Timeout limits the time the command may run, 0 means no limit
+	Timeout time.Duration
 }
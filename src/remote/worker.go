@@ -59,6 +59,9 @@ const (
 	ErrForceStop
 	ErrCopyFailed
 	ErrTerminalError
+	ErrConnectFailed
+	ErrConnectionLost
+	ErrTimeout
 )
 
 // NewWorker creates a worker
@@ -105,7 +108,11 @@ func (w *Worker) run() {
 
 		// does task have anything to copy?
 		if task.RemoteFilename != "" && task.LocalFilename != "" {
-			result = w.copy(task)
+			if currentTransport == TransportNative {
+				result = w.nativeCopy(task)
+			} else {
+				result = w.copy(task)
+			}
 			w.data <- &Output{nil, OutputTypeCopyFinished, task.HostName, result}
 			if result != 0 {
 				// if copying failed we can't proceed further with the task
@@ -117,7 +124,11 @@ func (w *Worker) run() {
 
 		// does task have anything to run?
 		if task.Cmd != "" {
-			result = w.cmd(task)
+			if currentTransport == TransportNative {
+				result = w.nativeCmd(task)
+			} else {
+				result = w.cmd(task)
+			}
 			w.data <- &Output{nil, OutputTypeExecFinished, task.HostName, result}
 		}
 
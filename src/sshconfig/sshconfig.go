@@ -0,0 +1,352 @@
+package sshconfig
+
+import (
+	"backend"
+	"bufio"
+	"config"
+	"fmt"
+	"inventory"
+	"os"
+	"path/filepath"
+	"regexp"
+	"remote"
+	"strconv"
+	"strings"
+)
+
+// SSHConfig is a backend taking hosts from an ssh client config file.
+//
+// Every name of a Host line without wildcards is a host. Groups come
+// from two sources: a wildcard pattern like "*.db.example.com" or "web-*"
+// makes a group named after the pattern without wildcards ("db.example.com",
+// "web"), and a "# xc-groups: group1,group2" comment puts hosts of the next
+// Host block into the listed groups. User, Port and HostName settings are
+// passed to the remote package so both transports honour them, openssh
+// is given the config file itself
+type SSHConfig struct {
+	*inventory.Inventory
+	filename string
+}
+
+type block struct {
+	patterns []string
+	groups   []string
+	options  map[string]string
+	// include are the patterns compiled, exclude are the negated ones
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+type configParser struct {
+	baseDir string
+	blocks  []*block
+	current *block
+	pending []string
+	depth   int
+}
+
+const (
+	maxIncludeDepth  = 16
+	groupsAnnotation = "xc-groups:"
+)
+
+var (
+	// the options are kept, the rest are of no interest for xc
+	knownOptions = map[string]bool{
+		"user":     true,
+		"port":     true,
+		"hostname": true,
+	}
+)
+
+func init() {
+	backend.Register("sshconfig", func(xc *config.XcConfig) (backend.Backend, error) {
+		return New(xc), nil
+	})
+}
+
+// New creates an sshconfig backend from the xc configuration
+func New(xc *config.XcConfig) *SSHConfig {
+	return &SSHConfig{
+		Inventory: inventory.New(&inventory.Data{}),
+		filename:  xc.SSHConfigFile,
+	}
+}
+
+// Load reads the hosts and their settings from the config file
+func (s *SSHConfig) Load() error {
+	p, err := parseConfig(s.filename)
+	if err != nil {
+		return err
+	}
+
+	data, settings := p.data()
+	for _, hs := range settings {
+		hs.ConfigFile = s.filename
+	}
+	s.Inventory = inventory.New(data)
+	// the file identifies the backend among several sshconfig ones
+	remote.SetHostSettings("sshconfig:"+s.filename, settings)
+	return nil
+}
+
+// Reload re-reads the config file
+func (s *SSHConfig) Reload() error {
+	return s.Load()
+}
+
+// parseConfig parses the config file along with all the included ones
+func parseConfig(filename string) (*configParser, error) {
+	// options before the first Host line apply to all hosts
+	global := newBlock(nil, "*")
+	p := &configParser{baseDir: filepath.Dir(filename), blocks: []*block{global}, current: global}
+	err := p.parseFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *configParser) parseFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if line[0] == '#' {
+			comment := strings.TrimSpace(line[1:])
+			if strings.HasPrefix(comment, groupsAnnotation) {
+				for _, group := range strings.Split(comment[len(groupsAnnotation):], ",") {
+					if group = strings.TrimSpace(group); group != "" {
+						p.pending = append(p.pending, group)
+					}
+				}
+			}
+			continue
+		}
+
+		keyword, args := splitLine(line)
+		switch strings.ToLower(keyword) {
+		case "host":
+			b := newBlock(p.pending, args...)
+			p.pending = nil
+			p.blocks = append(p.blocks, b)
+			p.current = b
+
+		case "match":
+			// match conditions can't be evaluated without connecting so
+			// options of match blocks are ignored
+			p.current = nil
+
+		case "include":
+			err = p.include(args)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %s", filename, lineNum, err)
+			}
+
+		default:
+			key := strings.ToLower(keyword)
+			if p.current == nil || !knownOptions[key] || len(args) == 0 {
+				continue
+			}
+			// the first obtained value is used
+			if _, found := p.current.options[key]; !found {
+				p.current.options[key] = args[0]
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func (p *configParser) include(patterns []string) error {
+	if p.depth >= maxIncludeDepth {
+		return fmt.Errorf("too many nested includes")
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+
+	for _, pattern := range patterns {
+		pattern = expandHome(pattern)
+		if !filepath.IsAbs(pattern) {
+			// relative includes are looked up in the directory of the main config
+			pattern = filepath.Join(p.baseDir, pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			err = p.parseFile(file)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	return path
+}
+
+// splitLine splits a config line into the keyword and its arguments.
+// Both "Keyword value" and "Keyword=value" forms are allowed, arguments
+// may be quoted
+func splitLine(line string) (string, []string) {
+	ki := strings.IndexAny(line, " \t=")
+	if ki < 0 {
+		return line, nil
+	}
+	keyword := line[:ki]
+	rest := strings.TrimLeft(line[ki:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	args := make([]string, 0)
+	cur := ""
+	inQuotes := false
+	for _, sym := range rest {
+		switch {
+		case sym == '"':
+			inQuotes = !inQuotes
+		case (sym == ' ' || sym == '\t') && !inQuotes:
+			if cur != "" {
+				args = append(args, cur)
+				cur = ""
+			}
+		default:
+			cur += string(sym)
+		}
+	}
+	if cur != "" {
+		args = append(args, cur)
+	}
+	return keyword, args
+}
+
+func isWildcard(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?")
+}
+
+// patternRegexp converts an ssh pattern to a regexp
+func patternRegexp(pattern string) *regexp.Regexp {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	return regexp.MustCompile("^" + expr + "$")
+}
+
+// newBlock creates a block of a Host line. The patterns are compiled
+// once here as every block is matched against every host
+func newBlock(groups []string, patterns ...string) *block {
+	b := &block{groups: groups, options: make(map[string]string)}
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			b.exclude = append(b.exclude, patternRegexp(pattern[1:]))
+		} else {
+			b.patterns = append(b.patterns, pattern)
+			b.include = append(b.include, patternRegexp(pattern))
+		}
+	}
+	return b
+}
+
+func (b *block) match(host string) bool {
+	for _, re := range b.exclude {
+		if re.MatchString(host) {
+			return false
+		}
+	}
+	for _, re := range b.include {
+		if re.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupName derives a group name from a wildcard pattern
+func groupName(pattern string) string {
+	name := strings.NewReplacer("*", "", "?", "").Replace(pattern)
+	return strings.Trim(name, ".-_")
+}
+
+// data builds the inventory and the connection settings of concrete hosts
+func (p *configParser) data() (*inventory.Data, map[string]*remote.HostSettings) {
+	data := new(inventory.Data)
+	settings := make(map[string]*remote.HostSettings)
+
+	seen := make(map[string]bool)
+	for _, b := range p.blocks {
+		for _, pattern := range b.patterns {
+			if isWildcard(pattern) || seen[pattern] || !b.match(pattern) {
+				continue
+			}
+			seen[pattern] = true
+			data.Hosts = append(data.Hosts, &inventory.Host{FQDN: pattern, Attributes: make(map[string]string)})
+		}
+	}
+
+	for _, host := range data.Hosts {
+		options := make(map[string]string)
+		for _, b := range p.blocks {
+			if !b.match(host.FQDN) {
+				continue
+			}
+			for key, value := range b.options {
+				if _, found := options[key]; !found {
+					options[key] = value
+				}
+			}
+			host.Groups = appendUnique(host.Groups, b.groups...)
+			for _, pattern := range b.patterns {
+				if name := groupName(pattern); isWildcard(pattern) && name != "" {
+					host.Groups = appendUnique(host.Groups, name)
+				}
+			}
+		}
+
+		address := strings.Replace(options["hostname"], "%h", host.FQDN, -1)
+		hs := &remote.HostSettings{User: options["user"], Address: address}
+		if port, err := strconv.Atoi(options["port"]); err == nil {
+			hs.Port = port
+		}
+		settings[host.FQDN] = hs
+
+		for key, value := range options {
+			host.Attributes[key] = value
+		}
+		if hs.Address != "" && hs.Address != host.FQDN {
+			host.Aliases = []string{hs.Address}
+		}
+	}
+	return data, settings
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, i := range list {
+			if i == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
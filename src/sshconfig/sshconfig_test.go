@@ -0,0 +1,215 @@
+package sshconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"remote"
+	"strings"
+	"testing"
+)
+
+const mainConfig = `# xc-groups: front, critical
+Host web1 web2
+    Port 2222
+
+Host *.db.example.com
+    User dba
+
+Host db1.db.example.com db2.db.example.com !db2.db.example.com
+    HostName %h.internal
+
+Host db3.db.example.com
+    HostName=10.0.0.3
+
+Host web-*
+    User webuser
+
+Host web-a web-b !web-b
+
+Host web-b
+    Port 22
+    User ignored
+
+Include conf.d/*.conf
+
+Match host web1
+    User ignored
+
+Host *
+    User default
+`
+
+const includedConfig = `# xc-groups: extra
+Host extra1
+    Port 2200
+
+Host extra2
+`
+
+// writeConfigs writes config files into a temporary directory,
+// the names are relative to it
+func writeConfigs(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "xc-sshconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		filename := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(filename), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(filename, []byte(contents), 0644)
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestParseConfig(t *testing.T) {
+	dir, cleanup := writeConfigs(t, map[string]string{
+		"config":            mainConfig,
+		"conf.d/extra.conf": includedConfig,
+	})
+	defer cleanup()
+
+	p, err := parseConfig(filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, settings := p.data()
+
+	hosts := make([]string, 0)
+	groups := make(map[string][]string)
+	aliases := make(map[string][]string)
+	for _, h := range data.Hosts {
+		hosts = append(hosts, h.FQDN)
+		groups[h.FQDN] = h.Groups
+		aliases[h.FQDN] = h.Aliases
+	}
+
+	// negated names and wildcards are not hosts
+	expectedHosts := []string{"web1", "web2", "db1.db.example.com", "db3.db.example.com", "web-a", "web-b", "extra1", "extra2"}
+	if !reflect.DeepEqual(hosts, expectedHosts) {
+		t.Errorf("hosts expected to be %v, got %v", expectedHosts, hosts)
+	}
+
+	expectedGroups := map[string][]string{
+		"web1":               {"front", "critical"},
+		"web2":               {"front", "critical"},
+		"db1.db.example.com": {"db.example.com"},
+		"db3.db.example.com": {"db.example.com"},
+		"web-a":              {"web"},
+		"web-b":              {"web"},
+		"extra1":             {"extra"},
+		"extra2":             nil,
+	}
+	if !reflect.DeepEqual(groups, expectedGroups) {
+		t.Errorf("groups expected to be %v, got %v", expectedGroups, groups)
+	}
+
+	expectedSettings := map[string]*remote.HostSettings{
+		"web1":               {User: "default", Port: 2222},
+		"web2":               {User: "default", Port: 2222},
+		"db1.db.example.com": {User: "dba", Address: "db1.db.example.com.internal"},
+		"db3.db.example.com": {User: "dba", Address: "10.0.0.3"},
+		"web-a":              {User: "webuser"},
+		"web-b":              {User: "webuser", Port: 22},
+		"extra1":             {User: "default", Port: 2200},
+		"extra2":             {User: "default"},
+	}
+	if !reflect.DeepEqual(settings, expectedSettings) {
+		for host, hs := range settings {
+			t.Errorf("%s: %+v", host, hs)
+		}
+		t.Error("unexpected host settings")
+	}
+
+	if !reflect.DeepEqual(aliases["db3.db.example.com"], []string{"10.0.0.3"}) {
+		t.Errorf("db3.db.example.com expected to have alias 10.0.0.3, got %v", aliases["db3.db.example.com"])
+	}
+	if aliases["web1"] != nil {
+		t.Errorf("web1 is not expected to have aliases, got %v", aliases["web1"])
+	}
+}
+
+func TestIncludeDepth(t *testing.T) {
+	dir, cleanup := writeConfigs(t, map[string]string{
+		"config": "Host a\nInclude config\n",
+		"nested": "Host b\nInclude missing/*.conf\n",
+	})
+	defer cleanup()
+
+	_, err := parseConfig(filepath.Join(dir, "config"))
+	if err == nil || !strings.Contains(err.Error(), "too many nested includes") {
+		t.Errorf("recursive include expected to fail, got %v", err)
+	}
+
+	// includes matching no files are fine
+	p, err := parseConfig(filepath.Join(dir, "nested"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := p.data(); len(data.Hosts) != 1 || data.Hosts[0].FQDN != "b" {
+		t.Errorf("nested config expected to have host b only, got %v", data.Hosts)
+	}
+}
+
+func TestBlockMatch(t *testing.T) {
+	b := newBlock(nil, "web*", "db?.example.com", "!web2", "!*.test")
+	data := map[string]bool{
+		"web1":             true,
+		"web":              true,
+		"web2":             false,
+		"web3.test":        false,
+		"db1.example.com":  true,
+		"db10.example.com": false,
+		"db1Xexample.com":  false,
+		"mail":             false,
+	}
+	for host, expected := range data {
+		if b.match(host) != expected {
+			t.Errorf("%s match expected to be %v", host, expected)
+		}
+	}
+}
+
+func TestGroupName(t *testing.T) {
+	data := map[string]string{
+		"*.db.example.com": "db.example.com",
+		"web-*":            "web",
+		"*-prod-*":         "prod",
+		"db?":              "db",
+		"*":                "",
+		"?*":               "",
+	}
+	for pattern, expected := range data {
+		if name := groupName(pattern); name != expected {
+			t.Errorf("%q expected to make group %q, got %q", pattern, expected, name)
+		}
+	}
+}
+
+func TestSplitLine(t *testing.T) {
+	data := []struct {
+		line    string
+		keyword string
+		args    []string
+	}{
+		{"Host web1 web2", "Host", []string{"web1", "web2"}},
+		{"Port=22", "Port", []string{"22"}},
+		{"Port = 22", "Port", []string{"22"}},
+		{"IdentityFile \"/a b/key\"", "IdentityFile", []string{"/a b/key"}},
+		{"User\tdeploy", "User", []string{"deploy"}},
+		{"Host", "Host", nil},
+	}
+	for _, d := range data {
+		keyword, args := splitLine(d.line)
+		if keyword != d.keyword || !reflect.DeepEqual(args, d.args) {
+			t.Errorf("%q expected to be split into %q %q, got %q %q", d.line, d.keyword, d.args, keyword, args)
+		}
+	}
+}
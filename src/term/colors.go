@@ -2,6 +2,8 @@ package term
 
 import (
 	"fmt"
+	"io"
+	"os"
 )
 
 type colorValue int
@@ -25,6 +27,35 @@ const (
 	CWhite        colorValue = 97
 )
 
+var (
+	// messages is where Errorf, Successf and Warnf print to
+	messages      io.Writer = os.Stdout
+	colorMessages           = true
+)
+
+// SetMessagesToStderr makes Errorf, Successf and Warnf print to stderr
+// leaving stdout to command output only, i.e. to json records. Messages
+// printed to stderr are colored only if it's a terminal
+func SetMessagesToStderr(toStderr bool) {
+	if !toStderr {
+		messages = os.Stdout
+		colorMessages = true
+		return
+	}
+	messages = os.Stderr
+	colorMessages = false
+	if st, err := os.Stderr.Stat(); err == nil {
+		colorMessages = st.Mode()&os.ModeCharDevice != 0
+	}
+}
+
+func printMessage(msg string, c colorValue) {
+	if colorMessages {
+		msg = Colored(msg, c, false)
+	}
+	fmt.Fprint(messages, msg)
+}
+
 func Colored(msg string, c colorValue, bold bool) string {
 	bstr := ""
 	if bold {
@@ -54,16 +85,13 @@ func Cyan(msg string) string {
 }
 
 func Errorf(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	fmt.Print(Red(msg))
+	printMessage(fmt.Sprintf(format, args...), CLightRed)
 }
 
 func Successf(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	fmt.Print(Green(msg))
+	printMessage(fmt.Sprintf(format, args...), CLightGreen)
 }
 
 func Warnf(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	fmt.Print(Yellow(msg))
+	printMessage(fmt.Sprintf(format, args...), CLightYellow)
 }
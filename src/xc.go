@@ -4,43 +4,150 @@ import (
 	"backend"
 	"cli"
 	"config"
+	"flag"
 	"fmt"
 	"os"
 	"path"
 	"strings"
 	"term"
+
+	"github.com/chzyer/readline"
+
+	// backends register themselves on import
+	_ "ansible"
+	_ "conductor"
+	_ "external"
+	_ "localfile"
+	_ "sshconfig"
 )
 
-func main() {
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] [command [args...]]\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Runs the command given, the commands of a batch file or the ones read from stdin\n")
+	fmt.Fprintf(os.Stderr, "if it's not a terminal. Without any of them an interactive shell is started.\n")
+	fmt.Fprintf(os.Stderr, "A command can't be given along with a batch file.\n\n")
+	fmt.Fprintf(os.Stderr, "Options:\n")
+	flag.PrintDefaults()
+}
+
+// selectBackend leaves the only backend in the configuration, it's
+// given by its name in main.backends or by its type
+func selectBackend(xc *config.XcConfig, name string) error {
+	for _, nb := range xc.Backends {
+		if nb.Name == name {
+			xc.Backends = []*config.NamedBackend{nb}
+			return nil
+		}
+	}
+	for _, bt := range backend.Registered() {
+		if bt == name {
+			xc.Backends = nil
+			xc.BackendType = name
+			return nil
+		}
+	}
+	return fmt.Errorf("there's neither backend nor backend type named %s", name)
+}
+
+func run() int {
+	configFilename := flag.String("c", path.Join(os.Getenv("HOME"), ".xc.conf"), "configuration `file`")
+	backendName := flag.String("b", "", "backend `name` from main.backends or backend type to use")
+	mode := flag.String("m", "", "execution `mode`: serial, parallel, collapse or rolling")
+	user := flag.String("u", "", "remote `user`")
+	raise := flag.String("r", "", "privilege raise `type`: none, su or sudo")
+	batchFilename := flag.String("f", "", "run commands from the `file`, - stands for stdin")
+	yes := flag.Bool("y", false, "answer yes to confirmations")
+	flag.Usage = usage
+	flag.Parse()
 
-	configFilename := path.Join(os.Getenv("HOME"), ".xc.conf")
-	xc, err := config.ReadConfig(configFilename)
+	if *batchFilename != "" && flag.NArg() > 0 {
+		term.Errorf("A command can't be given along with a batch file\n")
+		return cli.ExitError
+	}
+
+	// the default configuration file is created if it's missing,
+	// the one given explicitly must exist
+	configGiven := false
+	flag.Visit(func(f *flag.Flag) { configGiven = configGiven || f.Name == "c" })
+	if configGiven {
+		if _, err := os.Stat(*configFilename); err != nil {
+			term.Errorf("Error reading config: %s\n", err)
+			return cli.ExitError
+		}
+	}
+
+	xc, err := config.ReadConfig(*configFilename)
 	if err != nil {
 		term.Errorf("Error reading config: %s\n", err)
-		return
+		return cli.ExitError
+	}
+
+	if *backendName != "" {
+		err = selectBackend(xc, *backendName)
+		if err != nil {
+			term.Errorf("%s\n", err)
+			return cli.ExitError
+		}
 	}
 
 	bknd, err := backend.NewBackend(xc)
 	if err != nil {
-		fmt.Println(err)
+		term.Errorf("Error creating backend: %s\n", err)
+		return cli.ExitError
 	}
 
+	// expired data is refreshed in background in the interactive shell only,
+	// a single command or a batch must not run against it
+	interactive := *batchFilename == "" && flag.NArg() == 0 && readline.IsTerminal(int(os.Stdin.Fd()))
+	if cached, ok := bknd.(backend.Cached); ok {
+		cached.SetBackgroundRefresh(interactive)
+	}
+
+	// the interactive shell is still useful without the data
+	// as it may be reloaded, commands would fail without it
 	err = bknd.Load()
 	if err != nil {
-		fmt.Println(err)
+		term.Errorf("%s\n", err)
+		if !interactive {
+			return cli.ExitError
+		}
 	}
 
-	c, err := cli.NewCli(xc, bknd)
+	opts := &cli.Options{Mode: *mode, User: *user, Raise: *raise, Yes: *yes}
+	c, err := cli.NewCli(xc, bknd, opts)
 	if err != nil {
-		println(err)
-		return
+		term.Errorf("Error creating cli: %s\n", err)
+		return cli.ExitError
 	}
+	defer c.Finalize()
 
-	if len(os.Args) < 2 {
+	switch {
+	case *batchFilename == "-":
+		err = c.RunBatch(os.Stdin)
+	case *batchFilename != "":
+		var f *os.File
+		f, err = os.Open(*batchFilename)
+		if err != nil {
+			break
+		}
+		defer f.Close()
+		err = c.RunBatch(f)
+	case flag.NArg() > 0:
+		c.OneCmd(strings.Join(flag.Args(), " "))
+	case !interactive:
+		err = c.RunBatch(os.Stdin)
+	default:
 		c.CmdLoop()
-	} else {
-		cmd := strings.Join(os.Args[1:], " ")
-		c.OneCmd(cmd)
+		return cli.ExitOK
 	}
-	c.Finalize()
+
+	if err != nil {
+		term.Errorf("Error reading commands: %s\n", err)
+		return cli.ExitError
+	}
+	return c.ExitCode()
+}
+
+func main() {
+	os.Exit(run())
 }